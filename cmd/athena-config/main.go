@@ -0,0 +1,86 @@
+// athena-config 生成或还原配置中的 ENC[aes256:...] 密文
+//
+//	athena-config encrypt [-key-file path] [value]
+//	athena-config decrypt [-key-file path] [value]
+//
+// 未指定value时从标准输入读取，密钥默认取自 CONFIG_SECRET_KEY 或 CONFIG_SECRET_KEY_FILE
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/rickone/athena/config"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s encrypt|decrypt [-key-file path] [value]\n", os.Args[0])
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cmd := os.Args[1]
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "secret key file, overrides "+config.SecretKeyEnv)
+	fs.Parse(os.Args[2:])
+
+	key, err := loadKey(*keyFile)
+	if err != nil {
+		fail(err)
+	}
+
+	value, err := readValue(fs.Args())
+	if err != nil {
+		fail(err)
+	}
+
+	var result string
+	switch cmd {
+	case "encrypt":
+		result, err = config.Encrypt(value, key)
+	case "decrypt":
+		result, err = config.Decrypt(value, key)
+	default:
+		usage()
+	}
+	if err != nil {
+		fail(err)
+	}
+	fmt.Println(result)
+}
+
+func loadKey(keyFile string) ([]byte, error) {
+	if keyFile == "" {
+		return config.LoadSecretKey()
+	}
+
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(data), nil
+}
+
+func readValue(args []string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(data, "\r\n")), nil
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+	os.Exit(1)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/binary"
@@ -41,6 +42,12 @@ func Sha256Sign(strs ...string) string {
 	return Sha256Hash(strings.Join(strs, ""))
 }
 
+func HmacSha256(data, key []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
 func GetEntityType(id int64) int32 {
 	return int32((id >> 12) & mark4)
 }
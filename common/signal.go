@@ -6,8 +6,9 @@ import (
 	"syscall"
 )
 
+// Deprecated: 使用lifecycle.Manager.Run，按阶段停止并返回错误
 func OnSigQuit(handler func()) {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT, syscall.SIGTSTP, syscall.SIGQUIT)
 
 	go func() {
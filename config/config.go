@@ -1,6 +1,7 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -12,8 +13,9 @@ import (
 )
 
 var (
-	kvs = map[interface{}]interface{}{}
-	mu  = sync.RWMutex{}
+	kvs         = map[interface{}]interface{}{}
+	mu          = sync.RWMutex{}
+	watchCancel context.CancelFunc
 )
 
 func Init(path ...string) {
@@ -35,10 +37,30 @@ func Init(path ...string) {
 	data, err := ioutil.ReadFile(configPath)
 	common.AssertError(err)
 
-	err = yaml.Unmarshal(data, &kvs)
+	file := map[interface{}]interface{}{}
+	err = yaml.Unmarshal(data, &file)
 	common.AssertError(err)
 
-	go Watch()
+	err = initLayers(file)
+	common.AssertError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watchMu.Lock()
+	watchCancel = cancel
+	watchMu.Unlock()
+
+	go Watch(ctx)
+}
+
+// StopWatch 停止Init启动的配置监听
+func StopWatch() {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+
+	if watchCancel != nil {
+		watchCancel()
+		watchCancel = nil
+	}
 }
 
 type Value struct {
@@ -238,7 +260,16 @@ func GetString(fields ...interface{}) string {
 	if v == nil {
 		return ""
 	}
-	return v.val.(string)
+
+	switch s := v.val.(type) {
+	case int:
+		return strconv.Itoa(s)
+	case int64:
+		return strconv.FormatInt(s, 10)
+	case string:
+		return s
+	}
+	return ""
 }
 
 func updateValue(node map[interface{}]interface{}, key interface{}, val interface{}) {
@@ -258,9 +289,10 @@ func updateValue(node map[interface{}]interface{}, key interface{}, val interfac
 	}
 }
 
-func UpdateValue(key interface{}, val interface{}) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	updateValue(kvs, key, val)
+// UpdateValue 合并到consul层，不会覆盖环境变量和命令行参数
+// 合并结果未通过校验时整体拒绝并返回错误
+func UpdateValue(key interface{}, val interface{}) error {
+	return update(LayerConsul, func(ls map[string]map[interface{}]interface{}) {
+		updateValue(getLayer(ls, LayerConsul), key, deepCopy(val))
+	})
 }
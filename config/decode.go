@@ -0,0 +1,411 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// DecodeError 汇总一次解码中所有缺失或类型错误的键
+type DecodeError struct {
+	Errors []string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("config: %d error(s) decoding:\n* %s", len(e.Errors), strings.Join(e.Errors, "\n* "))
+}
+
+// Unmarshal 将path下的配置子树解码到target(结构体指针)
+//   tag `config:"name,required"` 指定键名及是否必填，`config:"-"` 跳过
+//   tag `default:"value"` 键不存在时的默认值，按YAML解析
+// 未指定键名时使用字段名的snake_case形式，time.Duration支持"3s"或整数秒
+func Unmarshal(target interface{}, path ...interface{}) error {
+	v := GetValue(path...)
+	if v == nil {
+		v = &Value{}
+	}
+	return v.decode(target, joinPath(path))
+}
+
+func (v *Value) Decode(target interface{}) error {
+	return v.decode(target, "")
+}
+
+func (v *Value) decode(target interface{}, root string) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("config: decode target must be a non-nil pointer, got %T", target)
+	}
+
+	var val interface{}
+	if v != nil {
+		val = v.val
+	}
+
+	d := &decoder{}
+	d.decode(root, val, rv.Elem())
+	if len(d.errs) > 0 {
+		return &DecodeError{Errors: d.errs}
+	}
+	return nil
+}
+
+type decoder struct {
+	errs []string
+}
+
+func (d *decoder) errorf(path string, format string, args ...interface{}) {
+	if path == "" {
+		path = "<root>"
+	}
+	d.errs = append(d.errs, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+}
+
+func (d *decoder) decode(path string, val interface{}, rv reflect.Value) {
+	if val == nil {
+		// 缺失的结构体按空map解码，以便应用默认值并检查必填
+		if rv.Kind() == reflect.Struct {
+			d.decodeStruct(path, map[interface{}]interface{}{}, rv)
+		}
+		return
+	}
+
+	if rv.Type() == durationType {
+		d.decodeDuration(path, val, rv)
+		return
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		d.decode(path, val, rv.Elem())
+	case reflect.Interface:
+		if !reflect.TypeOf(val).AssignableTo(rv.Type()) {
+			d.errorf(path, "cannot assign %T to %s", val, rv.Type())
+			return
+		}
+		rv.Set(reflect.ValueOf(val))
+	case reflect.Struct:
+		d.decodeStruct(path, val, rv)
+	case reflect.Map:
+		d.decodeMap(path, val, rv)
+	case reflect.Slice:
+		d.decodeSlice(path, val, rv)
+	case reflect.String:
+		d.decodeString(path, val, rv)
+	case reflect.Bool:
+		d.decodeBool(path, val, rv)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		d.decodeInt(path, val, rv)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		d.decodeUint(path, val, rv)
+	case reflect.Float32, reflect.Float64:
+		d.decodeFloat(path, val, rv)
+	default:
+		d.errorf(path, "unsupported type %s", rv.Type())
+	}
+}
+
+func (d *decoder) decodeStruct(path string, val interface{}, rv reflect.Value) {
+	m, ok := val.(map[interface{}]interface{})
+	if !ok {
+		d.errorf(path, "expected map, got %T", val)
+		return
+	}
+
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		if ft.PkgPath != "" {
+			continue
+		}
+
+		name, required := parseTag(ft.Tag.Get("config"))
+		if name == "-" {
+			continue
+		}
+
+		if ft.Anonymous && name == "" && ft.Type.Kind() == reflect.Struct {
+			d.decodeStruct(path, val, rv.Field(i))
+			continue
+		}
+
+		if name == "" {
+			name = snakeCase(ft.Name)
+		}
+		fieldPath := joinPath([]interface{}{path, name})
+
+		fv, ok := lookupKey(m, name)
+		if !ok || fv == nil {
+			def, hasDefault := ft.Tag.Lookup("default")
+			if hasDefault {
+				var dv interface{}
+				if err := yaml.Unmarshal([]byte(def), &dv); err != nil {
+					d.errorf(fieldPath, "invalid default %q: %v", def, err)
+					continue
+				}
+				if dv == nil {
+					dv = def
+				}
+				d.decode(fieldPath, dv, rv.Field(i))
+			} else if required {
+				d.errorf(fieldPath, "required")
+			} else {
+				d.decode(fieldPath, nil, rv.Field(i))
+			}
+			continue
+		}
+
+		d.decode(fieldPath, fv, rv.Field(i))
+	}
+}
+
+func (d *decoder) decodeMap(path string, val interface{}, rv reflect.Value) {
+	m, ok := val.(map[interface{}]interface{})
+	if !ok {
+		d.errorf(path, "expected map, got %T", val)
+		return
+	}
+
+	t := rv.Type()
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMapWithSize(t, len(m)))
+	}
+
+	for k, v := range m {
+		elemPath := joinPath([]interface{}{path, k})
+
+		key := reflect.New(t.Key()).Elem()
+		n := len(d.errs)
+		d.decode(elemPath, k, key)
+		if len(d.errs) > n {
+			continue
+		}
+
+		elem := reflect.New(t.Elem()).Elem()
+		d.decode(elemPath, v, elem)
+		rv.SetMapIndex(key, elem)
+	}
+}
+
+func (d *decoder) decodeSlice(path string, val interface{}, rv reflect.Value) {
+	s, ok := val.([]interface{})
+	if !ok {
+		d.errorf(path, "expected list, got %T", val)
+		return
+	}
+
+	slice := reflect.MakeSlice(rv.Type(), len(s), len(s))
+	for i, v := range s {
+		d.decode(fmt.Sprintf("%s[%d]", path, i), v, slice.Index(i))
+	}
+	rv.Set(slice)
+}
+
+func (d *decoder) decodeString(path string, val interface{}, rv reflect.Value) {
+	switch s := val.(type) {
+	case string:
+		rv.SetString(s)
+	case int, int64, uint64, float64, bool:
+		rv.SetString(fmt.Sprint(s))
+	default:
+		d.errorf(path, "expected string, got %T", val)
+	}
+}
+
+func (d *decoder) decodeBool(path string, val interface{}, rv reflect.Value) {
+	switch b := val.(type) {
+	case bool:
+		rv.SetBool(b)
+	case int:
+		rv.SetBool(b != 0)
+	case string:
+		pb, err := strconv.ParseBool(b)
+		if err != nil {
+			d.errorf(path, "expected bool, got string")
+			return
+		}
+		rv.SetBool(pb)
+	default:
+		d.errorf(path, "expected bool, got %T", val)
+	}
+}
+
+func (d *decoder) decodeInt(path string, val interface{}, rv reflect.Value) {
+	var i int64
+	switch n := val.(type) {
+	case int:
+		i = int64(n)
+	case int64:
+		i = n
+	case uint64:
+		if n > math.MaxInt64 {
+			d.errorf(path, "value %d overflows %s", n, rv.Type())
+			return
+		}
+		i = int64(n)
+	case float64:
+		if n != math.Trunc(n) {
+			d.errorf(path, "expected integer, got %v", n)
+			return
+		}
+		i = int64(n)
+	case string:
+		pi, err := strconv.ParseInt(n, 10, 64)
+		if err != nil {
+			d.errorf(path, "expected integer, got string")
+			return
+		}
+		i = pi
+	default:
+		d.errorf(path, "expected integer, got %T", val)
+		return
+	}
+
+	if rv.OverflowInt(i) {
+		d.errorf(path, "value %d overflows %s", i, rv.Type())
+		return
+	}
+	rv.SetInt(i)
+}
+
+func (d *decoder) decodeUint(path string, val interface{}, rv reflect.Value) {
+	var u uint64
+	switch n := val.(type) {
+	case int:
+		if n < 0 {
+			d.errorf(path, "expected unsigned integer, got %d", n)
+			return
+		}
+		u = uint64(n)
+	case int64:
+		if n < 0 {
+			d.errorf(path, "expected unsigned integer, got %d", n)
+			return
+		}
+		u = uint64(n)
+	case uint64:
+		u = n
+	case string:
+		pu, err := strconv.ParseUint(n, 10, 64)
+		if err != nil {
+			d.errorf(path, "expected unsigned integer, got string")
+			return
+		}
+		u = pu
+	default:
+		d.errorf(path, "expected unsigned integer, got %T", val)
+		return
+	}
+
+	if rv.OverflowUint(u) {
+		d.errorf(path, "value %d overflows %s", u, rv.Type())
+		return
+	}
+	rv.SetUint(u)
+}
+
+func (d *decoder) decodeFloat(path string, val interface{}, rv reflect.Value) {
+	switch n := val.(type) {
+	case int:
+		rv.SetFloat(float64(n))
+	case int64:
+		rv.SetFloat(float64(n))
+	case uint64:
+		rv.SetFloat(float64(n))
+	case float64:
+		rv.SetFloat(n)
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			d.errorf(path, "expected float, got string")
+			return
+		}
+		rv.SetFloat(f)
+	default:
+		d.errorf(path, "expected float, got %T", val)
+	}
+}
+
+func (d *decoder) decodeDuration(path string, val interface{}, rv reflect.Value) {
+	switch n := val.(type) {
+	case int:
+		rv.SetInt(int64(time.Duration(n) * time.Second))
+	case int64:
+		rv.SetInt(int64(time.Duration(n) * time.Second))
+	case float64:
+		rv.SetInt(int64(n * float64(time.Second)))
+	case string:
+		dur, err := time.ParseDuration(n)
+		if err != nil {
+			d.errorf(path, "expected duration, got string")
+			return
+		}
+		rv.SetInt(int64(dur))
+	default:
+		d.errorf(path, "expected duration, got %T", val)
+	}
+}
+
+func parseTag(tag string) (name string, required bool) {
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	for _, opt := range parts[1:] {
+		if opt == "required" {
+			required = true
+		}
+	}
+	return
+}
+
+// lookupKey 按字符串形式匹配键，兼容YAML中的整数键
+func lookupKey(m map[interface{}]interface{}, name string) (interface{}, bool) {
+	if v, ok := m[name]; ok {
+		return v, true
+	}
+	for k, v := range m {
+		if fmt.Sprint(k) == name {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func joinPath(path []interface{}) string {
+	ss := make([]string, 0, len(path))
+	for _, p := range path {
+		s := fmt.Sprint(p)
+		if s != "" {
+			ss = append(ss, s)
+		}
+	}
+	return strings.Join(ss, ".")
+}
+
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var sb strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				sb.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rickone/athena/common"
+	"gopkg.in/yaml.v2"
+)
+
+type testRedisConf struct {
+	Address string `config:",required"`
+	Auth    string
+	DB      int           `config:"db" default:"0"`
+	Timeout time.Duration `default:"3s"`
+}
+
+type testServiceConf struct {
+	Name     string `config:",required"`
+	Redis    map[string]*testRedisConf
+	Tags     []string
+	MaxConns int    `default:"16"`
+	Ignored  string `config:"-"`
+	Tokens   map[int]struct {
+		Contract string
+		Decimals int32
+	} `config:"token"`
+}
+
+func loadTestValue(t *testing.T, data string) *Value {
+	val := map[interface{}]interface{}{}
+	common.AssertErrorT(t, yaml.Unmarshal([]byte(data), &val))
+	return &Value{val: val}
+}
+
+func TestDecode(t *testing.T) {
+	v := loadTestValue(t, `
+name: wallet
+ignored: yes
+redis:
+  mutex:
+    address: 127.0.0.1:6379
+    timeout: 500ms
+  cache:
+    address: 127.0.0.1:6380
+    db: 2
+    timeout: 5
+tags: [a, b]
+token:
+  2:
+    contract: 0xdac17f958d2ee523a2206206994597c13d831ec7
+    decimals: 6
+`)
+
+	var conf testServiceConf
+	common.AssertErrorT(t, v.Decode(&conf))
+
+	common.AssertEqualT(t, conf.Name, "wallet")
+	common.AssertEqualT(t, conf.Ignored, "")
+	common.AssertEqualT(t, conf.MaxConns, 16)
+	common.AssertEqualT(t, conf.Tags, []string{"a", "b"})
+	common.AssertEqualT(t, conf.Redis["mutex"].Timeout, 500*time.Millisecond)
+	common.AssertEqualT(t, conf.Redis["mutex"].DB, 0)
+	common.AssertEqualT(t, conf.Redis["cache"].Timeout, 5*time.Second)
+	common.AssertEqualT(t, conf.Redis["cache"].DB, 2)
+	common.AssertEqualT(t, conf.Tokens[2].Decimals, int32(6))
+}
+
+func TestDecodeErrors(t *testing.T) {
+	v := loadTestValue(t, `
+redis:
+  mutex:
+    db: abc
+    timeout: forever
+tags: oops
+`)
+
+	var conf testServiceConf
+	err := v.Decode(&conf)
+	de, ok := err.(*DecodeError)
+	if !ok {
+		t.Fatalf("expected *DecodeError, got %v", err)
+	}
+
+	msg := de.Error()
+	for _, want := range []string{
+		"name: required",
+		"redis.mutex.address: required",
+		"redis.mutex.db: expected integer",
+		"redis.mutex.timeout: expected duration",
+		"tags: expected list",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("error %q missing %q", msg, want)
+		}
+	}
+	common.AssertEqualT(t, len(de.Errors), 5)
+}
+
+type testNestedConf struct {
+	Limit  int `default:"8"`
+	Server struct {
+		Port    int           `default:"8080"`
+		Timeout time.Duration `default:"2s"`
+	}
+	Backup *testRedisConf
+}
+
+func TestDecodeMissing(t *testing.T) {
+	// 缺失整个配置段时也应用默认值
+	var conf testNestedConf
+	common.AssertErrorT(t, (&Value{}).Decode(&conf))
+	common.AssertEqualT(t, conf.Limit, 8)
+	common.AssertEqualT(t, conf.Server.Port, 8080)
+	common.AssertEqualT(t, conf.Server.Timeout, 2*time.Second)
+	if conf.Backup != nil {
+		t.Fatalf("absent pointer allocated: %+v", conf.Backup)
+	}
+
+	// 缺失嵌套结构体
+	conf = testNestedConf{}
+	common.AssertErrorT(t, loadTestValue(t, "limit: 3").Decode(&conf))
+	common.AssertEqualT(t, conf.Limit, 3)
+	common.AssertEqualT(t, conf.Server.Port, 8080)
+	common.AssertEqualT(t, conf.Server.Timeout, 2*time.Second)
+
+	// 缺失的配置段仍检查必填
+	var redis testRedisConf
+	err := Unmarshal(&redis, "test_decode_missing")
+	if err == nil || !strings.Contains(err.Error(), "test_decode_missing.address: required") {
+		t.Fatalf("expected required error, got %v", err)
+	}
+	common.AssertEqualT(t, redis.Timeout, 3*time.Second)
+}
+
+func TestSnakeCase(t *testing.T) {
+	common.AssertEqualT(t, snakeCase("UploadMaxSize"), "upload_max_size")
+	common.AssertEqualT(t, snakeCase("UserID"), "user_id")
+	common.AssertEqualT(t, snakeCase("APIUrl"), "api_url")
+	common.AssertEqualT(t, snakeCase("Address"), "address")
+}
@@ -0,0 +1,288 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// 配置分层，后者覆盖前者：
+//   file   ./conf/<ENV>.yml
+//   consul Consul KV推送 (见Watch)
+//   env    环境变量，如 ATHENA_REDIS__MUTEX__ADDRESS=127.0.0.1:6379
+//   flag   命令行参数，如 --set redis.mutex.address=127.0.0.1:6379
+const (
+	LayerFile   = "file"
+	LayerConsul = "consul"
+	LayerEnv    = "env"
+	LayerFlag   = "flag"
+
+	envPrefix    = "ATHENA_"
+	envSeparator = "__"
+	flagName     = "set"
+)
+
+var (
+	layerOrder = []string{LayerFile, LayerConsul, LayerEnv, LayerFlag}
+	layers     = map[string]map[interface{}]interface{}{}
+)
+
+// merge 按优先级合并各层，返回新的有效配置
+func merge(ls map[string]map[interface{}]interface{}) map[interface{}]interface{} {
+	result := map[interface{}]interface{}{}
+	for _, name := range layerOrder {
+		for k, v := range ls[name] {
+			updateValue(result, k, deepCopy(v))
+		}
+	}
+	return result
+}
+
+func copyLayers() map[string]map[interface{}]interface{} {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	ls := make(map[string]map[interface{}]interface{}, len(layers))
+	for name, layer := range layers {
+		ls[name] = deepCopy(layer).(map[interface{}]interface{})
+	}
+	return ls
+}
+
+func getLayer(ls map[string]map[interface{}]interface{}, name string) map[interface{}]interface{} {
+	layer := ls[name]
+	if layer == nil {
+		layer = map[interface{}]interface{}{}
+		ls[name] = layer
+	}
+	return layer
+}
+
+func setLayer(name string, layer map[interface{}]interface{}) error {
+	return update(name, func(ls map[string]map[interface{}]interface{}) {
+		ls[name] = layer
+	})
+}
+
+// setOverride 以点分路径覆盖某一层的单个值，值按YAML标量解析
+func setOverride(name string, fields []interface{}, raw string) error {
+	return update(name, func(ls map[string]map[interface{}]interface{}) {
+		setPath(getLayer(ls, name), fields, parseScalar(raw))
+	})
+}
+
+func setPath(node map[interface{}]interface{}, fields []interface{}, val interface{}) {
+	for i, field := range fields {
+		if i == len(fields)-1 {
+			node[field] = val
+			return
+		}
+
+		inner, ok := node[field].(map[interface{}]interface{})
+		if !ok {
+			inner = map[interface{}]interface{}{}
+			node[field] = inner
+		}
+		node = inner
+	}
+}
+
+func lookup(node interface{}, fields []interface{}) (interface{}, bool) {
+	for _, field := range fields {
+		switch val := node.(type) {
+		case map[interface{}]interface{}:
+			v, ok := val[field]
+			if !ok {
+				return nil, false
+			}
+			node = v
+		case []interface{}:
+			i, ok := field.(int)
+			if !ok || i < 0 || i >= len(val) {
+				return nil, false
+			}
+			node = val[i]
+		default:
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+func deepCopy(val interface{}) interface{} {
+	switch t := val.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(t))
+		for k, v := range t {
+			m[k] = deepCopy(v)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(t))
+		for i, v := range t {
+			s[i] = deepCopy(v)
+		}
+		return s
+	default:
+		return val
+	}
+}
+
+// parseKey 数字路径段转为int，与YAML中的整数键保持一致
+func parseKey(s string) interface{} {
+	if i, err := strconv.Atoi(s); err == nil {
+		return i
+	}
+	return s
+}
+
+func parseScalar(raw string) interface{} {
+	var val interface{}
+	if err := yaml.Unmarshal([]byte(raw), &val); err != nil || val == nil {
+		return raw
+	}
+	if _, ok := val.(map[interface{}]interface{}); ok {
+		return raw
+	}
+	return val
+}
+
+func splitPath(path string, sep string) []interface{} {
+	ss := strings.Split(path, sep)
+	fields := make([]interface{}, len(ss))
+	for i, s := range ss {
+		fields[i] = parseKey(s)
+	}
+	return fields
+}
+
+func loadEnv(environ []string) map[interface{}]interface{} {
+	layer := map[interface{}]interface{}{}
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+
+		ss := strings.SplitN(kv[len(envPrefix):], "=", 2)
+		if len(ss) != 2 || ss[0] == "" {
+			continue
+		}
+		setPath(layer, splitPath(strings.ToLower(ss[0]), envSeparator), parseScalar(ss[1]))
+	}
+	return layer
+}
+
+func loadArgs(args []string) map[interface{}]interface{} {
+	layer := map[interface{}]interface{}{}
+	for i := 0; i < len(args); i++ {
+		arg := strings.TrimLeft(args[i], "-")
+		if arg == args[i] {
+			continue
+		}
+
+		var kv string
+		if arg == flagName && i+1 < len(args) {
+			i++
+			kv = args[i]
+		} else if strings.HasPrefix(arg, flagName+"=") {
+			kv = arg[len(flagName)+1:]
+		} else {
+			continue
+		}
+
+		ss := strings.SplitN(kv, "=", 2)
+		if len(ss) != 2 || ss[0] == "" {
+			continue
+		}
+		setPath(layer, splitPath(ss[0], "."), parseScalar(ss[1]))
+	}
+	return layer
+}
+
+type flagValue struct{}
+
+func (f flagValue) String() string {
+	return ""
+}
+
+func (f flagValue) Set(kv string) error {
+	ss := strings.SplitN(kv, "=", 2)
+	if len(ss) != 2 || ss[0] == "" {
+		return fmt.Errorf("expected key.path=value, got %q", kv)
+	}
+	return setOverride(LayerFlag, splitPath(ss[0], "."), ss[1])
+}
+
+// RegisterFlags 在fs上注册可重复的 -set key.path=value 参数，
+// 使用flag包解析参数的服务需调用，避免未知参数报错
+func RegisterFlags(fs *flag.FlagSet) {
+	fs.Var(flagValue{}, flagName, "override config value, e.g. -set redis.mutex.address=127.0.0.1:6379")
+}
+
+func initLayers(file map[interface{}]interface{}) error {
+	return update(LayerFile, func(ls map[string]map[interface{}]interface{}) {
+		ls[LayerFile] = file
+		ls[LayerEnv] = loadEnv(os.Environ())
+		flags := loadArgs(os.Args[1:])
+		for k, v := range ls[LayerFlag] {
+			updateValue(flags, k, v)
+		}
+		ls[LayerFlag] = flags
+	})
+}
+
+// Origin 返回路径上的有效值来自哪一层，不存在时返回""
+func Origin(fields ...interface{}) string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return origin(fields)
+}
+
+func origin(fields []interface{}) string {
+	for i := len(layerOrder) - 1; i >= 0; i-- {
+		if _, ok := lookup(layers[layerOrder[i]], fields); ok {
+			return layerOrder[i]
+		}
+	}
+	return ""
+}
+
+// Origins 列出所有叶子节点的点分路径及其来源层
+func Origins() map[string]string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	result := map[string]string{}
+	walkLeaves(kvs, nil, func(fields []interface{}, val interface{}) {
+		result[joinPath(fields)] = origin(fields)
+	})
+	return result
+}
+
+func walkLeaves(node interface{}, fields []interface{}, f func(fields []interface{}, val interface{})) {
+	m, ok := node.(map[interface{}]interface{})
+	if !ok {
+		f(fields, node)
+		return
+	}
+
+	keys := make([]interface{}, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+
+	for _, k := range keys {
+		sub := make([]interface{}, len(fields), len(fields)+1)
+		copy(sub, fields)
+		walkLeaves(m[k], append(sub, k), f)
+	}
+}
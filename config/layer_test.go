@@ -0,0 +1,69 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/rickone/athena/common"
+)
+
+func resetLayers(file map[interface{}]interface{}) {
+	mu.Lock()
+	layers = map[string]map[interface{}]interface{}{LayerFile: file}
+	kvs = merge(layers)
+	mu.Unlock()
+}
+
+func TestLayerPrecedence(t *testing.T) {
+	resetLayers(map[interface{}]interface{}{
+		"service": map[interface{}]interface{}{
+			"consul": "127.0.0.1:8500",
+			"test":   ":8801",
+		},
+		"redis": map[interface{}]interface{}{
+			"mutex": map[interface{}]interface{}{
+				"address": "127.0.0.1:6379",
+				"auth":    "file",
+			},
+		},
+	})
+
+	UpdateValue("redis", map[interface{}]interface{}{
+		"mutex": map[interface{}]interface{}{"auth": "consul", "db": 1},
+	})
+	setLayer(LayerEnv, loadEnv([]string{
+		"PATH=/bin",
+		"ATHENA_SERVICE__CONSUL=10.0.0.1:8500",
+		"ATHENA_REDIS__MUTEX__DB=2",
+	}))
+	setLayer(LayerFlag, loadArgs([]string{"-v", "--set", "redis.mutex.db=3", "--set=service.test=:9901"}))
+
+	common.AssertEqualT(t, GetString("service", "consul"), "10.0.0.1:8500")
+	common.AssertEqualT(t, GetString("service", "test"), ":9901")
+	common.AssertEqualT(t, GetString("redis", "mutex", "address"), "127.0.0.1:6379")
+	common.AssertEqualT(t, GetString("redis", "mutex", "auth"), "consul")
+	common.AssertEqualT(t, GetInt("redis", "mutex", "db"), int64(3))
+
+	common.AssertEqualT(t, Origin("redis", "mutex", "address"), LayerFile)
+	common.AssertEqualT(t, Origin("redis", "mutex", "auth"), LayerConsul)
+	common.AssertEqualT(t, Origin("service", "consul"), LayerEnv)
+	common.AssertEqualT(t, Origin("redis", "mutex", "db"), LayerFlag)
+	common.AssertEqualT(t, Origin("nothing"), "")
+
+	origins := Origins()
+	common.AssertEqualT(t, origins["service.test"], LayerFlag)
+	common.AssertEqualT(t, origins["redis.mutex.address"], LayerFile)
+	common.AssertEqualT(t, len(origins), 5)
+}
+
+func TestFlagValue(t *testing.T) {
+	resetLayers(map[interface{}]interface{}{})
+
+	f := flagValue{}
+	common.AssertErrorT(t, f.Set("eth.token.2.decimals=6"))
+	if f.Set("broken") == nil {
+		t.Fatal("expected error for missing '='")
+	}
+
+	common.AssertEqualT(t, GetInt("eth", "token", 2, "decimals"), int64(6))
+	common.AssertEqualT(t, Origin("eth", "token", 2, "decimals"), LayerFlag)
+}
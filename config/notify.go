@@ -0,0 +1,106 @@
+package config
+
+import (
+	"reflect"
+	"runtime/debug"
+	"sync"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/sirupsen/logrus"
+)
+
+type subscriber struct {
+	fields []interface{}
+	f      func(old, new *Value)
+}
+
+var (
+	subscribers []*subscriber
+	subMu       = sync.RWMutex{}
+	commitMu    = sync.Mutex{}
+)
+
+// OnChange 订阅点分路径(如"redis.mutex")下子树的变化，""表示整个配置
+// 每次合并后子树有变化时按订阅顺序同步回调一次，old/new在路径不存在时为nil
+// 回调在提交锁内执行，不可在回调中修改配置
+func OnChange(path string, f func(old, new *Value)) {
+	var fields []interface{}
+	if path != "" {
+		fields = splitPath(path, ".")
+	}
+
+	subMu.Lock()
+	defer subMu.Unlock()
+
+	subscribers = append(subscribers, &subscriber{fields: fields, f: f})
+}
+
+// update 在各层的副本上修改并合并，校验通过后整体替换并通知订阅者，
+// 校验失败时保留上一次的配置
+func update(source string, f func(ls map[string]map[interface{}]interface{})) error {
+	commitMu.Lock()
+	defer commitMu.Unlock()
+
+	ls := copyLayers()
+	f(ls)
+	next := merge(ls)
+
+	found, err := decryptTree(next)
+	if err == nil {
+		err = validate(next)
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"layer": source,
+			"err":   err.Error(),
+		}).Error("Config update rejected")
+		metrics.GetOrRegisterCounter("config_reject,layer="+source, nil).Inc(1)
+		return err
+	}
+
+	mu.Lock()
+	old := kvs
+	layers = ls
+	kvs = next
+	secrets = found
+	mu.Unlock()
+
+	notify(old, next)
+	return nil
+}
+
+func notify(old, next map[interface{}]interface{}) {
+	subMu.RLock()
+	subs := make([]*subscriber, len(subscribers))
+	copy(subs, subscribers)
+	subMu.RUnlock()
+
+	for _, sub := range subs {
+		oldVal, _ := lookup(old, sub.fields)
+		newVal, _ := lookup(next, sub.fields)
+		if reflect.DeepEqual(oldVal, newVal) {
+			continue
+		}
+		sub.call(toValue(oldVal), toValue(newVal))
+	}
+}
+
+func (sub *subscriber) call(old, new *Value) {
+	defer func() {
+		if ret := recover(); ret != nil {
+			logrus.WithFields(logrus.Fields{
+				"path":  joinPath(sub.fields),
+				"stack": string(debug.Stack()),
+				"err":   ret,
+			}).Error("Config subscriber panic")
+		}
+	}()
+	sub.f(old, new)
+}
+
+func toValue(val interface{}) *Value {
+	if val == nil {
+		return nil
+	}
+	return &Value{val: val}
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/rickone/athena/common"
+)
+
+func resetSubscribers() {
+	subMu.Lock()
+	subscribers = nil
+	subMu.Unlock()
+}
+
+func TestOnChange(t *testing.T) {
+	resetLayers(map[interface{}]interface{}{
+		"redis": map[interface{}]interface{}{
+			"mutex": map[interface{}]interface{}{"address": "127.0.0.1:6379"},
+			"cache": map[interface{}]interface{}{"address": "127.0.0.1:6380"},
+		},
+	})
+	resetSubscribers()
+	defer resetSubscribers()
+
+	var events []string
+	OnChange("redis.mutex", func(old, new *Value) {
+		panic("broken subscriber")
+	})
+	OnChange("redis.mutex", func(old, new *Value) {
+		events = append(events, "mutex:"+old.GetString("address")+">"+new.GetString("address"))
+	})
+	OnChange("redis.cache", func(old, new *Value) {
+		events = append(events, "cache")
+	})
+	OnChange("log", func(old, new *Value) {
+		if old != nil {
+			t.Error("expected nil old value for a new key")
+		}
+		events = append(events, "log:"+new.GetString("level"))
+	})
+
+	UpdateValue("redis", map[interface{}]interface{}{
+		"mutex": map[interface{}]interface{}{"address": "10.0.0.1:6379"},
+	})
+	UpdateValue("redis", map[interface{}]interface{}{
+		"mutex": map[interface{}]interface{}{"address": "10.0.0.1:6379"},
+	})
+	UpdateValue("log", map[interface{}]interface{}{"level": "debug"})
+
+	common.AssertEqualT(t, events, []string{"mutex:127.0.0.1:6379>10.0.0.1:6379", "log:debug"})
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rickone/athena/common"
+)
+
+// 配置中形如 ENC[aes256:<base64>] 的字符串在合并时解密，
+// 密钥取自 CONFIG_SECRET_KEY 或 CONFIG_SECRET_KEY_FILE 指向的文件，至少16字节
+const (
+	secretPrefix  = "ENC[aes256:"
+	secretSuffix  = "]"
+	secretSaltLen = 16
+	secretMagic   = "ATHC"
+
+	SecretKeyEnv     = "CONFIG_SECRET_KEY"
+	SecretKeyFileEnv = "CONFIG_SECRET_KEY_FILE"
+)
+
+var (
+	secretKey   []byte
+	secretKeyMu = sync.RWMutex{}
+
+	// 解密得到的叶子路径，导出配置时需脱敏
+	secrets = map[string]bool{}
+)
+
+// SetSecretKey 指定解密密钥，优先于环境变量
+func SetSecretKey(key []byte) {
+	secretKeyMu.Lock()
+	defer secretKeyMu.Unlock()
+
+	secretKey = key
+}
+
+// LoadSecretKey 按 SetSecretKey、CONFIG_SECRET_KEY、CONFIG_SECRET_KEY_FILE 的顺序查找密钥
+func LoadSecretKey() ([]byte, error) {
+	secretKeyMu.RLock()
+	key := secretKey
+	secretKeyMu.RUnlock()
+	if key != nil {
+		return key, nil
+	}
+
+	if s := os.Getenv(SecretKeyEnv); s != "" {
+		return []byte(s), nil
+	}
+
+	if path := os.Getenv(SecretKeyFileEnv); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimSpace(data), nil
+	}
+	return nil, fmt.Errorf("secret key not found, set %s or %s", SecretKeyEnv, SecretKeyFileEnv)
+}
+
+func IsEncrypted(s string) bool {
+	return strings.HasPrefix(s, secretPrefix) && strings.HasSuffix(s, secretSuffix)
+}
+
+// Encrypt 加密为 ENC[aes256:...] 形式，明文前加16字节头(随机盐+魔数)，
+// 使相同明文的密文不同，并在解密时识别错误的密钥
+func Encrypt(plain string, key []byte) (string, error) {
+	if len(key) < secretSaltLen {
+		return "", fmt.Errorf("secret key must be at least %d bytes", secretSaltLen)
+	}
+
+	saltLen := secretSaltLen - len(secretMagic)
+	data := make([]byte, saltLen, secretSaltLen+len(plain))
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	data = append(data, secretMagic...)
+	data = append(data, plain...)
+
+	crypted, err := common.AesEncrypt(data, key)
+	if err != nil {
+		return "", err
+	}
+	return secretPrefix + base64.StdEncoding.EncodeToString(crypted) + secretSuffix, nil
+}
+
+func Decrypt(s string, key []byte) (plain string, err error) {
+	if !IsEncrypted(s) {
+		return "", errors.New("value is not encrypted")
+	}
+	if len(key) < secretSaltLen {
+		return "", fmt.Errorf("secret key must be at least %d bytes", secretSaltLen)
+	}
+
+	crypted, err := base64.StdEncoding.DecodeString(s[len(secretPrefix) : len(s)-len(secretSuffix)])
+	if err != nil {
+		return "", errors.New("malformed encrypted value")
+	}
+	if len(crypted) <= secretSaltLen || len(crypted)%secretSaltLen != 0 {
+		return "", errors.New("malformed encrypted value")
+	}
+
+	// 密钥错误时填充校验可能越界
+	defer func() {
+		if ret := recover(); ret != nil {
+			err = errors.New("decrypt failed, wrong key?")
+		}
+	}()
+
+	data, err := common.AesDecrypt(crypted, key)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < secretSaltLen || string(data[secretSaltLen-len(secretMagic):secretSaltLen]) != secretMagic {
+		return "", errors.New("decrypt failed, wrong key?")
+	}
+	return string(data[secretSaltLen:]), nil
+}
+
+// decryptTree 原地解密所有加密叶子，返回其路径；错误信息中不包含任何值
+func decryptTree(root map[interface{}]interface{}) (map[string]bool, error) {
+	found := map[string]bool{}
+	var key []byte
+	var keyErr error
+	var errs []string
+
+	var walk func(node interface{}, path string) interface{}
+	walk = func(node interface{}, path string) interface{} {
+		switch t := node.(type) {
+		case map[interface{}]interface{}:
+			for k, v := range t {
+				t[k] = walk(v, joinPath([]interface{}{path, k}))
+			}
+		case []interface{}:
+			for i, v := range t {
+				t[i] = walk(v, joinPath([]interface{}{path, i}))
+			}
+		case string:
+			if !IsEncrypted(t) {
+				return node
+			}
+
+			if key == nil && keyErr == nil {
+				key, keyErr = LoadSecretKey()
+			}
+			if keyErr != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", path, keyErr))
+				return node
+			}
+
+			plain, err := Decrypt(t, key)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", path, err))
+				return node
+			}
+			found[path] = true
+			return plain
+		}
+		return node
+	}
+	walk(root, "")
+
+	if len(errs) > 0 {
+		return nil, &ValidationError{Errors: errs}
+	}
+	return found, nil
+}
+
+// IsSecret 路径上的值是否由密文解密而来
+func IsSecret(fields ...interface{}) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return secrets[joinPath(fields)]
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rickone/athena/common"
+)
+
+func TestSecret(t *testing.T) {
+	key := []byte("0123456789abcdef-test")
+	SetSecretKey(key)
+	defer SetSecretKey(nil)
+
+	enc, err := Encrypt("12345", key)
+	common.AssertErrorT(t, err)
+	common.AssertEqualT(t, IsEncrypted(enc), true)
+
+	enc2, err := Encrypt("12345", key)
+	common.AssertErrorT(t, err)
+	common.AssertNotEqualT(t, enc, enc2)
+
+	_, err = Decrypt(enc, []byte("fedcba9876543210-test"))
+	if err == nil {
+		t.Fatal("expected wrong key error")
+	}
+
+	resetLayers(map[interface{}]interface{}{})
+	common.AssertErrorT(t, UpdateValue("mysql", map[interface{}]interface{}{
+		"write": map[interface{}]interface{}{"username": "root", "password": enc},
+	}))
+	common.AssertEqualT(t, GetString("mysql", "write", "password"), "12345")
+	common.AssertEqualT(t, IsSecret("mysql", "write", "password"), true)
+	common.AssertEqualT(t, IsSecret("mysql", "write", "username"), false)
+
+	err = UpdateValue("redis", map[interface{}]interface{}{
+		"mutex": map[interface{}]interface{}{"auth": "ENC[aes256:bm90LWEtc2VjcmV0]"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "redis.mutex.auth: malformed") {
+		t.Fatalf("expected malformed error, got %v", err)
+	}
+	common.AssertEqualT(t, GetValue("redis") == nil, true)
+}
@@ -0,0 +1,329 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	Redacted = "******"
+)
+
+var (
+	// 以下键名的值导出时默认脱敏
+	secretNames = map[string]bool{
+		"password":    true,
+		"auth":        true,
+		"token":       true,
+		"secret_key":  true,
+		"session_key": true,
+	}
+	secretPatterns   [][]string
+	secretPatternsMu = sync.RWMutex{}
+)
+
+// MarkSecret 标记点分路径为敏感，导出时脱敏，路径段可用"*"通配，如"redis.*.auth"
+func MarkSecret(path string) {
+	secretPatternsMu.Lock()
+	defer secretPatternsMu.Unlock()
+
+	secretPatterns = append(secretPatterns, strings.Split(path, "."))
+}
+
+func isSecretPath(fields []interface{}, decrypted map[string]bool) bool {
+	if len(fields) == 0 {
+		return false
+	}
+	if decrypted[joinPath(fields)] || secretNames[fmt.Sprint(fields[len(fields)-1])] {
+		return true
+	}
+
+	secretPatternsMu.RLock()
+	defer secretPatternsMu.RUnlock()
+
+	for _, pattern := range secretPatterns {
+		if len(pattern) != len(fields) {
+			continue
+		}
+
+		matched := true
+		for i, seg := range pattern {
+			if seg != "*" && seg != fmt.Sprint(fields[i]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Snapshot 返回当前有效配置的深拷贝
+func Snapshot() *Value {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return &Value{val: deepCopy(kvs)}
+}
+
+// Redact 返回脱敏后的副本，v须为Snapshot等根节点
+func Redact(v *Value) *Value {
+	if v == nil {
+		return nil
+	}
+
+	mu.RLock()
+	decrypted := secrets
+	mu.RUnlock()
+
+	return &Value{val: redact(v.val, nil, decrypted)}
+}
+
+func redact(node interface{}, fields []interface{}, decrypted map[string]bool) interface{} {
+	if isSecretPath(fields, decrypted) {
+		return Redacted
+	}
+	if str, ok := node.(string); ok && IsEncrypted(str) {
+		return Redacted
+	}
+
+	switch t := node.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(t))
+		for k, v := range t {
+			m[k] = redact(v, appendField(fields, k), decrypted)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(t))
+		for i, v := range t {
+			s[i] = redact(v, appendField(fields, i), decrypted)
+		}
+		return s
+	default:
+		return node
+	}
+}
+
+func appendField(fields []interface{}, field interface{}) []interface{} {
+	sub := make([]interface{}, len(fields), len(fields)+1)
+	copy(sub, fields)
+	return append(sub, field)
+}
+
+// DumpYAML 脱敏后序列化为YAML
+func DumpYAML(v *Value) ([]byte, error) {
+	v = Redact(v)
+	if v == nil {
+		return yaml.Marshal(nil)
+	}
+	return yaml.Marshal(v.val)
+}
+
+// DumpJSON 脱敏后序列化为JSON，键统一转为字符串
+func DumpJSON(v *Value) ([]byte, error) {
+	v = Redact(v)
+	if v == nil {
+		return json.Marshal(nil)
+	}
+	return json.MarshalIndent(toJSONValue(v.val), "", "  ")
+}
+
+func toJSONValue(node interface{}) interface{} {
+	switch t := node.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprint(k)] = toJSONValue(v)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(t))
+		for i, v := range t {
+			s[i] = toJSONValue(v)
+		}
+		return s
+	default:
+		return node
+	}
+}
+
+type ChangeType string
+
+const (
+	ChangeAdded   ChangeType = "added"
+	ChangeRemoved ChangeType = "removed"
+	ChangeChanged ChangeType = "changed"
+)
+
+type Change struct {
+	Path string      `json:"path"`
+	Type ChangeType  `json:"type"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+
+	fields []interface{}
+	oldRaw interface{}
+	newRaw interface{}
+}
+
+// Diff 按路径列出a到b新增、删除和修改的叶子节点，结果按路径排序
+func Diff(a, b *Value) []Change {
+	var av, bv interface{}
+	if a != nil {
+		av = a.val
+	}
+	if b != nil {
+		bv = b.val
+	}
+
+	var changes []Change
+	diff(av, bv, nil, &changes)
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+func diff(a, b interface{}, fields []interface{}, changes *[]Change) {
+	am, aok := a.(map[interface{}]interface{})
+	bm, bok := b.(map[interface{}]interface{})
+	if (aok || a == nil) && (bok || b == nil) && (aok || bok) {
+		for k, v := range am {
+			diff(v, bm[k], appendField(fields, k), changes)
+		}
+		for k, v := range bm {
+			if _, ok := am[k]; !ok {
+				diff(nil, v, appendField(fields, k), changes)
+			}
+		}
+		return
+	}
+
+	if reflect.DeepEqual(a, b) {
+		return
+	}
+
+	change := Change{
+		Path:   joinPath(fields),
+		Old:    toJSONValue(a),
+		New:    toJSONValue(b),
+		fields: fields,
+		oldRaw: a,
+		newRaw: b,
+	}
+	switch {
+	case a == nil:
+		change.Type = ChangeAdded
+	case b == nil:
+		change.Type = ChangeRemoved
+	default:
+		change.Type = ChangeChanged
+	}
+	*changes = append(*changes, change)
+}
+
+// RedactChanges 脱敏Diff结果中的敏感值
+func RedactChanges(changes []Change) []Change {
+	mu.RLock()
+	decrypted := secrets
+	mu.RUnlock()
+
+	result := make([]Change, len(changes))
+	for i, c := range changes {
+		if c.oldRaw != nil {
+			c.Old = toJSONValue(redact(c.oldRaw, c.fields, decrypted))
+		}
+		if c.newRaw != nil {
+			c.New = toJSONValue(redact(c.newRaw, c.fields, decrypted))
+		}
+		result[i] = c
+	}
+	return result
+}
+
+// AdminHandler 查看有效配置的HTTP接口，所有输出均已脱敏
+//   ?format=json|yaml   输出格式，默认json
+//   ?layer=<layer>      只看某一层(file/consul/env/flag)
+//   ?diff=<layer>       该层与有效配置的差异
+//   ?origins=1          各叶子节点的来源层
+func AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		if query.Get("origins") != "" {
+			writeJSON(w, Origins())
+			return
+		}
+
+		if name := query.Get("diff"); name != "" {
+			layer, ok := getLayerSnapshot(name)
+			if !ok {
+				http.Error(w, fmt.Sprintf("unknown layer %q", name), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, RedactChanges(Diff(layer, Snapshot())))
+			return
+		}
+
+		v := Snapshot()
+		if name := query.Get("layer"); name != "" {
+			layer, ok := getLayerSnapshot(name)
+			if !ok {
+				http.Error(w, fmt.Sprintf("unknown layer %q", name), http.StatusBadRequest)
+				return
+			}
+			v = layer
+		}
+
+		var data []byte
+		var err error
+		if query.Get("format") == "yaml" {
+			w.Header().Set("Content-Type", "application/x-yaml; charset=utf-8")
+			data, err = DumpYAML(v)
+		} else {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			data, err = DumpJSON(v)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+	})
+}
+
+func getLayerSnapshot(name string) (*Value, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, l := range layerOrder {
+		if l == name {
+			return &Value{val: deepCopy(layers[name])}, true
+		}
+	}
+	return nil, false
+}
+
+func writeJSON(w http.ResponseWriter, val interface{}) {
+	data, err := json.MarshalIndent(val, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(data)
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rickone/athena/common"
+)
+
+func TestSnapshotDiff(t *testing.T) {
+	key := []byte("0123456789abcdef-test")
+	SetSecretKey(key)
+	defer SetSecretKey(nil)
+
+	enc, err := Encrypt("Toz1C9qKnF", key)
+	common.AssertErrorT(t, err)
+
+	resetLayers(map[interface{}]interface{}{
+		"service": map[interface{}]interface{}{"consul": "127.0.0.1:8500"},
+		"mysql": map[interface{}]interface{}{
+			"read": map[interface{}]interface{}{"username": "root", "password": "12345"},
+		},
+		"image": map[interface{}]interface{}{"secret_id": "AKID", "base_url": "https://a"},
+	})
+	MarkSecret("image.secret_id")
+
+	before := Snapshot()
+	common.AssertErrorT(t, UpdateValue("image", map[interface{}]interface{}{
+		"base_url": "https://b",
+		"api_key":  enc,
+	}))
+	common.AssertErrorT(t, UpdateValue("redis", map[interface{}]interface{}{
+		"mutex": map[interface{}]interface{}{"address": "127.0.0.1:6379"},
+	}))
+
+	// Snapshot是深拷贝，修改不影响当前配置
+	before.ToMap()["service"].(map[interface{}]interface{})["consul"] = "changed"
+	common.AssertEqualT(t, GetString("service", "consul"), "127.0.0.1:8500")
+	before.ToMap()["service"].(map[interface{}]interface{})["consul"] = "127.0.0.1:8500"
+
+	changes := Diff(before, Snapshot())
+	paths := []string{}
+	for _, c := range changes {
+		paths = append(paths, string(c.Type)+":"+c.Path)
+	}
+	common.AssertEqualT(t, paths, []string{"added:image.api_key", "changed:image.base_url", "added:redis.mutex.address"})
+	common.AssertEqualT(t, changes[0].New, "Toz1C9qKnF")
+	common.AssertEqualT(t, RedactChanges(changes)[0].New, Redacted)
+
+	data, err := DumpYAML(Snapshot())
+	common.AssertErrorT(t, err)
+	for _, secret := range []string{"12345", "AKID", "Toz1C9qKnF", "ENC["} {
+		if strings.Contains(string(data), secret) {
+			t.Fatalf("dump leaks %q:\n%s", secret, data)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/config?layer=consul", nil))
+	common.AssertEqualT(t, rec.Code, 200)
+
+	dump := map[string]interface{}{}
+	common.AssertErrorT(t, json.Unmarshal(rec.Body.Bytes(), &dump))
+	common.AssertEqualT(t, dump["image"].(map[string]interface{})["api_key"], Redacted)
+	common.AssertEqualT(t, dump["service"], nil)
+}
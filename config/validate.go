@@ -0,0 +1,211 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Validator 校验合并后的完整配置，返回error时整次更新被拒绝
+type Validator func(root *Value) error
+
+var (
+	validators   = map[string]Validator{}
+	validatorsMu = sync.RWMutex{}
+)
+
+// ValidationError 汇总所有未通过的校验
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("config: %d validation error(s):\n* %s", len(e.Errors), strings.Join(e.Errors, "\n* "))
+}
+
+// RegisterValidator 注册具名校验器，同名覆盖
+func RegisterValidator(name string, f Validator) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+
+	validators[name] = f
+}
+
+func UnregisterValidator(name string) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+
+	delete(validators, name)
+}
+
+// RegisterSchema 为点分路径下的子树注册Schema校验，""表示整个配置
+func RegisterSchema(path string, schema *Schema) {
+	var fields []interface{}
+	if path != "" {
+		fields = splitPath(path, ".")
+	}
+
+	RegisterValidator("schema:"+path, func(root *Value) error {
+		val, _ := lookup(root.val, fields)
+		return schema.Validate(val, path)
+	})
+}
+
+func validate(root map[interface{}]interface{}) error {
+	validatorsMu.RLock()
+	names := make([]string, 0, len(validators))
+	for name := range validators {
+		names = append(names, name)
+	}
+	fs := make([]Validator, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		fs[i] = validators[name]
+	}
+	validatorsMu.RUnlock()
+
+	v := &Value{val: root}
+	ve := &ValidationError{}
+	for i, f := range fs {
+		err := f(v)
+		if err == nil {
+			continue
+		}
+
+		if inner, ok := err.(*ValidationError); ok {
+			ve.Errors = append(ve.Errors, inner.Errors...)
+		} else {
+			ve.Errors = append(ve.Errors, fmt.Sprintf("%s: %v", names[i], err))
+		}
+	}
+
+	if len(ve.Errors) > 0 {
+		return ve
+	}
+	return nil
+}
+
+// Schema JSON-Schema风格的结构描述，可由YAML或JSON解析
+//   type: object|array|string|integer|number|boolean
+//   required: 对象必须存在的键
+//   properties: 对象已知键的Schema
+//   additional_properties: 其余键的Schema，如redis下的各实例
+//   items: 数组元素的Schema
+//   enum: 可选值
+type Schema struct {
+	Type                 string             `yaml:"type" json:"type"`
+	Required             []string           `yaml:"required" json:"required"`
+	Properties           map[string]*Schema `yaml:"properties" json:"properties"`
+	AdditionalProperties *Schema            `yaml:"additional_properties" json:"additionalProperties"`
+	Items                *Schema            `yaml:"items" json:"items"`
+	Enum                 []interface{}      `yaml:"enum" json:"enum"`
+}
+
+func ParseSchema(data []byte) (*Schema, error) {
+	schema := &Schema{}
+	if err := yaml.Unmarshal(data, schema); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+func (s *Schema) Validate(val interface{}, path string) error {
+	ve := &ValidationError{}
+	s.validate(val, path, ve)
+	if len(ve.Errors) > 0 {
+		return ve
+	}
+	return nil
+}
+
+func (s *Schema) validate(val interface{}, path string, ve *ValidationError) {
+	errorf := func(format string, args ...interface{}) {
+		p := path
+		if p == "" {
+			p = "<root>"
+		}
+		ve.Errors = append(ve.Errors, fmt.Sprintf("%s: %s", p, fmt.Sprintf(format, args...)))
+	}
+
+	if val == nil {
+		if s.Type != "" {
+			errorf("expected %s, got null", s.Type)
+		}
+		return
+	}
+
+	switch s.Type {
+	case "":
+	case "object":
+		m, ok := val.(map[interface{}]interface{})
+		if !ok {
+			errorf("expected object, got %T", val)
+			return
+		}
+
+		for _, key := range s.Required {
+			if v, ok := lookupKey(m, key); !ok || v == nil {
+				errorf("missing required key %q", key)
+			}
+		}
+
+		for k, v := range m {
+			name := fmt.Sprint(k)
+			sub := s.Properties[name]
+			if sub == nil {
+				sub = s.AdditionalProperties
+			}
+			if sub != nil {
+				sub.validate(v, joinPath([]interface{}{path, name}), ve)
+			}
+		}
+	case "array":
+		items, ok := val.([]interface{})
+		if !ok {
+			errorf("expected array, got %T", val)
+			return
+		}
+
+		if s.Items != nil {
+			for i, item := range items {
+				s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), ve)
+			}
+		}
+	case "string":
+		switch val.(type) {
+		case string, int, int64, uint64, float64, bool:
+		default:
+			errorf("expected string, got %T", val)
+		}
+	case "integer":
+		switch val.(type) {
+		case int, int64, uint64:
+		default:
+			errorf("expected integer, got %T", val)
+		}
+	case "number":
+		switch val.(type) {
+		case int, int64, uint64, float64:
+		default:
+			errorf("expected number, got %T", val)
+		}
+	case "boolean":
+		if _, ok := val.(bool); !ok {
+			errorf("expected boolean, got %T", val)
+		}
+	default:
+		errorf("unknown schema type %q", s.Type)
+	}
+
+	if len(s.Enum) > 0 {
+		for _, e := range s.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(val) {
+				return
+			}
+		}
+		errorf("value not in %v", s.Enum)
+	}
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rickone/athena/common"
+)
+
+const testSchema = `
+type: object
+required: [service]
+properties:
+  service:
+    type: object
+    required: [consul]
+    additional_properties:
+      type: string
+  redis:
+    type: object
+    additional_properties:
+      type: object
+      required: [address]
+      properties:
+        db:
+          type: integer
+`
+
+func TestValidateRejectsUpdate(t *testing.T) {
+	resetLayers(map[interface{}]interface{}{
+		"service": map[interface{}]interface{}{"consul": "127.0.0.1:8500"},
+		"redis": map[interface{}]interface{}{
+			"mutex": map[interface{}]interface{}{"address": "127.0.0.1:6379"},
+		},
+	})
+	resetSubscribers()
+	defer resetSubscribers()
+
+	schema, err := ParseSchema([]byte(testSchema))
+	common.AssertErrorT(t, err)
+	RegisterSchema("", schema)
+	defer UnregisterValidator("schema:")
+
+	RegisterValidator("redis-mutex", func(root *Value) error {
+		if root.GetValue("redis", "mutex") == nil {
+			return errors.New("redis.mutex is required")
+		}
+		return nil
+	})
+	defer UnregisterValidator("redis-mutex")
+
+	changed := 0
+	OnChange("", func(old, new *Value) {
+		changed++
+	})
+
+	err = UpdateValue("service", map[interface{}]interface{}{
+		"consul": map[interface{}]interface{}{"address": "127.0.0.1:8500"},
+	})
+	ve, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected *ValidationError, got %v", err)
+	}
+	if !strings.Contains(ve.Error(), "service.consul: expected string") {
+		t.Fatalf("unexpected error: %v", ve)
+	}
+
+	err = UpdateValue("redis", map[interface{}]interface{}{
+		"cache": map[interface{}]interface{}{"db": "x"},
+	})
+	ve, ok = err.(*ValidationError)
+	if !ok || len(ve.Errors) != 2 {
+		t.Fatalf("expected 2 validation errors, got %v", err)
+	}
+
+	common.AssertEqualT(t, GetString("service", "consul"), "127.0.0.1:8500")
+	common.AssertEqualT(t, GetValue("redis", "cache") == nil, true)
+	common.AssertEqualT(t, Origin("service", "consul"), LayerFile)
+	common.AssertEqualT(t, changed, 0)
+
+	common.AssertErrorT(t, UpdateValue("redis", map[interface{}]interface{}{
+		"cache": map[interface{}]interface{}{"address": "127.0.0.1:6380", "db": 1},
+	}))
+	common.AssertEqualT(t, GetInt("redis", "cache", "db"), int64(1))
+	common.AssertEqualT(t, changed, 1)
+}
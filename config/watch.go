@@ -1,66 +1,225 @@
 package config
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/consul/api"
-	"github.com/rickone/athena/common"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 )
 
 const (
-	errInterval = 2 * time.Second
+	watchPrefix     = "config"
+	watchWaitTime   = 5 * time.Minute
+	watchMinBackoff = 1 * time.Second
+	watchMaxBackoff = 1 * time.Minute
 )
 
-func Watch() {
-	address := GetString("service", "consul")
-	if address == "" {
-		log.Fatalf("service.consul is empty!")
-	}
+var (
+	defaultWatcher *Watcher
+	watchMu        = sync.RWMutex{}
+)
+
+// WatchStatus 配置监听的健康状况
+type WatchStatus struct {
+	LastIndex   uint64
+	LastSuccess time.Time
+	LastError   error
+}
+
+// Watcher 通过Consul阻塞查询监听配置，按顺序合并以下键后整体替换consul层：
+//   config/global
+//   config/<service>
+//   config/<service>/<host>
+type Watcher struct {
+	Address string
+	Service string
+	Host    string
+
+	client *api.Client
+	mu     sync.RWMutex
+	status WatchStatus
+}
 
+func NewWatcher(address, service, host string) (*Watcher, error) {
 	cfg := api.DefaultConfig()
 	cfg.Address = address
 
 	client, err := api.NewClient(cfg)
-	common.AssertError(err)
+	if err != nil {
+		return nil, err
+	}
 
+	return &Watcher{
+		Address: address,
+		Service: service,
+		Host:    host,
+		client:  client,
+	}, nil
+}
+
+func (w *Watcher) Keys() []string {
+	keys := []string{watchPrefix + "/global"}
+	if w.Service != "" {
+		keys = append(keys, fmt.Sprintf("%s/%s", watchPrefix, w.Service))
+		if w.Host != "" {
+			keys = append(keys, fmt.Sprintf("%s/%s/%s", watchPrefix, w.Service, w.Host))
+		}
+	}
+	return keys
+}
+
+func (w *Watcher) Status() WatchStatus {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.status
+}
+
+// Run 阻塞监听直到ctx结束，失败时指数退避重试
+func (w *Watcher) Run(ctx context.Context) error {
+	backoff := watchMinBackoff
 	var lastIndex uint64
+
 	for {
-		data, err := getKV(client, "config", &lastIndex)
+		index, err := w.poll(ctx, lastIndex)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
 		if err != nil {
+			w.mu.Lock()
+			w.status.LastError = err
+			w.mu.Unlock()
+
+			if _, ok := err.(*ValidationError); ok {
+				// 推送被拒绝，等待下一次变更
+				lastIndex = index
+				continue
+			}
+
 			logrus.WithFields(logrus.Fields{
-				"err": err.Error(),
+				"err":     err.Error(),
+				"backoff": backoff.String(),
 			}).Error("Config watch failed")
-			time.Sleep(errInterval)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > watchMaxBackoff {
+				backoff = watchMaxBackoff
+			}
+			continue
 		}
 
-		val := map[interface{}]interface{}{}
-		err = yaml.Unmarshal(data, &val)
+		backoff = watchMinBackoff
+		lastIndex = index
+	}
+}
+
+// poll 执行一次阻塞查询，索引变化时合并并替换consul层
+func (w *Watcher) poll(ctx context.Context, lastIndex uint64) (uint64, error) {
+	opts := (&api.QueryOptions{WaitIndex: lastIndex, WaitTime: watchWaitTime}).WithContext(ctx)
+	pairs, meta, err := w.client.KV().List(watchPrefix+"/", opts)
+	if err != nil {
+		return lastIndex, err
+	}
+
+	index := meta.LastIndex
+	if index < lastIndex {
+		// Consul索引回退(如集群重建)，需重新全量同步
+		index = 0
+	}
+
+	if index != lastIndex || lastIndex == 0 {
+		layer, err := w.load(pairs)
 		if err != nil {
-			logrus.WithFields(logrus.Fields{
-				"err": err.Error(),
-			}).Error("Config watch unmarshal failed")
-			time.Sleep(errInterval)
+			return lastIndex, err
+		}
+		if err := setLayer(LayerConsul, layer); err != nil {
+			return index, err
+		}
+	}
+
+	w.mu.Lock()
+	w.status.LastIndex = meta.LastIndex
+	w.status.LastSuccess = time.Now()
+	w.status.LastError = nil
+	w.mu.Unlock()
+	return index, nil
+}
+
+func (w *Watcher) load(pairs api.KVPairs) (map[interface{}]interface{}, error) {
+	values := map[string][]byte{}
+	for _, pair := range pairs {
+		values[pair.Key] = pair.Value
+	}
+
+	layer := map[interface{}]interface{}{}
+	for _, key := range w.Keys() {
+		data, ok := values[key]
+		if !ok {
+			continue
+		}
+
+		val := map[interface{}]interface{}{}
+		if err := yaml.Unmarshal(data, &val); err != nil {
+			return nil, fmt.Errorf("unmarshal %s: %v", key, err)
 		}
 
 		for k, v := range val {
-			UpdateValue(k, v)
+			updateValue(layer, k, v)
 		}
 	}
+	return layer, nil
 }
 
-func getKV(client *api.Client, key string, lastIndex *uint64) ([]byte, error) {
-	kvPair, meta, err := client.KV().Get(key, &api.QueryOptions{WaitIndex: *lastIndex})
+// Watch 使用service.consul地址监听配置，直到ctx结束
+// 未配置service.consul时直接返回
+func Watch(ctx context.Context) error {
+	address := GetString("service", "consul")
+	if address == "" {
+		logrus.Warn("Config watch disabled: service.consul is empty")
+		return nil
+	}
+
+	service := GetString("service", "name")
+	if service == "" {
+		service = os.Getenv("Service")
+	}
+
+	host, err := os.Hostname()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	*lastIndex = meta.LastIndex
 
-	if kvPair == nil {
-		return nil, nil
+	w, err := NewWatcher(address, service, host)
+	if err != nil {
+		return err
 	}
 
-	return kvPair.Value, nil
+	watchMu.Lock()
+	defaultWatcher = w
+	watchMu.Unlock()
+
+	return w.Run(ctx)
+}
+
+// WatchHealth 返回Init启动的配置监听状态
+func WatchHealth() (WatchStatus, bool) {
+	watchMu.RLock()
+	defer watchMu.RUnlock()
+
+	if defaultWatcher == nil {
+		return WatchStatus{}, false
+	}
+	return defaultWatcher.Status(), true
 }
@@ -0,0 +1,79 @@
+package config_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rickone/athena/common"
+	"github.com/rickone/athena/config"
+	"github.com/rickone/athena/mock"
+)
+
+func waitFor(t *testing.T, cond func() bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := common.RepeatUntil(ctx, 10*time.Millisecond, func(ctx context.Context) (bool, error) {
+		return cond(), nil
+	})
+	common.AssertErrorT(t, err)
+}
+
+func TestWatcher(t *testing.T) {
+	consul := mock.NewConsul()
+	defer consul.Close()
+
+	consul.PutKV("config/global", []byte("watch:\n  address: 127.0.0.1:6379\n  auth: global\n"))
+	consul.PutKV("config/wallet", []byte("watch:\n  auth: service\n"))
+	consul.PutKV("config/wallet/host1", []byte("watch:\n  db: 3\n"))
+	consul.PutKV("config/other", []byte("watch:\n  auth: other\n"))
+
+	w, err := config.NewWatcher(consul.Address(), "wallet", "host1")
+	common.AssertErrorT(t, err)
+	common.AssertEqualT(t, w.Keys(), []string{"config/global", "config/wallet", "config/wallet/host1"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Run(ctx)
+	}()
+
+	waitFor(t, func() bool {
+		return config.GetInt("watch", "db") == 3
+	})
+	common.AssertEqualT(t, config.GetString("watch", "address"), "127.0.0.1:6379")
+	common.AssertEqualT(t, config.GetString("watch", "auth"), "service")
+	common.AssertEqualT(t, config.Origin("watch", "auth"), config.LayerConsul)
+
+	// 坏数据整体跳过，保留上一次的配置
+	consul.PutKV("config/wallet/host1", []byte("watch: [unclosed"))
+	waitFor(t, func() bool {
+		return w.Status().LastError != nil
+	})
+	common.AssertEqualT(t, config.GetInt("watch", "db"), int64(3))
+
+	consul.PutKV("config/wallet/host1", []byte("watch:\n  db: 4\n"))
+	waitFor(t, func() bool {
+		return config.GetInt("watch", "db") == 4
+	})
+
+	// 删除键后对应的值随consul层一起消失
+	consul.DeleteKV("config/wallet")
+	waitFor(t, func() bool {
+		return config.GetString("watch", "auth") == "global"
+	})
+
+	status := w.Status()
+	if status.LastError != nil || status.LastIndex == 0 || status.LastSuccess.IsZero() {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		common.AssertEqualT(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher did not stop")
+	}
+}
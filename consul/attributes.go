@@ -0,0 +1,88 @@
+package consul
+
+import (
+	"reflect"
+
+	"github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+type attrKey string
+
+const (
+	tagsKey   attrKey = "consul_tags"
+	metaKey   attrKey = "consul_meta"
+	weightKey attrKey = "consul_weight"
+)
+
+type nodeAttrs struct {
+	tags   []string
+	meta   map[string]string
+	weight int
+	attrs  *attributes.Attributes
+}
+
+// newAttributes 由健康检查结果生成地址属性，未变化时复用旧的*Attributes，
+// 避免balancer把同一地址当成新地址重建连接
+func newAttributes(entry *api.ServiceEntry, last *nodeAttrs) *nodeAttrs {
+	weight := entry.Service.Weights.Passing
+	if entry.Checks.AggregatedStatus() == api.HealthWarning {
+		weight = entry.Service.Weights.Warning
+	}
+
+	na := &nodeAttrs{
+		tags:   entry.Service.Tags,
+		meta:   entry.Service.Meta,
+		weight: weight,
+	}
+	if last != nil && last.weight == na.weight && reflect.DeepEqual(last.tags, na.tags) && reflect.DeepEqual(last.meta, na.meta) {
+		na.attrs = last.attrs
+		return na
+	}
+
+	na.attrs = NewAttributes(na.tags, na.meta, na.weight)
+	return na
+}
+
+func AddressTags(addr resolver.Address) []string {
+	if addr.Attributes == nil {
+		return nil
+	}
+	tags, _ := addr.Attributes.Value(tagsKey).([]string)
+	return tags
+}
+
+func AddressMeta(addr resolver.Address) map[string]string {
+	if addr.Attributes == nil {
+		return nil
+	}
+	meta, _ := addr.Attributes.Value(metaKey).(map[string]string)
+	return meta
+}
+
+// AddressWeight 返回节点权重，健康检查为warning时取Weights.Warning，未设置时为1
+func AddressWeight(addr resolver.Address) int {
+	if addr.Attributes == nil {
+		return 1
+	}
+	weight, ok := addr.Attributes.Value(weightKey).(int)
+	if !ok || weight <= 0 {
+		return 1
+	}
+	return weight
+}
+
+func AddressHasTag(addr resolver.Address, tag string) bool {
+	for _, t := range AddressTags(addr) {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAttributes 生成带标签、元数据和权重的地址属性，供其它服务发现后端接入consul_weighted
+func NewAttributes(tags []string, meta map[string]string, weight int) *attributes.Attributes {
+	return attributes.New(tagsKey, tags, metaKey, meta, weightKey, weight)
+}
@@ -0,0 +1,104 @@
+package consul
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+const (
+	// WeightedBalancerName 按注册权重随机选择节点，可通过WithTag指定标签
+	// 如稳定节点权重19、canary节点权重1，canary约分得5%流量
+	WeightedBalancerName = "consul_weighted"
+)
+
+type tagCtxKey struct{}
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(WeightedBalancerName, &weightedPickerBuilder{}, base.Config{HealthCheck: true}))
+}
+
+// WithTag 本次调用只路由到带该标签的节点，没有可用节点时退回全部节点
+func WithTag(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, tagCtxKey{}, tag)
+}
+
+type weightedNode struct {
+	sc     balancer.SubConn
+	addr   string
+	tags   []string
+	weight int
+}
+
+type weightedPickerBuilder struct{}
+
+func (b *weightedPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	return newWeightedPicker(info)
+}
+
+func newWeightedPicker(info base.PickerBuildInfo) *weightedPicker {
+	nodes := make([]*weightedNode, 0, len(info.ReadySCs))
+	for sc, sci := range info.ReadySCs {
+		nodes = append(nodes, &weightedNode{
+			sc:     sc,
+			addr:   sci.Address.Addr,
+			tags:   AddressTags(sci.Address),
+			weight: AddressWeight(sci.Address),
+		})
+	}
+	return &weightedPicker{
+		nodes: nodes,
+		rand:  rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+type weightedPicker struct {
+	nodes []*weightedNode
+	mu    sync.Mutex
+	rand  *rand.Rand
+}
+
+func (p *weightedPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	return balancer.PickResult{SubConn: p.pick(info.Ctx, p.nodes).sc}, nil
+}
+
+// pick 按WithTag过滤后按权重随机选择，nodes不能为空
+func (p *weightedPicker) pick(ctx context.Context, nodes []*weightedNode) *weightedNode {
+	if tag, ok := ctx.Value(tagCtxKey{}).(string); ok && tag != "" {
+		var tagged []*weightedNode
+		for _, node := range nodes {
+			for _, t := range node.tags {
+				if t == tag {
+					tagged = append(tagged, node)
+					break
+				}
+			}
+		}
+		if len(tagged) > 0 {
+			nodes = tagged
+		}
+	}
+
+	total := 0
+	for _, node := range nodes {
+		total += node.weight
+	}
+
+	p.mu.Lock()
+	n := p.rand.Intn(total)
+	p.mu.Unlock()
+
+	for _, node := range nodes {
+		n -= node.weight
+		if n < 0 {
+			return node
+		}
+	}
+	return nodes[len(nodes)-1]
+}
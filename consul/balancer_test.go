@@ -0,0 +1,55 @@
+package consul
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+type testSubConn struct {
+	name string
+}
+
+func (sc *testSubConn) UpdateAddresses([]resolver.Address) {}
+func (sc *testSubConn) Connect()                           {}
+
+func TestWeightedPicker(t *testing.T) {
+	stable := &testSubConn{name: "stable"}
+	canary := &testSubConn{name: "canary"}
+
+	picker := (&weightedPickerBuilder{}).Build(base.PickerBuildInfo{
+		ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+			stable: {Address: resolver.Address{Addr: "10.0.0.1:80", Attributes: attributes.New(weightKey, 19)}},
+			canary: {Address: resolver.Address{Addr: "10.0.0.2:80", Attributes: attributes.New(weightKey, 1, tagsKey, []string{"canary"})}},
+		},
+	})
+
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		res, err := picker.Pick(balancer.PickInfo{Ctx: context.Background()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[res.SubConn.(*testSubConn).name]++
+	}
+	if counts["canary"] < 300 || counts["canary"] > 700 {
+		t.Fatalf("canary share out of range: %v", counts)
+	}
+
+	ctx := WithTag(context.Background(), "canary")
+	for i := 0; i < 100; i++ {
+		res, _ := picker.Pick(balancer.PickInfo{Ctx: ctx})
+		if res.SubConn != canary {
+			t.Fatal("tagged pick should route to canary")
+		}
+	}
+
+	ctx = WithTag(context.Background(), "missing")
+	if _, err := picker.Pick(balancer.PickInfo{Ctx: ctx}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,312 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	electionTTL        = 15 * time.Second
+	electionLockDelay  = 5 * time.Second
+	electionWaitTime   = 5 * time.Minute
+	electionMinBackoff = 1 * time.Second
+	electionMaxBackoff = 30 * time.Second
+)
+
+// LeadershipEvent Leader为true表示成为leader，false表示失去
+type LeadershipEvent struct {
+	Key     string
+	Leader  bool
+	Session string
+}
+
+// Election 基于Consul会话和KV acquire的选主，同一时刻一个Election只应有一个Campaign
+//   election := consul.NewElection(address)
+//   election.OnElected = func(ctx context.Context) { go scanner.Run(ctx) }
+//   election.Campaign(ctx, "leader/chain-scanner")
+type Election struct {
+	Address string
+	// TTL 会话TTL，每TTL/3续期一次，超过TTL未能续期即视为失去leader
+	TTL time.Duration
+	// LockDelay 会话失效后其它节点需等待的时间，留给旧leader停止工作
+	LockDelay time.Duration
+	// Value 写入key的值，默认为主机名和进程号
+	Value []byte
+
+	// OnElected 成为leader时调用，ctx在失去leader时取消，应尽快返回，工作放到协程中按ctx退出
+	OnElected func(ctx context.Context)
+	// OnRevoked 失去leader时在ctx取消之后调用
+	OnRevoked func()
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewElection(address string) *Election {
+	hostname, _ := os.Hostname()
+	return &Election{
+		Address:   address,
+		TTL:       electionTTL,
+		LockDelay: electionLockDelay,
+		Value:     []byte(fmt.Sprintf("%s:%d", hostname, os.Getpid())),
+	}
+}
+
+// Campaign 持续竞选直到ctx结束或Resign，返回的通道在竞选结束时关闭，
+// 读取不及时只保留最新的事件，需要可靠地启停工作时使用OnElected/OnRevoked
+func (e *Election) Campaign(ctx context.Context, key string) <-chan LeadershipEvent {
+	e.Resign()
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	events := make(chan LeadershipEvent, 1)
+
+	e.mu.Lock()
+	e.cancel = cancel
+	e.done = done
+	e.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		defer close(events)
+		e.run(ctx, key, events)
+	}()
+	return events
+}
+
+// Resign 放弃leader并停止竞选，等待释放锁和销毁会话后返回
+func (e *Election) Resign() {
+	e.mu.Lock()
+	cancel, done := e.cancel, e.done
+	e.cancel, e.done = nil, nil
+	e.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+func (e *Election) run(ctx context.Context, key string, events chan LeadershipEvent) {
+	cfg := api.DefaultConfig()
+	cfg.Address = e.Address
+
+	client, err := api.NewClient(cfg)
+	if err != nil {
+		logrus.WithField("err", err.Error()).Error("Consul election create client failed")
+		return
+	}
+
+	backoff := electionMinBackoff
+	for {
+		err := e.session(ctx, client, key, events)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			backoff = electionMinBackoff
+			continue
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"key": key,
+			"err": err.Error(),
+		}).Error("Consul election failed")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > electionMaxBackoff {
+			backoff = electionMaxBackoff
+		}
+	}
+}
+
+// session 创建会话并竞选，会话失效或ctx结束时返回
+func (e *Election) session(ctx context.Context, client *api.Client, key string, events chan LeadershipEvent) error {
+	id, _, err := client.Session().Create(&api.SessionEntry{
+		Name:      "election:" + key,
+		TTL:       e.TTL.String(),
+		LockDelay: e.LockDelay,
+		Behavior:  api.SessionBehaviorRelease,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	sctx, cancel := context.WithCancel(ctx)
+	renewDone := make(chan error, 1)
+	go func() {
+		renewDone <- e.renew(sctx, client, id)
+		cancel()
+	}()
+
+	defer func() {
+		cancel()
+		// 先释放锁再销毁会话，其它节点无需等待LockDelay
+		client.KV().Release(&api.KVPair{Key: key, Session: id}, nil)
+		client.Session().Destroy(id, nil)
+	}()
+
+	var index uint64
+	for {
+		acquired, _, err := client.KV().Acquire(&api.KVPair{Key: key, Value: e.Value, Session: id}, (&api.WriteOptions{}).WithContext(sctx))
+		if sctx.Err() != nil {
+			return e.sessionErr(ctx, renewDone)
+		}
+		if err != nil {
+			return err
+		}
+
+		if acquired {
+			e.lead(sctx, client, key, id, events)
+			return e.sessionErr(ctx, renewDone)
+		}
+
+		// 等待持有者释放
+		index, err = waitKey(sctx, client, key, index, func(kv *api.KVPair) bool {
+			return kv == nil || kv.Session == ""
+		})
+		if sctx.Err() != nil {
+			return e.sessionErr(ctx, renewDone)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (e *Election) sessionErr(ctx context.Context, renewDone chan error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	select {
+	case err := <-renewDone:
+		return err
+	default:
+		return nil
+	}
+}
+
+// lead 作为leader直到key被其它会话持有、会话失效或ctx结束
+func (e *Election) lead(ctx context.Context, client *api.Client, key string, id string, events chan LeadershipEvent) {
+	logrus.WithFields(logrus.Fields{
+		"key":     key,
+		"session": id,
+	}).Info("Consul election elected")
+
+	lctx, cancel := context.WithCancel(ctx)
+	sendEvent(events, LeadershipEvent{Key: key, Leader: true, Session: id})
+	if e.OnElected != nil {
+		e.OnElected(lctx)
+	}
+
+	var index uint64
+	for {
+		var err error
+		index, err = waitKey(ctx, client, key, index, func(kv *api.KVPair) bool {
+			return kv == nil || kv.Session != id
+		})
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+
+		// 查询失败时仍持有会话，由续期判断是否失去leader
+		select {
+		case <-ctx.Done():
+		case <-time.After(electionMinBackoff):
+		}
+	}
+
+	cancel()
+	if e.OnRevoked != nil {
+		e.OnRevoked()
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"key":     key,
+		"session": id,
+	}).Info("Consul election revoked")
+
+	sendEvent(events, LeadershipEvent{Key: key, Leader: false, Session: id})
+}
+
+// renew 每TTL/3续期一次，会话不存在或超过TTL未能续期时返回错误
+func (e *Election) renew(ctx context.Context, client *api.Client, id string) error {
+	interval := e.TTL / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastRenew := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		entry, _, err := client.Session().Renew(id, (&api.WriteOptions{}).WithContext(ctx))
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil && entry == nil {
+			return fmt.Errorf("session %s invalidated", id)
+		}
+		if err == nil {
+			lastRenew = time.Now()
+			continue
+		}
+
+		if time.Since(lastRenew) >= e.TTL {
+			return fmt.Errorf("session %s renew failed: %v", id, err)
+		}
+	}
+}
+
+// waitKey 阻塞查询key直到done返回true
+func waitKey(ctx context.Context, client *api.Client, key string, index uint64, done func(kv *api.KVPair) bool) (uint64, error) {
+	for {
+		kv, meta, err := client.KV().Get(key, (&api.QueryOptions{WaitIndex: index, WaitTime: electionWaitTime}).WithContext(ctx))
+		if err != nil {
+			return index, err
+		}
+
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
+		}
+		if done(kv) {
+			return index, nil
+		}
+	}
+}
+
+// sendEvent 通道已满时丢弃未读取的旧事件
+func sendEvent(events chan LeadershipEvent, ev LeadershipEvent) {
+	for {
+		select {
+		case events <- ev:
+			return
+		default:
+		}
+
+		select {
+		case <-events:
+		default:
+		}
+	}
+}
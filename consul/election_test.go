@@ -0,0 +1,142 @@
+package consul
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rickone/athena/mock"
+)
+
+func nextEvent(t *testing.T, events <-chan LeadershipEvent) LeadershipEvent {
+	t.Helper()
+
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("events channel closed")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for leadership event")
+	}
+	return LeadershipEvent{}
+}
+
+func newTestElection(server *mock.Consul, name string) *Election {
+	e := NewElection(server.Address())
+	e.TTL = 300 * time.Millisecond
+	e.LockDelay = 0
+	e.Value = []byte(name)
+	return e
+}
+
+func TestElection(t *testing.T) {
+	server := mock.NewConsul()
+	defer server.Close()
+
+	var mu sync.Mutex
+	var calls []string
+	record := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, s)
+	}
+
+	a := newTestElection(server, "a")
+	var workCtx context.Context
+	a.OnElected = func(ctx context.Context) {
+		workCtx = ctx
+		record("a elected")
+	}
+	a.OnRevoked = func() {
+		if workCtx.Err() == nil {
+			t.Error("work ctx should be cancelled before OnRevoked")
+		}
+		record("a revoked")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	aEvents := a.Campaign(ctx, "leader/scanner")
+	ev := nextEvent(t, aEvents)
+	if !ev.Leader || ev.Key != "leader/scanner" {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	if kv := server.GetKV("leader/scanner"); kv == nil || string(kv.Value) != "a" || kv.Session != ev.Session {
+		t.Fatalf("unexpected lock: %+v", kv)
+	}
+
+	b := newTestElection(server, "b")
+	bEvents := b.Campaign(ctx, "leader/scanner")
+	select {
+	case ev := <-bEvents:
+		t.Fatalf("b should not be elected while a holds the lock: %+v", ev)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// a放弃后b接任
+	a.Resign()
+	if _, ok := <-aEvents; !ok {
+		t.Fatal("expected revoked event before close")
+	}
+	if _, ok := <-aEvents; ok {
+		t.Fatal("expected closed channel after Resign")
+	}
+
+	ev = nextEvent(t, bEvents)
+	if !ev.Leader {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+
+	// 会话失效后失去leader，并以新会话重新竞选
+	server.InvalidateSession(ev.Session)
+	lost := nextEvent(t, bEvents)
+	if lost.Leader || lost.Session != ev.Session {
+		t.Fatalf("unexpected event: %+v", lost)
+	}
+	again := nextEvent(t, bEvents)
+	if !again.Leader || again.Session == ev.Session {
+		t.Fatalf("unexpected event: %+v", again)
+	}
+
+	b.Resign()
+	if sessions := server.Sessions(); len(sessions) != 0 {
+		t.Fatalf("sessions not destroyed: %v", sessions)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != 2 || calls[0] != "a elected" || calls[1] != "a revoked" {
+		t.Fatalf("unexpected callbacks: %v", calls)
+	}
+}
+
+func TestElectionRenew(t *testing.T) {
+	server := mock.NewConsul()
+	defer server.Close()
+
+	e := newTestElection(server, "a")
+	ctx, cancel := context.WithCancel(context.Background())
+	events := e.Campaign(ctx, "leader/renew")
+
+	ev := nextEvent(t, events)
+	// 经过多个TTL仍保持leader
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event while renewing: %+v", ev)
+	case <-time.After(time.Second):
+	}
+	if kv := server.GetKV("leader/renew"); kv == nil || kv.Session != ev.Session {
+		t.Fatalf("lock lost: %+v", kv)
+	}
+
+	cancel()
+	for range events {
+	}
+	if kv := server.GetKV("leader/renew"); kv == nil || kv.Session != "" {
+		t.Fatalf("lock not released: %+v", kv)
+	}
+}
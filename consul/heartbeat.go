@@ -0,0 +1,131 @@
+package consul
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/sirupsen/logrus"
+)
+
+// TTLCheck 由服务自身心跳维持的检查，超过ttl未心跳即变为critical，
+// 适用于agent无法主动访问服务的场景(如NAT后)
+func TTLCheck(ttl time.Duration, deregisterAfter time.Duration) *api.AgentServiceCheck {
+	return &api.AgentServiceCheck{
+		TTL:                            ttl.String(),
+		DeregisterCriticalServiceAfter: deregisterAfter.String(),
+	}
+}
+
+// CheckID 注册时内联检查的ID
+func (r *Register) CheckID() string {
+	return "service:" + r.serviceID()
+}
+
+func (r *Register) isTTL() bool {
+	return r.Check != nil && r.Check.TTL != ""
+}
+
+// Heartbeat 每ttl/3心跳一次直到ctx结束，阻塞调用
+//   probe非nil时先探测服务自身，失败则上报critical，进程卡死时心跳随之停止
+//   agent重启丢失注册信息时自动重新注册
+func (r *Register) Heartbeat(ctx context.Context, address string, ttl time.Duration, probe func(ctx context.Context) error) {
+	cfg := api.DefaultConfig()
+	cfg.Address = address
+
+	client, err := api.NewClient(cfg)
+	if err != nil {
+		logrus.WithField("err", err.Error()).Error("Consul heartbeat create client failed")
+		return
+	}
+
+	interval := ttl / 3
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		r.beat(ctx, client, address, interval, probe)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Register) beat(ctx context.Context, client *api.Client, address string, timeout time.Duration, probe func(ctx context.Context) error) {
+	status, output := api.HealthPassing, ""
+	if probe != nil {
+		probeCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := probe(probeCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			status, output = api.HealthCritical, err.Error()
+		}
+	}
+
+	err := client.Agent().UpdateTTL(r.CheckID(), output, status)
+	if err == nil {
+		return
+	}
+
+	// 检查不存在，通常是agent重启，重新注册后再上报
+	logrus.WithFields(logrus.Fields{
+		"service": r.Service,
+		"err":     err.Error(),
+	}).Warn("Consul heartbeat failed, re-registering")
+
+	if err := r.Register(address); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"service": r.Service,
+			"err":     err.Error(),
+		}).Error("Consul re-register failed")
+		return
+	}
+	if err := client.Agent().UpdateTTL(r.CheckID(), output, status); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"service": r.Service,
+			"err":     err.Error(),
+		}).Error("Consul heartbeat failed")
+	}
+}
+
+// Drain 先将节点标记为critical让调用方摘除流量，等待period后再注销
+// TTL检查直接上报critical，其它检查通过维护模式实现
+func (r *Register) Drain(address string, period time.Duration) error {
+	cfg := api.DefaultConfig()
+	cfg.Address = address
+
+	client, err := api.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"service": r.Service,
+		"period":  period.String(),
+	}).Info("Consul draining")
+
+	if r.isTTL() {
+		err = client.Agent().UpdateTTL(r.CheckID(), "draining", api.HealthCritical)
+	} else {
+		err = client.Agent().EnableServiceMaintenance(r.serviceID(), "draining")
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"service": r.Service,
+			"err":     err.Error(),
+		}).Error("Consul mark critical failed")
+	} else {
+		time.Sleep(period)
+	}
+
+	return r.Deregister(address)
+}
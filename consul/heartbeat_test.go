@@ -0,0 +1,102 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/rickone/athena/mock"
+)
+
+func waitStatus(t *testing.T, server *mock.Consul, id string, status string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for server.ServiceStatus(id) != status {
+		if time.Now().After(deadline) {
+			t.Fatalf("service %s status %q, want %q", id, server.ServiceStatus(id), status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	server := mock.NewConsul()
+	defer server.Close()
+
+	r := &Register{Service: "user", Address: "10.0.0.1", Port: 80, Check: TTLCheck(150*time.Millisecond, time.Minute)}
+	if err := r.Register(server.Address()); err != nil {
+		t.Fatal(err)
+	}
+	id := r.serviceID()
+	if server.ServiceStatus(id) != api.HealthCritical {
+		t.Fatal("TTL check should start critical")
+	}
+
+	var failing atomic.Value
+	failing.Store(false)
+	probe := func(ctx context.Context) error {
+		if failing.Load().(bool) {
+			return errors.New("deadlock")
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Heartbeat(ctx, server.Address(), 150*time.Millisecond, probe)
+		close(done)
+	}()
+	waitStatus(t, server, id, api.HealthPassing)
+
+	// agent重启后自动重新注册
+	server.ResetAgent()
+	waitStatus(t, server, id, api.HealthPassing)
+
+	failing.Store(true)
+	waitStatus(t, server, id, api.HealthCritical)
+	if server.ServiceOutput(id) != "deadlock" {
+		t.Fatalf("unexpected output: %q", server.ServiceOutput(id))
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Heartbeat did not stop")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	server := mock.NewConsul()
+	defer server.Close()
+
+	for _, check := range []*api.AgentServiceCheck{TTLCheck(time.Second, time.Minute), {HTTP: "http://10.0.0.1/health", Interval: "3s"}} {
+		r := &Register{Service: "user", Address: "10.0.0.1", Port: 80, Check: check}
+		if err := r.Register(server.Address()); err != nil {
+			t.Fatal(err)
+		}
+		server.SetServiceStatus(r.serviceID(), api.HealthPassing)
+
+		done := make(chan error)
+		go func() {
+			done <- r.Drain(server.Address(), 200*time.Millisecond)
+		}()
+
+		waitStatus(t, server, r.serviceID(), api.HealthCritical)
+		if server.Service(r.serviceID()) == nil {
+			t.Fatal("service deregistered before drain period")
+		}
+
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if server.Service(r.serviceID()) != nil {
+			t.Fatal("service not deregistered after drain")
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package consul
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rickone/athena/config"
+	"github.com/rickone/athena/metrics"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// OutlierBalancerName 在consul_weighted的基础上暂时摘除连续失败的节点
+	OutlierBalancerName = "consul_outlier"
+)
+
+func init() {
+	balancer.Register(&outlierBalancerBuilder{})
+}
+
+// OutlierConfig 对应配置中的outlier_ejection段:
+//   outlier_ejection:
+//     consecutive_failures: 5    # 连续失败次数达到后摘除
+//     base_ejection_time: 30s    # 第n次摘除的时长为n*base_ejection_time
+//     max_ejection_time: 5m
+//     max_ejection_percent: 50   # 同时摘除的节点不超过该比例，单节点不会被摘除
+type OutlierConfig struct {
+	ConsecutiveFailures int           `default:"5"`
+	BaseEjectionTime    time.Duration `default:"30s"`
+	MaxEjectionTime     time.Duration `default:"5m"`
+	MaxEjectionPercent  int           `default:"50"`
+}
+
+// LoadOutlierConfig 非正值使用注释中的默认值
+func LoadOutlierConfig() (*OutlierConfig, error) {
+	var conf OutlierConfig
+	if err := config.Unmarshal(&conf, "outlier_ejection"); err != nil {
+		return nil, err
+	}
+	if conf.ConsecutiveFailures <= 0 {
+		conf.ConsecutiveFailures = 5
+	}
+	if conf.BaseEjectionTime <= 0 {
+		conf.BaseEjectionTime = 30 * time.Second
+	}
+	if conf.MaxEjectionTime <= 0 {
+		conf.MaxEjectionTime = 5 * time.Minute
+	}
+	if conf.MaxEjectionPercent <= 0 {
+		conf.MaxEjectionPercent = 50
+	}
+	return &conf, nil
+}
+
+type outlierBalancerBuilder struct{}
+
+func (b *outlierBalancerBuilder) Name() string {
+	return OutlierBalancerName
+}
+
+// Build 每个ClientConn独立统计节点的失败次数
+func (b *outlierBalancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	conf, err := LoadOutlierConfig()
+	if err != nil {
+		logrus.WithField("err", err.Error()).Error("Load outlier ejection config failed")
+		conf = &OutlierConfig{ConsecutiveFailures: 5, BaseEjectionTime: 30 * time.Second, MaxEjectionTime: 5 * time.Minute, MaxEjectionPercent: 50}
+	}
+
+	detector := newOutlierDetector(opts.Target.Endpoint, conf)
+	return base.NewBalancerBuilder(OutlierBalancerName, &outlierPickerBuilder{detector: detector}, base.Config{HealthCheck: true}).Build(cc, opts)
+}
+
+type outlierNode struct {
+	failures     int
+	ejections    int
+	ejectedUntil time.Time
+}
+
+type outlierDetector struct {
+	service string
+	conf    *OutlierConfig
+	now     func() time.Time
+
+	mu    sync.Mutex
+	nodes map[string]*outlierNode
+}
+
+func newOutlierDetector(service string, conf *OutlierConfig) *outlierDetector {
+	return &outlierDetector{
+		service: service,
+		conf:    conf,
+		now:     time.Now,
+		nodes:   map[string]*outlierNode{},
+	}
+}
+
+// retain 丢弃已不在节点列表中的统计
+func (d *outlierDetector) retain(nodes []*weightedNode) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	keep := make(map[string]*outlierNode, len(nodes))
+	for _, node := range nodes {
+		if on, ok := d.nodes[node.addr]; ok {
+			keep[node.addr] = on
+		}
+	}
+	d.nodes = keep
+	d.updateGauge(d.now())
+}
+
+// available 返回未被摘除的节点，全部被摘除时返回全部
+func (d *outlierDetector) available(nodes []*weightedNode) []*weightedNode {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	var healthy []*weightedNode
+	for _, node := range nodes {
+		if on, ok := d.nodes[node.addr]; ok && now.Before(on.ejectedUntil) {
+			continue
+		}
+		healthy = append(healthy, node)
+	}
+	if len(healthy) == 0 {
+		return nodes
+	}
+	return healthy
+}
+
+// record 记录一次调用结果，total为当前节点总数，用于限制摘除比例
+func (d *outlierDetector) record(addr string, total int, err error) {
+	failed, ok := isNodeFailure(err)
+	if !ok {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	on := d.nodes[addr]
+	if on == nil {
+		on = &outlierNode{}
+		d.nodes[addr] = on
+	}
+
+	if !failed {
+		on.failures = 0
+		// 恢复后稳定一段时间，摘除时长重新计算
+		if on.ejections > 0 && now.After(on.ejectedUntil.Add(d.conf.MaxEjectionTime)) {
+			on.ejections = 0
+		}
+		return
+	}
+
+	on.failures++
+	if on.failures < d.conf.ConsecutiveFailures || now.Before(on.ejectedUntil) {
+		return
+	}
+
+	ejected := 0
+	for _, n := range d.nodes {
+		if now.Before(n.ejectedUntil) {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > total*d.conf.MaxEjectionPercent {
+		return
+	}
+
+	on.ejections++
+	on.failures = 0
+	duration := d.conf.BaseEjectionTime * time.Duration(on.ejections)
+	if d.conf.MaxEjectionTime > 0 && duration > d.conf.MaxEjectionTime {
+		duration = d.conf.MaxEjectionTime
+	}
+	on.ejectedUntil = now.Add(duration)
+
+	logrus.WithFields(logrus.Fields{
+		"service":  d.service,
+		"addr":     addr,
+		"duration": duration.String(),
+	}).Warn("Outlier node ejected")
+	metrics.NewCounter("outlier_ejection", "service", d.service, "addr", addr).Inc(1)
+	d.updateGauge(now)
+}
+
+func (d *outlierDetector) updateGauge(now time.Time) {
+	ejected := 0
+	for _, on := range d.nodes {
+		if now.Before(on.ejectedUntil) {
+			ejected++
+		}
+	}
+	metrics.NewGauge("outlier_ejected", "service", d.service).Update(int64(ejected))
+}
+
+// isNodeFailure 区分节点故障和业务错误，ok为false表示不计入(如调用方取消)
+func isNodeFailure(err error) (failed bool, ok bool) {
+	if err == nil {
+		return false, true
+	}
+
+	switch code := status.Code(err); code {
+	case codes.Canceled:
+		return false, false
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown, codes.DataLoss:
+		return true, true
+	default:
+		// errcode中5xx开头的为服务端失败
+		return int(code) >= 500000 && int(code) < 600000, true
+	}
+}
+
+type outlierPickerBuilder struct {
+	detector *outlierDetector
+}
+
+func (b *outlierPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	p := &outlierPicker{weightedPicker: newWeightedPicker(info), detector: b.detector}
+	b.detector.retain(p.nodes)
+	return p
+}
+
+type outlierPicker struct {
+	*weightedPicker
+	detector *outlierDetector
+}
+
+func (p *outlierPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	node := p.pick(info.Ctx, p.detector.available(p.nodes))
+	total := len(p.nodes)
+	return balancer.PickResult{
+		SubConn: node.sc,
+		Done: func(di balancer.DoneInfo) {
+			p.detector.record(node.addr, total, di.Err)
+		},
+	}, nil
+}
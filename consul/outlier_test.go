@@ -0,0 +1,134 @@
+package consul
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rickone/athena/config"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+func TestOutlierPicker(t *testing.T) {
+	now := time.Now()
+	detector := newOutlierDetector("user", &OutlierConfig{
+		ConsecutiveFailures: 3,
+		BaseEjectionTime:    10 * time.Second,
+		MaxEjectionTime:     time.Minute,
+		MaxEjectionPercent:  50,
+	})
+	detector.now = func() time.Time { return now }
+
+	a, b, c := &testSubConn{name: "a"}, &testSubConn{name: "b"}, &testSubConn{name: "c"}
+	build := func(scs ...*testSubConn) balancer.Picker {
+		ready := map[balancer.SubConn]base.SubConnInfo{}
+		for _, sc := range scs {
+			ready[sc] = base.SubConnInfo{Address: resolver.Address{Addr: sc.name + ":80"}}
+		}
+		return (&outlierPickerBuilder{detector: detector}).Build(base.PickerBuildInfo{ReadySCs: ready})
+	}
+	picker := build(a, b, c)
+
+	// pickUntil 反复选择直到选中sc，并以err结束调用
+	pickUntil := func(sc *testSubConn, err error) {
+		t.Helper()
+		for i := 0; i < 1000; i++ {
+			res, _ := picker.Pick(balancer.PickInfo{Ctx: context.Background()})
+			if res.SubConn == sc {
+				res.Done(balancer.DoneInfo{Err: err})
+				return
+			}
+			res.Done(balancer.DoneInfo{})
+		}
+		t.Fatalf("%s never picked", sc.name)
+	}
+	picked := func() map[string]bool {
+		seen := map[string]bool{}
+		for i := 0; i < 200; i++ {
+			res, _ := picker.Pick(balancer.PickInfo{Ctx: context.Background()})
+			seen[res.SubConn.(*testSubConn).name] = true
+		}
+		return seen
+	}
+
+	unavailable := status.Error(codes.Unavailable, "down")
+	for i := 0; i < 3; i++ {
+		pickUntil(a, unavailable)
+	}
+	if seen := picked(); seen["a"] || !seen["b"] || !seen["c"] {
+		t.Fatalf("a should be ejected: %v", seen)
+	}
+
+	// 业务错误和调用方取消不计入
+	for i := 0; i < 5; i++ {
+		pickUntil(b, status.Error(codes.NotFound, "not found"))
+		pickUntil(b, status.Error(codes.Canceled, "canceled"))
+	}
+	if seen := picked(); !seen["b"] {
+		t.Fatalf("b should not be ejected: %v", seen)
+	}
+
+	// 3个节点最多摘除1个
+	for i := 0; i < 3; i++ {
+		pickUntil(b, unavailable)
+	}
+	if seen := picked(); !seen["b"] || !seen["c"] {
+		t.Fatalf("b should not be ejected beyond max_ejection_percent: %v", seen)
+	}
+
+	// 到期后恢复，重建picker不丢失状态
+	picker = build(a, b, c)
+	if seen := picked(); seen["a"] {
+		t.Fatalf("a should still be ejected after rebuild: %v", seen)
+	}
+	now = now.Add(11 * time.Second)
+	if seen := picked(); !seen["a"] {
+		t.Fatalf("a should be back: %v", seen)
+	}
+
+	// 再次摘除的时长翻倍
+	for i := 0; i < 3; i++ {
+		pickUntil(a, unavailable)
+	}
+	now = now.Add(11 * time.Second)
+	if seen := picked(); seen["a"] {
+		t.Fatalf("a should be ejected for 20s: %v", seen)
+	}
+	now = now.Add(10 * time.Second)
+	if seen := picked(); !seen["a"] {
+		t.Fatalf("a should be back: %v", seen)
+	}
+
+	// 单节点不会被摘除
+	picker = build(c)
+	for i := 0; i < 5; i++ {
+		pickUntil(c, unavailable)
+	}
+	if seen := picked(); !seen["c"] {
+		t.Fatalf("single node should not be ejected: %v", seen)
+	}
+}
+
+func TestLoadOutlierConfig(t *testing.T) {
+	config.UpdateValue("outlier_ejection", nil)
+	defer config.UpdateValue("outlier_ejection", nil)
+
+	want := OutlierConfig{ConsecutiveFailures: 5, BaseEjectionTime: 30 * time.Second, MaxEjectionTime: 5 * time.Minute, MaxEjectionPercent: 50}
+	conf, err := LoadOutlierConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *conf != want {
+		t.Fatalf("unexpected defaults: %+v", conf)
+	}
+
+	config.UpdateValue("outlier_ejection", map[interface{}]interface{}{"consecutive_failures": 3, "max_ejection_percent": 0})
+	want.ConsecutiveFailures = 3
+	if conf, _ = LoadOutlierConfig(); *conf != want {
+		t.Fatalf("zero values not defaulted: %+v", conf)
+	}
+}
@@ -3,8 +3,15 @@ package consul
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/rickone/athena/config"
+)
+
+const (
+	CheckAgent = "agent"
+	CheckTTL   = "ttl"
 )
 
 type Register struct {
@@ -12,6 +19,50 @@ type Register struct {
 	Address string
 	Port    int
 	Check   *api.AgentServiceCheck
+	Tags    []string
+	Meta    map[string]string
+	Weights *api.AgentWeights
+}
+
+// RegisterConfig 注册附带的标签、元数据和权重，对应配置中的registry段:
+//   registry:
+//     tags: [canary]
+//     meta: {version: 1.2.0, zone: gz, git_sha: 1a2b3c}
+//     weights: {passing: 1, warning: 1}
+//     check: ttl          # agent(默认，由agent访问服务)|ttl(服务自身心跳)
+//     ttl: 10s
+//     drain_period: 5s    # 注销前先标记critical并等待，让调用方摘除流量
+type RegisterConfig struct {
+	Tags    []string
+	Meta    map[string]string
+	Weights struct {
+		Passing int `default:"1"`
+		Warning int `default:"1"`
+	}
+	Check       string        `default:"agent"`
+	TTL         time.Duration `default:"10s"`
+	DrainPeriod time.Duration
+}
+
+func LoadRegisterConfig() (*RegisterConfig, error) {
+	var conf RegisterConfig
+	if err := config.Unmarshal(&conf, "registry"); err != nil {
+		return nil, err
+	}
+	if conf.Check == "" {
+		conf.Check = CheckAgent
+	}
+	// consul要求权重大于0，未配置或非正值时使用1
+	if conf.Weights.Passing < 1 {
+		conf.Weights.Passing = 1
+	}
+	if conf.Weights.Warning < 1 {
+		conf.Weights.Warning = 1
+	}
+	if conf.Check != CheckAgent && conf.Check != CheckTTL {
+		return nil, fmt.Errorf("registry.check must be %s or %s, got %q", CheckAgent, CheckTTL, conf.Check)
+	}
+	return &conf, nil
 }
 
 func (r *Register) serviceID() string {
@@ -27,13 +78,20 @@ func (r *Register) Register(address string) error {
 		return err
 	}
 
+	tags := r.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
 	log.Printf("Consul register: %+v\n", *r)
 	return client.Agent().ServiceRegister(&api.AgentServiceRegistration{
 		ID:      r.serviceID(), // 服务节点的名称
 		Name:    r.Service,     // 服务名称
-		Tags:    []string{},    // tag，可以为空
+		Tags:    tags,          // tag，可以为空
 		Port:    r.Port,        // 服务端口
 		Address: r.Address,     // 服务 IP
+		Meta:    r.Meta,
+		Weights: r.Weights,
 		Check:   r.Check,
 	})
 }
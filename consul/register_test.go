@@ -0,0 +1,36 @@
+package consul
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rickone/athena/config"
+)
+
+func TestLoadRegisterConfig(t *testing.T) {
+	config.UpdateValue("registry", nil)
+	defer config.UpdateValue("registry", nil)
+
+	// 没有registry段时使用默认值
+	conf, err := LoadRegisterConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Check != CheckAgent || conf.TTL != 10*time.Second || conf.Weights.Passing != 1 || conf.Weights.Warning != 1 {
+		t.Fatalf("unexpected defaults: %+v", conf)
+	}
+
+	config.UpdateValue("registry", map[interface{}]interface{}{"weights": map[interface{}]interface{}{"passing": 0, "warning": -1}})
+	if conf, err = LoadRegisterConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Weights.Passing != 1 || conf.Weights.Warning != 1 {
+		t.Fatalf("weights not clamped: %+v", conf.Weights)
+	}
+
+	config.UpdateValue("registry", nil)
+	config.UpdateValue("registry", map[interface{}]interface{}{"check": "http"})
+	if _, err := LoadRegisterConfig(); err == nil {
+		t.Fatal("expected error for unknown check")
+	}
+}
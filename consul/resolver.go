@@ -1,8 +1,13 @@
 package consul
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/consul/api"
@@ -10,6 +15,12 @@ import (
 	"google.golang.org/grpc/resolver"
 )
 
+const (
+	resolveWaitTime   = 5 * time.Minute
+	resolveMinBackoff = 1 * time.Second
+	resolveMaxBackoff = 30 * time.Second
+)
+
 func init() {
 	resolver.Register(&Builder{})
 }
@@ -17,9 +28,19 @@ func init() {
 type Builder struct {
 }
 
+// Build 目标格式 consul://<consul-address>/<service>[?tag=a&tag=b&dc=dc2&near=_agent&healthy=true]
+//   tag     只选带全部标签的节点，可重复
+//   dc      数据中心
+//   near    按到该节点的网络距离排序，_agent表示本地agent
+//   healthy 默认true，只选健康检查通过的节点
 func (cb *Builder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
 	log.Printf("Consul build target: %v\n", target)
 
+	q, err := parseQuery(target.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
 	config := api.DefaultConfig()
 	config.Address = target.Authority
 
@@ -28,22 +49,19 @@ func (cb *Builder) Build(target resolver.Target, cc resolver.ClientConn, opts re
 		return nil, err
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	cr := &Resolver{
-		client:    client,
-		name:      target.Endpoint,
-		cc:        cc,
-		lastIndex: 0,
-	}
-
-	go func() {
-		for {
-			err := cr.resolve()
-			if err != nil {
-				logrus.WithField("err", err.Error()).Error("Consul resolve failed")
-				time.Sleep(2 * time.Second)
-			}
-		}
-	}()
+		client: client,
+		name:   q.service,
+		query:  q,
+		cc:     cc,
+		ctx:    ctx,
+		cancel: cancel,
+		wakeup: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+
+	go cr.watch()
 	return cr, nil
 }
 
@@ -51,25 +69,142 @@ func (cb *Builder) Scheme() string {
 	return "consul"
 }
 
+type query struct {
+	service     string
+	tags        []string
+	dc          string
+	near        string
+	passingOnly bool
+}
+
+func parseQuery(endpoint string) (*query, error) {
+	q := &query{passingOnly: true}
+
+	ss := strings.SplitN(endpoint, "?", 2)
+	q.service = ss[0]
+	if q.service == "" {
+		return nil, fmt.Errorf("consul target missing service name: %q", endpoint)
+	}
+	if len(ss) == 1 {
+		return q, nil
+	}
+
+	values, err := url.ParseQuery(ss[1])
+	if err != nil {
+		return nil, err
+	}
+
+	q.tags = values["tag"]
+	q.dc = values.Get("dc")
+	q.near = values.Get("near")
+	if healthy := values.Get("healthy"); healthy != "" {
+		q.passingOnly, err = strconv.ParseBool(healthy)
+		if err != nil {
+			return nil, fmt.Errorf("consul target invalid healthy=%q", healthy)
+		}
+	}
+	return q, nil
+}
+
 type Resolver struct {
-	client    *api.Client
-	name      string
-	cc        resolver.ClientConn
-	lastIndex uint64
+	client *api.Client
+	name   string
+	query  *query
+	cc     resolver.ClientConn
+	nodes  map[string]*nodeAttrs
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wakeup chan struct{}
+	done   chan struct{}
+
+	mu          sync.Mutex
+	lastIndex   uint64
+	immediate   bool
+	forced      time.Time
+	cancelQuery context.CancelFunc
+}
+
+func (cr *Resolver) watch() {
+	defer close(cr.done)
+
+	backoff := resolveMinBackoff
+	for {
+		err := cr.resolve()
+		if cr.ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			backoff = resolveMinBackoff
+			continue
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"service": cr.name,
+			"err":     err.Error(),
+		}).Error("Consul resolve failed")
+		cr.cc.ReportError(err)
+
+		select {
+		case <-cr.ctx.Done():
+			return
+		case <-cr.wakeup:
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > resolveMaxBackoff {
+			backoff = resolveMaxBackoff
+		}
+	}
 }
 
 func (cr *Resolver) resolve() error {
-	services, meta, err := cr.client.Health().Service(cr.name, "", true, &api.QueryOptions{WaitIndex: cr.lastIndex})
+	cr.mu.Lock()
+	index := cr.lastIndex
+	if cr.immediate {
+		index = 0
+		cr.immediate = false
+	}
+	ctx, cancel := context.WithCancel(cr.ctx)
+	cr.cancelQuery = cancel
+	cr.mu.Unlock()
+	defer cancel()
+
+	opts := (&api.QueryOptions{
+		WaitIndex:  index,
+		WaitTime:   resolveWaitTime,
+		Datacenter: cr.query.dc,
+		Near:       cr.query.near,
+	}).WithContext(ctx)
+
+	services, meta, err := cr.client.Health().ServiceMultipleTags(cr.name, cr.query.tags, cr.query.passingOnly, opts)
 	if err != nil {
+		if ctx.Err() != nil && cr.ctx.Err() == nil {
+			// 被ResolveNow打断，立即重新查询
+			return nil
+		}
 		return err
 	}
-	cr.lastIndex = meta.LastIndex
+
+	cr.mu.Lock()
+	if meta.LastIndex < cr.lastIndex {
+		cr.lastIndex = 0
+	} else {
+		cr.lastIndex = meta.LastIndex
+	}
+	cr.mu.Unlock()
 
 	var newAddrs []resolver.Address
+	nodes := make(map[string]*nodeAttrs, len(services))
 	for _, service := range services {
 		addr := fmt.Sprintf("%v:%v", service.Service.Address, service.Service.Port)
-		newAddrs = append(newAddrs, resolver.Address{Addr: addr})
+		na := newAttributes(service, cr.nodes[addr])
+		nodes[addr] = na
+		newAddrs = append(newAddrs, resolver.Address{Addr: addr, Attributes: na.attrs})
 	}
+	cr.nodes = nodes
 
 	if len(newAddrs) == 0 {
 		logrus.WithField("service", cr.name).Warn("Consul resolve NONE node!")
@@ -79,8 +214,30 @@ func (cr *Resolver) resolve() error {
 	return nil
 }
 
+// ResolveNow 打断当前的阻塞查询并立即重新查询；gRPC在每次子连接失败时调用，
+// 距上次打断不足resolveMinBackoff时忽略，避免节点抖动时频繁全量查询
 func (cr *Resolver) ResolveNow(opt resolver.ResolveNowOptions) {
+	cr.mu.Lock()
+	now := time.Now()
+	if now.Sub(cr.forced) < resolveMinBackoff {
+		cr.mu.Unlock()
+		return
+	}
+	cr.forced = now
+	cr.immediate = true
+	if cr.cancelQuery != nil {
+		cr.cancelQuery()
+	}
+	cr.mu.Unlock()
+
+	select {
+	case cr.wakeup <- struct{}{}:
+	default:
+	}
 }
 
+// Close 停止查询并等待监听协程退出
 func (cr *Resolver) Close() {
+	cr.cancel()
+	<-cr.done
 }
@@ -0,0 +1,203 @@
+package consul
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/rickone/athena/mock"
+	"google.golang.org/grpc/resolver"
+)
+
+type testClientConn struct {
+	resolver.ClientConn
+
+	mu     sync.Mutex
+	states []resolver.State
+	update chan struct{}
+}
+
+func newTestClientConn() *testClientConn {
+	return &testClientConn{update: make(chan struct{}, 16)}
+}
+
+func (cc *testClientConn) UpdateState(state resolver.State) {
+	cc.mu.Lock()
+	cc.states = append(cc.states, state)
+	cc.mu.Unlock()
+
+	select {
+	case cc.update <- struct{}{}:
+	default:
+	}
+}
+
+func (cc *testClientConn) ReportError(err error) {}
+
+func (cc *testClientConn) wait(t *testing.T) resolver.State {
+	t.Helper()
+
+	select {
+	case <-cc.update:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for resolver update")
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.states[len(cc.states)-1]
+}
+
+func registerService(t *testing.T, consul *mock.Consul, reg *api.AgentServiceRegistration) {
+	t.Helper()
+
+	config := api.DefaultConfig()
+	config.Address = consul.Address()
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Agent().ServiceRegister(reg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func buildResolver(t *testing.T, consul *mock.Consul, endpoint string) (*Resolver, *testClientConn) {
+	t.Helper()
+
+	cc := newTestClientConn()
+	r, err := (&Builder{}).Build(resolver.Target{Scheme: "consul", Authority: consul.Address(), Endpoint: endpoint}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.(*Resolver), cc
+}
+
+func TestParseQuery(t *testing.T) {
+	q, err := parseQuery("user?tag=canary&tag=v2&dc=dc2&near=_agent&healthy=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.service != "user" || len(q.tags) != 2 || q.tags[1] != "v2" || q.dc != "dc2" || q.near != "_agent" || q.passingOnly {
+		t.Fatalf("unexpected query: %+v", q)
+	}
+
+	q, err = parseQuery("user")
+	if err != nil || !q.passingOnly || q.tags != nil {
+		t.Fatalf("unexpected default query: %+v, %v", q, err)
+	}
+
+	if _, err := parseQuery("?tag=a"); err == nil {
+		t.Fatal("expected error for missing service")
+	}
+	if _, err := parseQuery("user?healthy=maybe"); err == nil {
+		t.Fatal("expected error for invalid healthy")
+	}
+}
+
+func TestResolverUpdate(t *testing.T) {
+	consul := mock.NewConsul()
+	defer consul.Close()
+
+	registerService(t, consul, &api.AgentServiceRegistration{ID: "user-1", Name: "user", Address: "10.0.0.1", Port: 80})
+	registerService(t, consul, &api.AgentServiceRegistration{ID: "user-2", Name: "user", Address: "10.0.0.2", Port: 80, Tags: []string{"canary"}})
+
+	r, cc := buildResolver(t, consul, "user?tag=canary&dc=dc2&near=_agent")
+	defer r.Close()
+
+	state := cc.wait(t)
+	if len(state.Addresses) != 1 || state.Addresses[0].Addr != "10.0.0.2:80" {
+		t.Fatalf("unexpected addresses: %v", state.Addresses)
+	}
+	if !AddressHasTag(state.Addresses[0], "canary") {
+		t.Fatal("expected canary tag on address")
+	}
+
+	query := consul.LastHealthQuery()
+	if query.Get("dc") != "dc2" || query.Get("near") != "_agent" || query.Get("tag") != "canary" {
+		t.Fatalf("unexpected health query: %v", query)
+	}
+	if _, ok := query["passing"]; !ok {
+		t.Fatal("expected passing only query")
+	}
+
+	// 节点变为不健康时从地址列表移除
+	consul.SetServiceStatus("user-2", api.HealthCritical)
+	state = cc.wait(t)
+	if len(state.Addresses) != 0 {
+		t.Fatalf("expected no addresses, got %v", state.Addresses)
+	}
+}
+
+func TestResolverResolveNow(t *testing.T) {
+	consul := mock.NewConsul()
+	defer consul.Close()
+
+	registerService(t, consul, &api.AgentServiceRegistration{ID: "user-1", Name: "user", Address: "10.0.0.1", Port: 80})
+
+	r, cc := buildResolver(t, consul, "user")
+	defer r.Close()
+	cc.wait(t)
+
+	// 等待进入阻塞查询后再打断
+	time.Sleep(100 * time.Millisecond)
+	r.ResolveNow(resolver.ResolveNowOptions{})
+
+	state := cc.wait(t)
+	if len(state.Addresses) != 1 {
+		t.Fatalf("unexpected addresses: %v", state.Addresses)
+	}
+
+	// resolveMinBackoff内的再次调用被忽略，不打断阻塞查询
+	time.Sleep(100 * time.Millisecond)
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	select {
+	case <-cc.update:
+		t.Fatal("unexpected update within backoff")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestResolverClose(t *testing.T) {
+	consul := mock.NewConsul()
+	defer consul.Close()
+
+	r, cc := buildResolver(t, consul, "user")
+	cc.wait(t)
+	time.Sleep(100 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		r.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close did not cancel the blocking query")
+	}
+
+	select {
+	case <-r.done:
+	default:
+		t.Fatal("watch goroutine still running")
+	}
+}
+
+func TestResolverBackoff(t *testing.T) {
+	consul := mock.NewConsul()
+	defer consul.Close()
+	consul.SetFailing(true)
+
+	r, cc := buildResolver(t, consul, "user")
+	defer r.Close()
+
+	time.Sleep(100 * time.Millisecond)
+	consul.SetFailing(false)
+
+	// ResolveNow跳过退避等待
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	cc.wait(t)
+}
@@ -0,0 +1,197 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/rickone/athena/consul"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	consulWaitTime   = 5 * time.Minute
+	consulMinBackoff = 1 * time.Second
+	consulMaxBackoff = 30 * time.Second
+)
+
+// Consul 基于Consul agent注册和健康检查的Registry
+type Consul struct {
+	address string
+
+	mu         sync.Mutex
+	heartbeats map[string]*heartbeat
+}
+
+type heartbeat struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewConsul(address string) *Consul {
+	return &Consul{
+		address:    address,
+		heartbeats: map[string]*heartbeat{},
+	}
+}
+
+func (c *Consul) register(ins *Instance) *consul.Register {
+	r := &consul.Register{
+		Service: ins.Service,
+		Address: ins.Address,
+		Port:    ins.Port,
+		Tags:    ins.Tags,
+		Meta:    ins.Meta,
+		Weights: &api.AgentWeights{
+			Passing: ins.weight(),
+			Warning: ins.Weights.Warning,
+		},
+	}
+	if r.Weights.Warning <= 0 {
+		r.Weights.Warning = r.Weights.Passing
+	}
+
+	if ins.Check != nil && ins.Check.TTL > 0 {
+		r.Check = consul.TTLCheck(ins.Check.TTL, ins.Check.DeregisterAfter)
+	} else if ins.Check != nil {
+		r.Check = &api.AgentServiceCheck{
+			GRPC:                           ins.Check.GRPC,
+			GRPCUseTLS:                     ins.Check.TLS && ins.Check.GRPC != "",
+			TLSSkipVerify:                  ins.Check.TLS,
+			HTTP:                           ins.Check.HTTP,
+			Interval:                       ins.Check.Interval.String(),
+			DeregisterCriticalServiceAfter: ins.Check.DeregisterAfter.String(),
+		}
+	}
+	return r
+}
+
+// Register TTL检查时启动心跳协程，直到Deregister
+func (c *Consul) Register(ins *Instance) error {
+	r := c.register(ins)
+	if err := r.Register(c.address); err != nil {
+		return err
+	}
+
+	if ins.Check == nil || ins.Check.TTL <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	hb := &heartbeat{cancel: cancel, done: make(chan struct{})}
+	c.stopHeartbeat(ins.id())
+
+	c.mu.Lock()
+	c.heartbeats[ins.id()] = hb
+	c.mu.Unlock()
+
+	go func() {
+		defer close(hb.done)
+		r.Heartbeat(ctx, c.address, ins.Check.TTL, ins.Check.Probe)
+	}()
+	return nil
+}
+
+// Deregister 先停止心跳，DrainPeriod大于0时标记critical并等待后再注销
+func (c *Consul) Deregister(ins *Instance) error {
+	c.stopHeartbeat(ins.id())
+
+	r := c.register(ins)
+	if ins.DrainPeriod > 0 {
+		return r.Drain(c.address, ins.DrainPeriod)
+	}
+	return r.Deregister(c.address)
+}
+
+func (c *Consul) stopHeartbeat(id string) {
+	c.mu.Lock()
+	hb := c.heartbeats[id]
+	delete(c.heartbeats, id)
+	c.mu.Unlock()
+
+	if hb != nil {
+		hb.cancel()
+		<-hb.done
+	}
+}
+
+// Target grpc.Dial使用consul://解析器，支持标签和健康过滤
+func (c *Consul) Target(service string) string {
+	return fmt.Sprintf("consul://%s/%s", c.address, service)
+}
+
+func (c *Consul) Watch(ctx context.Context, service string) (<-chan []*Instance, error) {
+	cfg := api.DefaultConfig()
+	cfg.Address = c.address
+
+	client, err := api.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan []*Instance, 1)
+	go c.watch(ctx, client, service, ch)
+	return ch, nil
+}
+
+func (c *Consul) watch(ctx context.Context, client *api.Client, service string, ch chan []*Instance) {
+	defer close(ch)
+
+	var lastIndex uint64
+	backoff := consulMinBackoff
+	for {
+		opts := (&api.QueryOptions{WaitIndex: lastIndex, WaitTime: consulWaitTime}).WithContext(ctx)
+		entries, meta, err := client.Health().Service(service, "", true, opts)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"service": service,
+				"err":     err.Error(),
+			}).Error("Discovery consul watch failed")
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > consulMaxBackoff {
+				backoff = consulMaxBackoff
+			}
+			continue
+		}
+		backoff = consulMinBackoff
+
+		if meta.LastIndex < lastIndex {
+			lastIndex = 0
+			continue
+		}
+		if meta.LastIndex == lastIndex {
+			continue
+		}
+		lastIndex = meta.LastIndex
+
+		list := make([]*Instance, 0, len(entries))
+		for _, entry := range entries {
+			list = append(list, &Instance{
+				ID:      entry.Service.ID,
+				Service: entry.Service.Service,
+				Address: entry.Service.Address,
+				Port:    entry.Service.Port,
+				Tags:    entry.Service.Tags,
+				Meta:    entry.Service.Meta,
+				Weights: Weights{
+					Passing: entry.Service.Weights.Passing,
+					Warning: entry.Service.Weights.Warning,
+				},
+			})
+		}
+		push(ch, list)
+	}
+}
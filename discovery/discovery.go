@@ -0,0 +1,211 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rickone/athena/config"
+	"github.com/rickone/athena/consul"
+)
+
+const (
+	TypeConsul = "consul"
+	TypeStatic = "static"
+	TypeFile   = "file"
+)
+
+// Registry 服务注册与发现
+type Registry interface {
+	Register(ins *Instance) error
+	Deregister(ins *Instance) error
+	// Watch 立即推送一次当前节点列表，之后每次变化推送，ctx结束后关闭通道；
+	// 消费不及时只保留最新的列表
+	Watch(ctx context.Context, service string) (<-chan []*Instance, error)
+}
+
+// Instance 服务节点
+type Instance struct {
+	ID      string
+	Service string
+	Address string
+	Port    int
+	Tags    []string
+	Meta    map[string]string
+	Weights Weights
+	Check   *Check
+
+	// DrainPeriod 注销前先标记为critical并等待，仅consul使用
+	DrainPeriod time.Duration
+}
+
+type Weights struct {
+	Passing int
+	Warning int
+}
+
+// Check 健康检查，仅consul使用
+//   GRPC/HTTP 由agent定期访问服务，TLS为true时GRPC检查使用TLS且不校验证书
+//   TTL       由服务自身每TTL/3心跳一次，Probe非nil时心跳前先探测服务自身
+type Check struct {
+	GRPC            string
+	HTTP            string
+	TLS             bool
+	Interval        time.Duration
+	DeregisterAfter time.Duration
+
+	TTL   time.Duration
+	Probe func(ctx context.Context) error
+}
+
+func (ins *Instance) id() string {
+	if ins.ID != "" {
+		return ins.ID
+	}
+	return fmt.Sprintf("%s-%s-%d", ins.Service, ins.Address, ins.Port)
+}
+
+func (ins *Instance) Addr() string {
+	return fmt.Sprintf("%s:%d", ins.Address, ins.Port)
+}
+
+func (ins *Instance) weight() int {
+	if ins.Weights.Passing <= 0 {
+		return 1
+	}
+	return ins.Weights.Passing
+}
+
+// LoadConfig 从配置的registry段填充Tags、Meta、Weights、TTL检查和DrainPeriod，见consul.RegisterConfig
+func (ins *Instance) LoadConfig() error {
+	conf, err := consul.LoadRegisterConfig()
+	if err != nil {
+		return err
+	}
+
+	ins.Tags = conf.Tags
+	ins.Meta = conf.Meta
+	ins.Weights = Weights{
+		Passing: conf.Weights.Passing,
+		Warning: conf.Weights.Warning,
+	}
+	ins.DrainPeriod = conf.DrainPeriod
+
+	if conf.Check == consul.CheckTTL {
+		check := &Check{TTL: conf.TTL, DeregisterAfter: time.Minute}
+		if ins.Check != nil {
+			check.Probe = ins.Check.Probe
+			if ins.Check.DeregisterAfter > 0 {
+				check.DeregisterAfter = ins.Check.DeregisterAfter
+			}
+		}
+		ins.Check = check
+	}
+	return nil
+}
+
+// Config 对应配置中的discovery段:
+//   discovery:
+//     type: static            # consul(默认)|static|file
+//     static:
+//       user:
+//         - {address: 127.0.0.1:9000}
+//         - {address: 127.0.0.1:9001, tags: [canary], weight: 2}
+//     file: ./services.yaml   # 格式同static，修改后自动生效
+//     file_interval: 2s
+type Config struct {
+	Type         string `default:"consul"`
+	Static       map[string][]Endpoint
+	File         string
+	FileInterval time.Duration `default:"2s"`
+}
+
+// Endpoint static和file后端中的一个节点
+type Endpoint struct {
+	Address string            `yaml:"address"`
+	Tags    []string          `yaml:"tags"`
+	Meta    map[string]string `yaml:"meta"`
+	Weight  int               `yaml:"weight"`
+}
+
+var (
+	defaultRegistry Registry
+	defaultMu       = sync.Mutex{}
+)
+
+// New 按配置的discovery段创建Registry
+func New() (Registry, error) {
+	var conf Config
+	if err := config.Unmarshal(&conf, "discovery"); err != nil {
+		return nil, err
+	}
+
+	switch conf.Type {
+	case TypeConsul:
+		return NewConsul(config.GetString("service", "consul")), nil
+	case TypeStatic:
+		return NewStatic(conf.Static)
+	case TypeFile:
+		if conf.File == "" {
+			return nil, fmt.Errorf("discovery: type file requires discovery.file")
+		}
+		return NewFile(conf.File, conf.FileInterval)
+	default:
+		return nil, fmt.Errorf("discovery: unknown type %q", conf.Type)
+	}
+}
+
+// Default 进程内共享的Registry，首次使用时按配置创建
+func Default() (Registry, error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultRegistry != nil {
+		return defaultRegistry, nil
+	}
+
+	r, err := New()
+	if err != nil {
+		return nil, err
+	}
+	defaultRegistry = r
+	return r, nil
+}
+
+// SetDefault 替换默认Registry，nil表示下次使用时重新按配置创建
+func SetDefault(r Registry) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	defaultRegistry = r
+}
+
+// Target 返回grpc.Dial使用的目标地址，consul后端使用consul://，其余使用discovery://
+func Target(service string) (string, error) {
+	r, err := Default()
+	if err != nil {
+		return "", err
+	}
+
+	if t, ok := r.(interface{ Target(string) string }); ok {
+		return t.Target(service), nil
+	}
+	return fmt.Sprintf("%s:///%s", Scheme, service), nil
+}
+
+// push 向容量为1的通道发送，通道已满时丢弃未消费的旧值，调用方须是唯一的发送者
+func push(ch chan []*Instance, list []*Instance) {
+	for {
+		select {
+		case ch <- list:
+			return
+		default:
+		}
+
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package discovery
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rickone/athena/config"
+	"github.com/rickone/athena/consul"
+	"github.com/rickone/athena/mock"
+	"google.golang.org/grpc/resolver"
+)
+
+func next(t *testing.T, ch <-chan []*Instance) []*Instance {
+	t.Helper()
+
+	select {
+	case list, ok := <-ch:
+		if !ok {
+			t.Fatal("watch channel closed")
+		}
+		return list
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for instances")
+	}
+	return nil
+}
+
+func addrs(list []*Instance) []string {
+	var result []string
+	for _, ins := range list {
+		result = append(result, ins.Addr())
+	}
+	return result
+}
+
+func TestStatic(t *testing.T) {
+	s, err := NewStatic(map[string][]Endpoint{
+		"user": {{Address: "127.0.0.1:9000"}, {Address: "127.0.0.1:9001", Tags: []string{"canary"}, Weight: 2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, _ := s.Watch(ctx, "user")
+
+	list := next(t, ch)
+	if len(list) != 2 || list[1].Tags[0] != "canary" || list[1].weight() != 2 || list[0].weight() != 1 {
+		t.Fatalf("unexpected instances: %v", addrs(list))
+	}
+
+	ins := &Instance{Service: "user", Address: "127.0.0.1", Port: 9002}
+	s.Register(ins)
+	if list = next(t, ch); len(list) != 3 {
+		t.Fatalf("expected 3 instances, got %v", addrs(list))
+	}
+
+	s.Deregister(ins)
+	if list = next(t, ch); len(list) != 2 {
+		t.Fatalf("expected 2 instances, got %v", addrs(list))
+	}
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("expected closed channel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watch channel not closed")
+	}
+
+	if _, err := NewStatic(map[string][]Endpoint{"user": {{Address: "bad"}}}); err == nil {
+		t.Fatal("expected error for bad address")
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "services.yaml")
+	ioutil.WriteFile(path, []byte("user:\n  - {address: 127.0.0.1:9000}\n"), 0644)
+
+	f, err := NewFile(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, _ := f.Watch(ctx, "user")
+	if list := next(t, ch); len(list) != 1 {
+		t.Fatalf("unexpected instances: %v", addrs(list))
+	}
+
+	ioutil.WriteFile(path, []byte("user:\n  - {address: 127.0.0.1:9000}\n  - {address: 127.0.0.1:9001, tags: [canary]}\n"), 0644)
+	if list := next(t, ch); len(list) != 2 || list[1].Tags[0] != "canary" {
+		t.Fatalf("unexpected instances: %v", addrs(list))
+	}
+
+	// 格式错误时保留上次的节点
+	ioutil.WriteFile(path, []byte("user: [\n"), 0644)
+	time.Sleep(50 * time.Millisecond)
+	if list := f.hub.get("user"); len(list) != 2 {
+		t.Fatalf("expected last good instances, got %v", addrs(list))
+	}
+
+	ioutil.WriteFile(path, []byte("order:\n  - {address: 127.0.0.1:9100}\n"), 0644)
+	if list := next(t, ch); len(list) != 0 {
+		t.Fatalf("expected no instances, got %v", addrs(list))
+	}
+}
+
+func TestConsul(t *testing.T) {
+	server := mock.NewConsul()
+	defer server.Close()
+
+	c := NewConsul(server.Address())
+	if target := c.Target("user"); target != "consul://"+server.Address()+"/user" {
+		t.Fatalf("unexpected target: %s", target)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := c.Watch(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := next(t, ch); len(list) != 0 {
+		t.Fatalf("unexpected instances: %v", addrs(list))
+	}
+
+	ins := &Instance{Service: "user", Address: "10.0.0.1", Port: 80, Tags: []string{"canary"}, Weights: Weights{Passing: 3}}
+	if err := c.Register(ins); err != nil {
+		t.Fatal(err)
+	}
+	reg := server.Service("user-10.0.0.1-80")
+	if reg == nil || reg.Weights.Passing != 3 || reg.Weights.Warning != 3 {
+		t.Fatalf("unexpected registration: %+v", reg)
+	}
+
+	list := next(t, ch)
+	if len(list) != 1 || list[0].Addr() != "10.0.0.1:80" || list[0].Tags[0] != "canary" {
+		t.Fatalf("unexpected instances: %v", addrs(list))
+	}
+
+	if err := c.Deregister(ins); err != nil {
+		t.Fatal(err)
+	}
+	if list := next(t, ch); len(list) != 0 {
+		t.Fatalf("unexpected instances: %v", addrs(list))
+	}
+}
+
+type testClientConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+}
+
+func (cc *testClientConn) UpdateState(state resolver.State) {
+	cc.states <- state
+}
+
+func TestResolver(t *testing.T) {
+	s, _ := NewStatic(map[string][]Endpoint{
+		"user": {{Address: "127.0.0.1:9000", Tags: []string{"canary"}}},
+	})
+	SetDefault(s)
+	defer SetDefault(nil)
+
+	target, err := Target("user")
+	if err != nil || target != "discovery:///user" {
+		t.Fatalf("unexpected target: %s, %v", target, err)
+	}
+
+	cc := &testClientConn{states: make(chan resolver.State, 4)}
+	r, err := (&Builder{}).Build(resolver.Target{Scheme: Scheme, Endpoint: "user"}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := <-cc.states
+	if len(state.Addresses) != 1 || !consul.AddressHasTag(state.Addresses[0], "canary") {
+		t.Fatalf("unexpected state: %v", state)
+	}
+	first := state.Addresses[0]
+
+	s.Register(&Instance{Service: "user", Address: "127.0.0.1", Port: 9001})
+	state = <-cc.states
+	if len(state.Addresses) != 2 || state.Addresses[0] != first {
+		t.Fatalf("expected unchanged address to be reused: %v", state.Addresses)
+	}
+
+	r.Close()
+}
+
+func TestConsulTTL(t *testing.T) {
+	server := mock.NewConsul()
+	defer server.Close()
+
+	c := NewConsul(server.Address())
+	ins := &Instance{
+		Service:     "user",
+		Address:     "10.0.0.1",
+		Port:        80,
+		Check:       &Check{TTL: 150 * time.Millisecond, DeregisterAfter: time.Minute},
+		DrainPeriod: 50 * time.Millisecond,
+	}
+	if err := c.Register(ins); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for server.ServiceStatus("user-10.0.0.1-80") != "passing" {
+		if time.Now().After(deadline) {
+			t.Fatal("heartbeat did not mark service passing")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := c.Deregister(ins); err != nil {
+		t.Fatal(err)
+	}
+
+	// 心跳停止后不会再重新注册
+	time.Sleep(200 * time.Millisecond)
+	if server.Service("user-10.0.0.1-80") != nil {
+		t.Fatal("service registered again after Deregister")
+	}
+}
+
+func TestDefaultWithoutConfig(t *testing.T) {
+	config.UpdateValue("discovery", nil)
+	SetDefault(nil)
+	defer SetDefault(nil)
+
+	// 没有discovery段时默认使用consul
+	r, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.(*Consul); !ok {
+		t.Fatalf("expected consul registry, got %T", r)
+	}
+
+	target, err := Target("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(target, "consul://") || !strings.HasSuffix(target, "/user") {
+		t.Fatalf("unexpected target: %s", target)
+	}
+}
+
+func TestInstanceLoadConfig(t *testing.T) {
+	config.UpdateValue("registry", map[interface{}]interface{}{
+		"check":   "ttl",
+		"weights": map[interface{}]interface{}{"passing": 0, "warning": 3},
+	})
+	defer config.UpdateValue("registry", nil)
+
+	probe := func(ctx context.Context) error { return nil }
+	ins := &Instance{Service: "user", Check: &Check{GRPC: "127.0.0.1:9000/user", DeregisterAfter: 2 * time.Minute, Probe: probe}}
+	if err := ins.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if ins.Weights != (Weights{Passing: 1, Warning: 3}) {
+		t.Fatalf("unexpected weights: %+v", ins.Weights)
+	}
+	if ins.Check.TTL != 10*time.Second || ins.Check.GRPC != "" || ins.Check.DeregisterAfter != 2*time.Minute || ins.Check.Probe == nil {
+		t.Fatalf("unexpected check: %+v", ins.Check)
+	}
+}
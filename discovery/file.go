@@ -0,0 +1,120 @@
+package discovery
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// File 从YAML文件读取节点列表并定期检查修改，格式同discovery.static:
+//   user:
+//     - {address: 127.0.0.1:9000}
+//     - {address: 127.0.0.1:9001, tags: [canary]}
+// 文件由外部维护，Register/Deregister不修改文件
+type File struct {
+	path     string
+	interval time.Duration
+	hub      *hub
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	modTime time.Time
+	size    int64
+}
+
+func NewFile(path string, interval time.Duration) (*File, error) {
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+
+	f := &File{
+		path:     path,
+		interval: interval,
+		hub:      newHub(),
+		done:     make(chan struct{}),
+	}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+
+	var ctx context.Context
+	ctx, f.cancel = context.WithCancel(context.Background())
+	go f.poll(ctx)
+	return f, nil
+}
+
+func (f *File) Register(ins *Instance) error {
+	logrus.WithField("service", ins.Service).Info("Discovery file registry ignores Register")
+	return nil
+}
+
+func (f *File) Deregister(ins *Instance) error {
+	return nil
+}
+
+func (f *File) Watch(ctx context.Context, service string) (<-chan []*Instance, error) {
+	return f.hub.watch(ctx, service), nil
+}
+
+// Close 停止检查文件
+func (f *File) Close() {
+	f.cancel()
+	<-f.done
+}
+
+func (f *File) poll(ctx context.Context) {
+	defer close(f.done)
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := f.reload(); err != nil {
+			// 保留上次成功加载的节点
+			logrus.WithFields(logrus.Fields{
+				"path": f.path,
+				"err":  err.Error(),
+			}).Error("Discovery file reload failed")
+		}
+	}
+}
+
+// reload 文件修改时间或大小变化时重新加载
+func (f *File) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	var services map[string][]Endpoint
+	if err := yaml.Unmarshal(data, &services); err != nil {
+		return err
+	}
+	list, err := parseEndpoints(services)
+	if err != nil {
+		return err
+	}
+
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	f.hub.replace(list)
+	return nil
+}
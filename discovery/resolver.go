@@ -0,0 +1,100 @@
+package discovery
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/rickone/athena/consul"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme 目标格式 discovery:///<service>，节点来自Default()
+const Scheme = "discovery"
+
+func init() {
+	resolver.Register(&Builder{})
+}
+
+type Builder struct {
+}
+
+func (b *Builder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	r, err := Default()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := r.Watch(ctx, target.Endpoint)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	dr := &Resolver{
+		service: target.Endpoint,
+		cc:      cc,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		last:    map[string]*addrState{},
+	}
+	go dr.watch(ch)
+	return dr, nil
+}
+
+func (b *Builder) Scheme() string {
+	return Scheme
+}
+
+type addrState struct {
+	ins  *Instance
+	addr resolver.Address
+}
+
+type Resolver struct {
+	service string
+	cc      resolver.ClientConn
+	cancel  context.CancelFunc
+	done    chan struct{}
+	last    map[string]*addrState
+}
+
+func (r *Resolver) watch(ch <-chan []*Instance) {
+	defer close(r.done)
+
+	for list := range ch {
+		addrs := make([]resolver.Address, 0, len(list))
+		states := make(map[string]*addrState, len(list))
+		for _, ins := range list {
+			state := r.last[ins.Addr()]
+			// 属性未变化时复用旧地址，避免balancer重建连接
+			if state == nil || !reflect.DeepEqual(state.ins, ins) {
+				state = &addrState{
+					ins: ins,
+					addr: resolver.Address{
+						Addr:       ins.Addr(),
+						Attributes: consul.NewAttributes(ins.Tags, ins.Meta, ins.weight()),
+					},
+				}
+			}
+			states[ins.Addr()] = state
+			addrs = append(addrs, state.addr)
+		}
+		r.last = states
+
+		if len(addrs) == 0 {
+			logrus.WithField("service", r.service).Warn("Discovery resolve NONE node!")
+		}
+		r.cc.UpdateState(resolver.State{Addresses: addrs})
+	}
+}
+
+// ResolveNow 节点变化由Registry主动推送，无需处理
+func (r *Resolver) ResolveNow(opt resolver.ResolveNowOptions) {
+}
+
+func (r *Resolver) Close() {
+	r.cancel()
+	<-r.done
+}
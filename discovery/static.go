@@ -0,0 +1,171 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// hub 保存各服务的节点列表并推送给订阅者
+type hub struct {
+	mu       sync.Mutex
+	services map[string][]*Instance
+	watchers map[string]map[chan []*Instance]bool
+}
+
+func newHub() *hub {
+	return &hub{
+		services: map[string][]*Instance{},
+		watchers: map[string]map[chan []*Instance]bool{},
+	}
+}
+
+func (h *hub) get(service string) []*Instance {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.services[service]
+}
+
+func (h *hub) set(service string, list []*Instance) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.setLocked(service, list)
+}
+
+func (h *hub) setLocked(service string, list []*Instance) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].id() < list[j].id()
+	})
+	if reflect.DeepEqual(h.services[service], list) {
+		return
+	}
+
+	if len(list) == 0 {
+		delete(h.services, service)
+	} else {
+		h.services[service] = list
+	}
+	for ch := range h.watchers[service] {
+		push(ch, list)
+	}
+}
+
+// replace 整体替换所有服务，不再出现的服务推送空列表
+func (h *hub) replace(services map[string][]*Instance) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for service := range h.services {
+		if _, ok := services[service]; !ok {
+			h.setLocked(service, nil)
+		}
+	}
+	for service, list := range services {
+		h.setLocked(service, list)
+	}
+}
+
+func (h *hub) watch(ctx context.Context, service string) <-chan []*Instance {
+	ch := make(chan []*Instance, 1)
+
+	h.mu.Lock()
+	if h.watchers[service] == nil {
+		h.watchers[service] = map[chan []*Instance]bool{}
+	}
+	h.watchers[service][ch] = true
+	push(ch, h.services[service])
+	h.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.watchers[service], ch)
+		close(ch)
+	}()
+	return ch
+}
+
+// Static 固定节点列表，Register/Deregister只在进程内生效，适合本地开发和集成测试
+type Static struct {
+	hub *hub
+}
+
+func NewStatic(services map[string][]Endpoint) (*Static, error) {
+	list, err := parseEndpoints(services)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Static{hub: newHub()}
+	s.hub.replace(list)
+	return s, nil
+}
+
+func (s *Static) Register(ins *Instance) error {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	var list []*Instance
+	for _, i := range s.hub.services[ins.Service] {
+		if i.id() != ins.id() {
+			list = append(list, i)
+		}
+	}
+	dup := *ins
+	s.hub.setLocked(ins.Service, append(list, &dup))
+	return nil
+}
+
+func (s *Static) Deregister(ins *Instance) error {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	var list []*Instance
+	for _, i := range s.hub.services[ins.Service] {
+		if i.id() != ins.id() {
+			list = append(list, i)
+		}
+	}
+	s.hub.setLocked(ins.Service, list)
+	return nil
+}
+
+func (s *Static) Watch(ctx context.Context, service string) (<-chan []*Instance, error) {
+	return s.hub.watch(ctx, service), nil
+}
+
+func parseEndpoints(services map[string][]Endpoint) (map[string][]*Instance, error) {
+	result := make(map[string][]*Instance, len(services))
+	for service, endpoints := range services {
+		list := make([]*Instance, 0, len(endpoints))
+		for _, ep := range endpoints {
+			host, portStr, err := net.SplitHostPort(ep.Address)
+			if err != nil {
+				return nil, fmt.Errorf("discovery: service %s: %v", service, err)
+			}
+			port, err := strconv.Atoi(portStr)
+			if err != nil {
+				return nil, fmt.Errorf("discovery: service %s: invalid port in %q", service, ep.Address)
+			}
+
+			list = append(list, &Instance{
+				Service: service,
+				Address: host,
+				Port:    port,
+				Tags:    ep.Tags,
+				Meta:    ep.Meta,
+				Weights: Weights{Passing: ep.Weight, Warning: ep.Weight},
+			})
+		}
+		result[service] = list
+	}
+	return result, nil
+}
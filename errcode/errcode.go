@@ -12,6 +12,10 @@ const (
 	ErrGlcDecode                 // Glc解码
 )
 
+const (
+	ErrUnauthenticated = 401900 + iota // 调用方身份缺失或签名无效
+)
+
 const (
 	ErrRequestLimit  = 403900 + iota // 请求太频繁
 	ErrMutexLock                     // 抢锁失败
@@ -19,6 +23,7 @@ const (
 	ErrBlocked                       // 系统己阻断
 	ErrKeyDuplicated                 // 键冲突
 	ErrChainFailed                   // 链上失败
+	ErrForbidden                     // 调用方或scope无权访问
 )
 
 const (
@@ -32,6 +37,10 @@ const (
 	ErrRpcPanic                   // Rpc panic
 )
 
+const (
+	ErrCircuitOpen = 503900 + iota // 熔断中，请求未发出
+)
+
 func From(err error) (code int, failed bool) {
 	if err != nil {
 		if st, ok := status.FromError(err); ok {
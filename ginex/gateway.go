@@ -0,0 +1,274 @@
+package ginex
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/protobuf/proto"
+	"github.com/rickone/athena/common"
+	"github.com/rickone/athena/config"
+	"github.com/rickone/athena/errcode"
+	"github.com/rickone/athena/grpcex"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const gatewayPath = "gateway"
+
+// GatewayConfig 对应配置中的gateway段，将已链接的pb包中注册的gRPC服务暴露为JSON接口，
+// 未配置routes的unary方法映射为POST <prefix>/<服务全名>/<方法名>，流式方法不暴露；
+// 请求和响应按protobuf的JSON映射编解码(枚举可用名称，int64为字符串，响应使用字段名)，
+// 路径参数和query按字段名(或JSON名)填入请求消息，POST/PUT/PATCH的JSON body先于它们解析:
+//   gateway:
+//     prefix: /api
+//     services:
+//       user.User:                     # gRPC服务全名
+//         target: user                 # grpcex.Client的服务名，默认为proto包名
+//         routes:
+//           GetUser: GET /users/:user_id
+//           CreateUser: POST /users
+//           DeleteUser: DELETE /users/:user_id
+type GatewayConfig struct {
+	Prefix   string
+	Services map[string]GatewayService
+}
+
+type GatewayService struct {
+	Target string
+	Routes map[string]string
+}
+
+// LoadGatewayConfig 读取gateway段
+func LoadGatewayConfig() (*GatewayConfig, error) {
+	var conf GatewayConfig
+	if err := config.Unmarshal(&conf, gatewayPath); err != nil {
+		return nil, err
+	}
+	return &conf, nil
+}
+
+// MountGateway 按gateway段挂载接口，返回结果和错误的格式与Wrap相同
+func (s *GinService) MountGateway() {
+	conf, err := LoadGatewayConfig()
+	common.AssertError(err)
+	common.AssertError(mountGateway(s.Engine, conf, func(target string) (grpc.ClientConnInterface, error) {
+		return grpcex.ClientConn(target)
+	}))
+}
+
+// mountGateway dial在请求时调用，以便使用grpcex.Client的连接缓存，连接失败的错误经Wrap返回
+func mountGateway(r gin.IRouter, conf *GatewayConfig, dial func(target string) (grpc.ClientConnInterface, error)) error {
+	for name, svc := range conf.Services {
+		desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			return fmt.Errorf("%s.services.%s: %v", gatewayPath, name, err)
+		}
+		sd, ok := desc.(protoreflect.ServiceDescriptor)
+		if !ok {
+			return fmt.Errorf("%s.services.%s: not a service", gatewayPath, name)
+		}
+
+		target := svc.Target
+		if target == "" {
+			target = string(sd.ParentFile().Package())
+		}
+
+		for routeMethod := range svc.Routes {
+			if sd.Methods().ByName(protoreflect.Name(routeMethod)) == nil {
+				return fmt.Errorf("%s.services.%s.routes: method %s not found", gatewayPath, name, routeMethod)
+			}
+		}
+
+		methods := sd.Methods()
+		for i := 0; i < methods.Len(); i++ {
+			md := methods.Get(i)
+			if md.IsStreamingClient() || md.IsStreamingServer() {
+				continue
+			}
+
+			httpMethod, path := http.MethodPost, fmt.Sprintf("%s/%s/%s", conf.Prefix, name, md.Name())
+			if route, ok := svc.Routes[string(md.Name())]; ok {
+				parts := strings.Fields(route)
+				if len(parts) != 2 {
+					return fmt.Errorf("%s.services.%s.routes.%s: expected \"<METHOD> <path>\"", gatewayPath, name, md.Name())
+				}
+				httpMethod, path = strings.ToUpper(parts[0]), conf.Prefix+parts[1]
+			}
+
+			handler, err := gatewayHandler(md, func() (grpc.ClientConnInterface, error) { return dial(target) })
+			if err != nil {
+				return err
+			}
+			r.Handle(httpMethod, path, handler)
+			logrus.WithFields(logrus.Fields{
+				"route":  httpMethod + " " + path,
+				"method": fmt.Sprintf("/%s/%s", name, md.Name()),
+			}).Info("Gateway route mounted")
+		}
+	}
+	return nil
+}
+
+var (
+	gatewayUnmarshal = protojson.UnmarshalOptions{DiscardUnknown: true}
+	gatewayMarshal   = protojson.MarshalOptions{UseProtoNames: true}
+)
+
+func gatewayHandler(md protoreflect.MethodDescriptor, dial func() (grpc.ClientConnInterface, error)) (gin.HandlerFunc, error) {
+	input, err := protoregistry.GlobalTypes.FindMessageByName(md.Input().FullName())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", md.Input().FullName(), err)
+	}
+	output, err := protoregistry.GlobalTypes.FindMessageByName(md.Output().FullName())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", md.Output().FullName(), err)
+	}
+	fullMethod := fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+
+	return Wrap(func(c *gin.Context) (interface{}, error) {
+		fields := input.New()
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+			body, err := c.GetRawData()
+			if err != nil {
+				return nil, Error(errcode.ErrGinBind, err.Error())
+			}
+			if len(body) > 0 {
+				if err := gatewayUnmarshal.Unmarshal(body, fields.Interface()); err != nil {
+					return nil, Error(errcode.ErrGinBind, err.Error())
+				}
+			}
+		}
+
+		// query中未知的键忽略，路径参数必须对应字段
+		for key, vals := range c.Request.URL.Query() {
+			if findField(fields, key) == nil {
+				continue
+			}
+			if err := setField(fields, key, vals); err != nil {
+				return nil, Error(errcode.ErrGinParam, err.Error())
+			}
+		}
+		for _, param := range c.Params {
+			if err := setField(fields, param.Key, []string{param.Value}); err != nil {
+				return nil, Error(errcode.ErrGinParam, err.Error())
+			}
+		}
+
+		conn, err := dial()
+		if err != nil {
+			return nil, err
+		}
+		resp := output.New().Interface()
+		if err := conn.Invoke(c, fullMethod, proto.MessageV1(fields.Interface()), proto.MessageV1(resp)); err != nil {
+			return nil, err
+		}
+		data, err := gatewayMarshal.Marshal(resp)
+		if err != nil {
+			return nil, err
+		}
+		return json.RawMessage(data), nil
+	}), nil
+}
+
+func findField(msg protoreflect.Message, name string) protoreflect.FieldDescriptor {
+	fields := msg.Descriptor().Fields()
+	if fd := fields.ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return fields.ByJSONName(name)
+}
+
+// setField 按字段名或JSON名设置标量字段，repeated字段使用全部值
+func setField(msg protoreflect.Message, name string, vals []string) error {
+	fd := findField(msg, name)
+	if fd == nil {
+		return fmt.Errorf("unknown field %s", name)
+	}
+	if fd.IsMap() || fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+		return fmt.Errorf("field %s is not scalar", name)
+	}
+
+	if fd.IsList() {
+		list := msg.Mutable(fd).List()
+		for _, s := range vals {
+			val, err := parseScalar(fd, s)
+			if err != nil {
+				return err
+			}
+			list.Append(val)
+		}
+		return nil
+	}
+
+	val, err := parseScalar(fd, vals[len(vals)-1])
+	if err != nil {
+		return err
+	}
+	msg.Set(fd, val)
+	return nil
+}
+
+func parseScalar(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	var (
+		val protoreflect.Value
+		err error
+	)
+
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		val = protoreflect.ValueOfString(s)
+	case protoreflect.BytesKind:
+		val = protoreflect.ValueOfBytes([]byte(s))
+	case protoreflect.BoolKind:
+		var b bool
+		b, err = strconv.ParseBool(s)
+		val = protoreflect.ValueOfBool(b)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		var n int64
+		n, err = strconv.ParseInt(s, 10, 32)
+		val = protoreflect.ValueOfInt32(int32(n))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		var n int64
+		n, err = strconv.ParseInt(s, 10, 64)
+		val = protoreflect.ValueOfInt64(n)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		var n uint64
+		n, err = strconv.ParseUint(s, 10, 32)
+		val = protoreflect.ValueOfUint32(uint32(n))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		var n uint64
+		n, err = strconv.ParseUint(s, 10, 64)
+		val = protoreflect.ValueOfUint64(n)
+	case protoreflect.FloatKind:
+		var f float64
+		f, err = strconv.ParseFloat(s, 32)
+		val = protoreflect.ValueOfFloat32(float32(f))
+	case protoreflect.DoubleKind:
+		var f float64
+		f, err = strconv.ParseFloat(s, 64)
+		val = protoreflect.ValueOfFloat64(f)
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			val = protoreflect.ValueOfEnum(ev.Number())
+		} else {
+			var n int64
+			n, err = strconv.ParseInt(s, 10, 32)
+			val = protoreflect.ValueOfEnum(protoreflect.EnumNumber(n))
+		}
+	default:
+		err = fmt.Errorf("unsupported kind %s", fd.Kind())
+	}
+
+	if err != nil {
+		return val, fmt.Errorf("field %s: %v", fd.Name(), err)
+	}
+	return val, nil
+}
@@ -0,0 +1,309 @@
+package ginex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/protobuf/proto"
+	"github.com/rickone/athena/errcode"
+	"github.com/rickone/athena/grpcex"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	protov2 "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// gatewayHealth 记录收到的service和request_id
+type gatewayHealth struct {
+	grpc_health_v1.UnimplementedHealthServer
+
+	mu        sync.Mutex
+	service   string
+	requestId string
+}
+
+func (h *gatewayHealth) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	h.mu.Lock()
+	h.service, h.requestId = req.Service, grpcex.Meta(ctx).RequestId
+	h.mu.Unlock()
+
+	if req.Service == "missing" {
+		return nil, status.Error(codes.Code(errcode.ErrRecordNotFound), "service missing")
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func (h *gatewayHealth) last() (string, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.service, h.requestId
+}
+
+func startGatewayBackend(t *testing.T) (*gatewayHealth, grpc.ClientConnInterface) {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	health := &gatewayHealth{}
+	s := grpc.NewServer(grpc.UnaryInterceptor(grpcex.CtxUnaryServerMW))
+	grpc_health_v1.RegisterHealthServer(s, health)
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithUnaryInterceptor(grpcex.CtxUnaryClientMW()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return health, conn
+}
+
+func newGatewayEngine(t *testing.T, routes map[string]string, dial func(target string) (grpc.ClientConnInterface, error)) *gin.Engine {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestIdMW())
+	conf := &GatewayConfig{
+		Prefix:   "/api",
+		Services: map[string]GatewayService{"grpc.health.v1.Health": {Routes: routes}},
+	}
+	if err := mountGateway(r, conf, dial); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func serveGateway(r *gin.Engine, method, target, body string) *httptest.ResponseRecorder {
+	var req *http.Request
+	if body != "" {
+		req = httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req = httptest.NewRequest(method, target, nil)
+	}
+	req.Header.Set("Request-Id", "req-1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestGatewayRoutes(t *testing.T) {
+	health, conn := startGatewayBackend(t)
+	var dialed string
+	dial := func(target string) (grpc.ClientConnInterface, error) {
+		dialed = target
+		return conn, nil
+	}
+
+	// 配置的路由，路径参数优先于query
+	r := newGatewayEngine(t, map[string]string{"Check": "get /health/:service"}, dial)
+	w := serveGateway(r, http.MethodGet, "/api/health/user?service=order", "")
+	if w.Code != http.StatusOK || w.Body.String() != `{"status":"SERVING"}` {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	if service, requestId := health.last(); service != "user" || requestId != "req-1" {
+		t.Fatalf("unexpected request: service=%q request_id=%q", service, requestId)
+	}
+	if dialed != "grpc.health.v1" {
+		t.Fatalf("unexpected default target: %q", dialed)
+	}
+	if w := serveGateway(r, http.MethodPost, "/api/grpc.health.v1.Health/Check", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("default path mounted for routed method: %d", w.Code)
+	}
+
+	// 未配置路由时使用默认路径，query优先于body
+	r = newGatewayEngine(t, nil, dial)
+	w = serveGateway(r, http.MethodPost, "/api/grpc.health.v1.Health/Check", `{"service":"user"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	if service, _ := health.last(); service != "user" {
+		t.Fatalf("body not bound: %q", service)
+	}
+	serveGateway(r, http.MethodPost, "/api/grpc.health.v1.Health/Check?service=order", `{"service":"user"}`)
+	if service, _ := health.last(); service != "order" {
+		t.Fatalf("query not bound: %q", service)
+	}
+	// 流式方法不暴露
+	if w := serveGateway(r, http.MethodPost, "/api/grpc.health.v1.Health/Watch", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("streaming method mounted: %d", w.Code)
+	}
+}
+
+func TestGatewayErrors(t *testing.T) {
+	_, conn := startGatewayBackend(t)
+	dial := func(target string) (grpc.ClientConnInterface, error) {
+		return conn, nil
+	}
+
+	for _, conf := range []*GatewayConfig{
+		{Services: map[string]GatewayService{"grpc.health.v1.Health": {Routes: map[string]string{"Get": "GET /health"}}}},
+		{Services: map[string]GatewayService{"grpc.health.v1.Health": {Routes: map[string]string{"Check": "/health"}}}},
+		{Services: map[string]GatewayService{"grpc.health.v1.Missing": {}}},
+	} {
+		if err := mountGateway(gin.New(), conf, dial); err == nil {
+			t.Fatalf("expected error for %+v", conf.Services)
+		}
+	}
+
+	r := newGatewayEngine(t, nil, dial)
+	expect := func(w *httptest.ResponseRecorder, httpCode int, code int) {
+		t.Helper()
+		if w.Code != httpCode || !strings.Contains(w.Body.String(), fmt.Sprintf(`"Code":%d`, code)) {
+			t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+		}
+		if w.Header().Get("Request-Id") != "req-1" {
+			t.Fatalf("request id not returned: %v", w.Header())
+		}
+	}
+
+	w := serveGateway(r, http.MethodPost, "/api/grpc.health.v1.Health/Check?service=missing", "")
+	expect(w, http.StatusNotFound, errcode.ErrRecordNotFound)
+	if !strings.Contains(w.Body.String(), `"Msg":"service missing"`) {
+		t.Fatalf("unexpected msg: %s", w.Body)
+	}
+	expect(serveGateway(r, http.MethodPost, "/api/grpc.health.v1.Health/Check", `{"service":`), http.StatusBadRequest, errcode.ErrGinBind)
+
+	// 连接失败的错误按Wrap的格式返回
+	r = newGatewayEngine(t, nil, func(target string) (grpc.ClientConnInterface, error) {
+		return nil, errors.New("dial failed")
+	})
+	expect(serveGateway(r, http.MethodPost, "/api/grpc.health.v1.Health/Check", ""), http.StatusInternalServerError, errcode.ErrRpcFailed)
+}
+
+// registerGatewayTestProto 注册含枚举和int64字段的test.gateway.Order服务
+func registerGatewayTestProto(t *testing.T) protoreflect.ServiceDescriptor {
+	t.Helper()
+
+	const name = "test.gateway.Order"
+	if desc, err := protoregistry.GlobalFiles.FindDescriptorByName(name); err == nil {
+		return desc.(protoreflect.ServiceDescriptor)
+	}
+
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(jsonCamelCase(name)),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     typ.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test/gateway/order.proto"),
+		Package: proto.String("test.gateway"),
+		Syntax:  proto.String("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("State"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("STATE_UNKNOWN"), Number: proto.Int32(0)},
+				{Name: proto.String("STATE_PAID"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("OrderMsg"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("order_id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+				field("state", 2, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".test.gateway.State"),
+			},
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Order"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("Update"),
+				InputType:  proto.String(".test.gateway.OrderMsg"),
+				OutputType: proto.String(".test.gateway.OrderMsg"),
+			}},
+		}},
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		t.Fatal(err)
+	}
+	if err := protoregistry.GlobalTypes.RegisterMessage(dynamicpb.NewMessageType(fd.Messages().Get(0))); err != nil {
+		t.Fatal(err)
+	}
+	return fd.Services().Get(0)
+}
+
+func jsonCamelCase(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = strings.Title(parts[i])
+	}
+	return strings.Join(parts, "")
+}
+
+// echoConn 将请求原样作为响应返回
+type echoConn struct {
+	grpc.ClientConnInterface
+	method string
+}
+
+func (c *echoConn) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	c.method = method
+	data, err := protov2.Marshal(proto.MessageV2(args))
+	if err != nil {
+		return err
+	}
+	return protov2.Unmarshal(data, proto.MessageV2(reply))
+}
+
+func TestGatewayProtoJSON(t *testing.T) {
+	registerGatewayTestProto(t)
+	conn := &echoConn{}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	conf := &GatewayConfig{Services: map[string]GatewayService{"test.gateway.Order": {
+		Routes: map[string]string{"Update": "PUT /orders/:order_id"},
+	}}}
+	if err := mountGateway(r, conf, func(target string) (grpc.ClientConnInterface, error) {
+		return conn, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 枚举名称、字符串形式的int64和JSON名，未知字段忽略
+	w := serveGateway(r, http.MethodPut, "/orders/9007199254740993", `{"orderId":"1","state":"STATE_PAID","unknown":1}`)
+	if w.Code != http.StatusOK || w.Body.String() != `{"order_id":"9007199254740993","state":"STATE_PAID"}` {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	if conn.method != "/test.gateway.Order/Update" {
+		t.Fatalf("unexpected method: %s", conn.method)
+	}
+
+	w = serveGateway(r, http.MethodPut, "/orders/1?state=1", `{"order_id":"1"}`)
+	if w.Code != http.StatusOK || w.Body.String() != `{"order_id":"1","state":"STATE_PAID"}` {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body)
+	}
+	if w := serveGateway(r, http.MethodPut, "/orders/1", `{"state":"STATE_NONE"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected bind error, got %d %s", w.Code, w.Body)
+	}
+}
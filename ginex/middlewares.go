@@ -10,10 +10,12 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/rickone/athena/grpcex"
 	"github.com/rickone/athena/limiter"
 	"github.com/rickone/athena/logger"
 	"github.com/rickone/athena/metrics"
 	"github.com/rickone/athena/redis"
+	"github.com/rickone/athena/trace"
 	"github.com/sirupsen/logrus"
 )
 
@@ -60,16 +62,74 @@ func RequestIdMW() gin.HandlerFunc {
 	}
 }
 
+// RerouteMW 从X-Reroute请求头、reroute cookie或query中读取调试流量的路由标记，
+// 随调用gRPC时传递，见grpcex.RerouteUnaryClientMW；query中的标记同时写入cookie，
+// 便于浏览器中后续请求沿用，reroute=为空时清除
+func RerouteMW() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tag := c.GetHeader(grpcex.RerouteHeader)
+		if tag == "" {
+			if t, ok := c.GetQuery(grpcex.MetaReroute); ok {
+				tag = t
+				maxAge := int(redis.DefaultRerouteTTL.Seconds())
+				if tag == "" {
+					maxAge = -1
+				}
+				// 页面脚本不可读写，https请求时只随https发送
+				c.SetCookie(grpcex.MetaReroute, tag, maxAge, "/", "", c.Request.TLS != nil, true)
+			} else {
+				tag, _ = c.Cookie(grpcex.MetaReroute)
+			}
+		}
+
+		if tag != "" {
+			c.Set("Reroute", tag)
+			c.Writer.Header().Set(grpcex.RerouteHeader, tag)
+		}
+	}
+}
+
+// TraceMW 以请求头中的traceparent为父节点开始服务端span，应位于AccessLogMW之前，
+// span保存在c.Keys和c.Request的ctx中，以c为ctx调用grpcex、redis、mysql时自动传递
+func TraceMW() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.URL.Path == "/health" {
+			c.Next()
+			return
+		}
+
+		ctx := trace.ExtractHeader(c.Request.Context(), c.Request.Header)
+		ctx, span := trace.Start(ctx, fmt.Sprintf("%s %s", c.Request.Method, c.FullPath()), trace.KindServer)
+		c.Request = c.Request.WithContext(ctx)
+		c.Set(trace.GinKey, span)
+
+		c.Next()
+
+		code := c.Writer.Status()
+		span.SetAttr("http.method", c.Request.Method)
+		span.SetAttr("http.route", c.FullPath())
+		span.SetAttr("http.status_code", code)
+		if code >= http.StatusInternalServerError {
+			span.SetStatus(trace.StatusError, http.StatusText(code))
+		}
+		span.End()
+	}
+}
+
 func AccessLogMW() gin.HandlerFunc {
 	service := os.Getenv("Service")
 
 	return func(c *gin.Context) {
-		c.Set("Logger", logger.NewEntry(c, map[string]interface{}{
+		entryFields := map[string]interface{}{
 			"request_id": c.GetString("Request-Id"),
 			"client_ip":  c.ClientIP(),
 			"service":    service,
 			"method":     getFullMethod(c),
-		}))
+		}
+		if tag := c.GetString("Reroute"); tag != "" {
+			entryFields["reroute"] = tag
+		}
+		c.Set("Logger", logger.NewEntry(c, entryFields))
 
 		start := time.Now()
 		c.Next()
@@ -0,0 +1,68 @@
+package ginex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rickone/athena/grpcex"
+)
+
+func TestRerouteMW(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RerouteMW())
+	r.GET("/ping", func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("Reroute"))
+	})
+
+	do := func(target string, header, cookie string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if header != "" {
+			req.Header.Set(grpcex.RerouteHeader, header)
+		}
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: grpcex.MetaReroute, Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// 请求头优先于query和cookie
+	w := do("/ping?reroute=bob", "alice", "carol")
+	if w.Body.String() != "alice" || w.Header().Get(grpcex.RerouteHeader) != "alice" || w.Header().Get("Set-Cookie") != "" {
+		t.Fatalf("unexpected header reroute: %q %v", w.Body, w.Header())
+	}
+
+	// query设置cookie供后续请求使用
+	w = do("/ping?reroute=bob", "", "carol")
+	if w.Body.String() != "bob" {
+		t.Fatalf("unexpected query reroute: %q", w.Body)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != "bob" || cookies[0].MaxAge <= 0 || !cookies[0].HttpOnly || cookies[0].Secure {
+		t.Fatalf("unexpected cookie: %v", cookies)
+	}
+	w = do("https://example.com/ping?reroute=bob", "", "")
+	if cookies = w.Result().Cookies(); len(cookies) != 1 || !cookies[0].Secure || !cookies[0].HttpOnly {
+		t.Fatalf("cookie not secure over https: %v", cookies)
+	}
+
+	if w = do("/ping", "", "carol"); w.Body.String() != "carol" {
+		t.Fatalf("unexpected cookie reroute: %q", w.Body)
+	}
+
+	// 空的query清除cookie
+	w = do("/ping?reroute=", "", "carol")
+	cookies = w.Result().Cookies()
+	if w.Body.String() != "" || w.Header().Get(grpcex.RerouteHeader) != "" || len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Fatalf("cookie not cleared: %q %v", w.Body, cookies)
+	}
+
+	if w = do("/ping", "", ""); w.Body.String() != "" || w.Header().Get(grpcex.RerouteHeader) != "" {
+		t.Fatalf("unexpected reroute: %q", w.Body)
+	}
+}
@@ -1,26 +1,38 @@
 package ginex
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/hashicorp/consul/api"
 	"github.com/rickone/athena/common"
 	"github.com/rickone/athena/config"
-	"github.com/rickone/athena/consul"
+	"github.com/rickone/athena/discovery"
 	"github.com/rickone/athena/errcode"
+	"github.com/rickone/athena/health"
+	"github.com/rickone/athena/lifecycle"
 	"github.com/rickone/athena/metrics"
+	"github.com/rickone/athena/redis"
+	"github.com/rickone/athena/trace"
+	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc/status"
 )
 
+const closeTimeout = 10 * time.Second
+
 type GinService struct {
 	*gin.Engine
 	ip4      string
 	port     int
-	register *consul.Register
+	registry discovery.Registry
+	instance *discovery.Instance
+	server   *http.Server
 }
 
 func NewGinService(name string) *GinService {
@@ -39,46 +51,158 @@ func NewGinService(name string) *GinService {
 		service.ip4 = common.GetLocalAddr()
 	}
 	service.port = port
+	service.server = &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: service.Engine,
+	}
 
 	os.Setenv("Service", name)
+	common.AssertError(trace.Init(name))
 
 	go metrics.ReportInfluxDBV2(name)
 
 	return service
 }
 
+// Register 挂载/health并注册到服务发现，/health的状态由health.Default决定
 func (s *GinService) Register(name string) {
-	s.GET("health", func(c *gin.Context) {
-		c.Status(208)
-	})
+	health.AddService(name)
+	health.Start()
+	s.GET("health", healthHandler(health.Default, name))
 
-	cs := &consul.Register{
+	instance := &discovery.Instance{
 		Service: name,
 		Address: s.ip4,
 		Port:    s.port,
-		Check: &api.AgentServiceCheck{
-			Interval:                       "10s",
-			DeregisterCriticalServiceAfter: "1m",
-			HTTP:                           fmt.Sprintf("http://%s:%d/health", s.ip4, s.port),
+		Check: &discovery.Check{
+			Interval:        10 * time.Second,
+			DeregisterAfter: time.Minute,
+			HTTP:            fmt.Sprintf("http://%s:%d/health", s.ip4, s.port),
+			Probe:           s.probe,
 		},
 	}
+	common.AssertError(instance.LoadConfig())
+
+	registry, err := discovery.Default()
+	common.AssertError(err)
+	common.AssertError(registry.Register(instance))
+	s.registry = registry
+	s.instance = instance
+}
 
-	consulAddress := config.GetString("service", "consul")
-	common.AssertError(cs.Register(consulAddress))
-	s.register = cs
+// MountConfigAdmin 挂载脱敏后的有效配置查看接口，见config.AdminHandler
+func (s *GinService) MountConfigAdmin(relativePath string) {
+	s.GET(relativePath, gin.WrapH(config.AdminHandler()))
 }
 
+// MountRerouteAdmin 挂载调试流量路由规则的管理接口，见redis.RerouteAdminHandler
+func (s *GinService) MountRerouteAdmin(relativePath string) {
+	s.Any(relativePath, gin.WrapH(redis.RerouteAdminHandler()))
+}
+
+// Serve 阻塞直到Shutdown，配合lifecycle.Manager时使用Attach
 func (s *GinService) Serve() {
-	s.Run(fmt.Sprintf(":%d", s.port))
-	//r.RunTLS(fmt.Sprintf(":%d", port), "./server.crt", "./server.key")
+	if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logrus.WithField("err", err.Error()).Error("Http serve failed")
+	}
+}
+
+// Attach 将启动、注销和drain加入m，需在Register之后调用
+func (s *GinService) Attach(m *lifecycle.Manager) {
+	m.Append(lifecycle.Hook{
+		Name:  "http deregister",
+		Phase: lifecycle.PhaseDeregister,
+		OnStart: func(ctx context.Context) error {
+			listener, err := net.Listen("tcp", s.server.Addr)
+			if err != nil {
+				return err
+			}
+			go func() {
+				if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+					m.Fail(fmt.Errorf("http serve: %v", err))
+				}
+			}()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return s.deregister()
+		},
+	})
+	m.Append(lifecycle.Hook{
+		Name:   "http drain",
+		Phase:  lifecycle.PhaseDrain,
+		OnStop: s.Shutdown,
+	})
+}
+
+// Shutdown 停止接收新连接并等待处理中的请求结束，ctx到期后返回错误
+func (s *GinService) Shutdown(ctx context.Context) error {
+	if err := s.server.Shutdown(ctx); err != nil {
+		s.server.Close()
+		return err
+	}
+	return nil
+}
+
+// healthHandler 可用时返回208，否则返回503，附带各检查的结果
+func healthHandler(registry *health.Registry, service string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		st, _ := registry.Status(service)
+		code := http.StatusAlreadyReported
+		if st != health.StatusServing {
+			code = http.StatusServiceUnavailable
+		}
+		c.JSON(code, gin.H{
+			"status": st.String(),
+			"checks": registry.Results(),
+		})
+	}
+}
+
+// probe 访问自身的/health，用于TTL心跳
+func (s *GinService) probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s:%d/health", s.ip4, s.port), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("health status %d", resp.StatusCode)
+	}
+	return nil
 }
 
+// deregister 先将/health置为不可用，注销可能需要等待drain_period，期间仍正常提供服务
+func (s *GinService) deregister() error {
+	health.Shutdown()
+
+	var err error
+	if s.instance != nil {
+		err = s.registry.Deregister(s.instance)
+		s.instance = nil
+	}
+	return err
+}
+
+// Close 注销并在closeTimeout内drain，使用lifecycle.Manager时不需要调用
 func (s *GinService) Close() {
-	if s.register != nil {
-		consulAddress := config.GetString("service", "consul")
-		s.register.Deregister(consulAddress)
-		s.register = nil
+	if err := s.deregister(); err != nil {
+		logrus.WithField("err", err.Error()).Error("Deregister failed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		logrus.WithField("err", err.Error()).Error("Shutdown http server failed")
 	}
+
+	trace.Shutdown(context.Background())
 }
 
 func GetBearerAccessToken(c *gin.Context) string {
@@ -27,5 +27,6 @@ require (
 	go.mongodb.org/mongo-driver v1.4.6
 	golang.org/x/time v0.0.0-20191024005414-555d28b269f0
 	google.golang.org/grpc v1.31.1
+	google.golang.org/protobuf v1.24.0
 	gopkg.in/yaml.v2 v2.4.0
 )
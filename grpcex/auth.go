@@ -0,0 +1,326 @@
+package grpcex
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rickone/athena/common"
+	"github.com/rickone/athena/config"
+	"github.com/rickone/athena/errcode"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const authPolicyPath = "auth"
+
+// identityKey 签名身份在gRPC metadata中的键，每一跳由调用方重新签名，不随RequestMeta传递
+const identityKey = "x-identity"
+
+// Claims 签名的调用方身份，服务端验证后覆盖metadata中的caller和user_id
+type Claims struct {
+	Caller string `json:"caller"`
+	UserId string `json:"user_id,omitempty"`
+	Scope  string `json:"scope,omitempty"`
+	Expire int64  `json:"exp"`
+}
+
+// HasScope Scope以空格分隔
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range strings.Fields(c.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthPolicy 对应配置中的auth段，keys为空时不签名也不验证；
+// 配置keys后不再信任metadata中的调用方、用户和scope，未签名且允许通过的请求中这些值被清除；
+// 网关和各服务使用相同的keys，发出请求时以当前服务名为caller签名，用户和scope取自RequestMeta；
+// methods的键为完整方法名或服务名，完整方法名优先，均未匹配时使用default，健康检查不受限制:
+//   auth:
+//     keys: [k2, k1]                   # HMAC密钥，第一个用于签名，全部用于验证，便于轮换
+//     ttl: 1m                          # 签名有效期
+//     required: true                   # 拒绝未签名的请求，灰度上线时设为false
+//     default:
+//       callers: []                    # 允许的调用方，空表示不限制
+//       scopes: []                     # 需具有其中之一，空表示不限制
+//       user: false                    # 是否要求签名中有用户
+//     methods:
+//       /user.User/DeleteUser:
+//         callers: [admin]
+//       user.Account:
+//         scopes: [account, admin]
+//         user: true
+type AuthPolicy struct {
+	Keys     []string
+	Ttl      time.Duration `default:"1m"`
+	Required bool          `default:"true"`
+	Default  AuthRule
+	Methods  map[string]AuthRule
+}
+
+type AuthRule struct {
+	Callers []string
+	Scopes  []string
+	User    bool
+}
+
+// LoadAuthPolicy 读取auth段
+func LoadAuthPolicy() (*AuthPolicy, error) {
+	var policy AuthPolicy
+	if err := config.Unmarshal(&policy, authPolicyPath); err != nil {
+		return nil, err
+	}
+
+	for _, key := range policy.Keys {
+		if key == "" {
+			return nil, fmt.Errorf("%s.keys must not contain empty key", authPolicyPath)
+		}
+	}
+	if len(policy.Keys) > 0 && policy.Ttl <= 0 {
+		return nil, fmt.Errorf("%s.ttl must be > 0", authPolicyPath)
+	}
+	return &policy, nil
+}
+
+// Method 返回方法对应的规则
+func (p *AuthPolicy) Method(fullMethod string) *AuthRule {
+	if rule, ok := p.Methods[fullMethod]; ok {
+		return &rule
+	}
+	if subs := regFullMethod.FindStringSubmatch(fullMethod); len(subs) == 3 {
+		if rule, ok := p.Methods[subs[1]]; ok {
+			return &rule
+		}
+	}
+	return &p.Default
+}
+
+// Sign 使用第一个key签名，Expire为0时按ttl设置
+func (p *AuthPolicy) Sign(claims *Claims) (string, error) {
+	if len(p.Keys) == 0 {
+		return "", fmt.Errorf("%s.keys not configured", authPolicyPath)
+	}
+
+	dup := *claims
+	if dup.Expire == 0 {
+		dup.Expire = time.Now().Add(p.Ttl).Unix()
+	}
+	data, err := json.Marshal(&dup)
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	mac := common.HmacSha256([]byte(payload), []byte(p.Keys[0]))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac), nil
+}
+
+// Verify 验证签名和有效期，任一key验证通过即可
+func (p *AuthPolicy) Verify(token string) (*Claims, error) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return nil, errors.New("malformed token")
+	}
+	payload := token[:i]
+	mac, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+
+	matched := false
+	for _, key := range p.Keys {
+		if hmac.Equal(mac, common.HmacSha256([]byte(payload), []byte(key))) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return nil, errors.New("sign dismatch")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+	var claims Claims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, errors.New("malformed token")
+	}
+	if time.Now().Unix() > claims.Expire {
+		return nil, errors.New("token expired")
+	}
+	return &claims, nil
+}
+
+// authorize 验证签名并检查方法规则，未启用或请求未签名且规则不限制时返回nil
+func (p *AuthPolicy) authorize(fullMethod string, token string) (*Claims, error) {
+	if len(p.Keys) == 0 || strings.HasPrefix(fullMethod, "/grpc.health.v1.Health/") {
+		return nil, nil
+	}
+
+	rule := p.Method(fullMethod)
+	if token == "" {
+		if p.Required || len(rule.Callers) > 0 || len(rule.Scopes) > 0 || rule.User {
+			return nil, status.Error(errcode.ErrUnauthenticated, "identity required")
+		}
+		return nil, nil
+	}
+
+	claims, err := p.Verify(token)
+	if err != nil {
+		return nil, status.Errorf(errcode.ErrUnauthenticated, "identity invalid: %v", err)
+	}
+	if rule.User && claims.UserId == "" {
+		return nil, status.Error(errcode.ErrUnauthenticated, "user required")
+	}
+	if len(rule.Callers) > 0 && !containsString(rule.Callers, claims.Caller) {
+		return nil, status.Errorf(errcode.ErrForbidden, "caller %s not allowed", claims.Caller)
+	}
+	if len(rule.Scopes) > 0 {
+		allowed := false
+		for _, scope := range rule.Scopes {
+			if claims.HasScope(scope) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, status.Errorf(errcode.ErrForbidden, "scope %v required", rule.Scopes)
+		}
+	}
+	return claims, nil
+}
+
+func containsString(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+// watchAuthPolicy 加载auth并在配置变化后更新，新配置有误时保留原策略
+func watchAuthPolicy() (*atomic.Value, error) {
+	policy, err := LoadAuthPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	var current atomic.Value
+	current.Store(policy)
+	config.OnChange(authPolicyPath, func(old, new *config.Value) {
+		policy, err := LoadAuthPolicy()
+		if err != nil {
+			logrus.WithField("err", err.Error()).Error("Reload auth policy failed")
+			return
+		}
+		current.Store(policy)
+	})
+	return &current, nil
+}
+
+var (
+	defaultAuthOnce     sync.Once
+	defaultAuthPolicies *atomic.Value
+	defaultAuthErr      error
+)
+
+// defaultAuth 进程内共用，服务端和客户端签名使用相同的keys
+func defaultAuth() (*atomic.Value, error) {
+	defaultAuthOnce.Do(func() {
+		defaultAuthPolicies, defaultAuthErr = watchAuthPolicy()
+		if defaultAuthErr != nil {
+			logrus.WithField("err", defaultAuthErr.Error()).Error("Load auth policy failed")
+		}
+	})
+	return defaultAuthPolicies, defaultAuthErr
+}
+
+// appendIdentity auth.keys配置时将调用方身份签名后写入发出的metadata
+func appendIdentity(ctx context.Context, meta *RequestMeta) context.Context {
+	policies, err := defaultAuth()
+	if err != nil {
+		return ctx
+	}
+	policy := policies.Load().(*AuthPolicy)
+	if len(policy.Keys) == 0 {
+		return ctx
+	}
+
+	token, err := policy.Sign(&Claims{Caller: meta.Caller, UserId: meta.UserId, Scope: meta.Scope})
+	if err != nil {
+		meta.Logger().WithField("err", err.Error()).Error("Sign identity failed")
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, identityKey, token)
+}
+
+// authCtx 验证通过后以签名中的调用方、用户和scope覆盖metadata中的值，
+// 配置keys而请求未签名时清除这些值，避免信任未验证的身份
+func authCtx(ctx context.Context, fullMethod string, policies *atomic.Value) (context.Context, error) {
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(identityKey); len(vals) > 0 {
+			token = vals[0]
+		}
+	}
+
+	policy := policies.Load().(*AuthPolicy)
+	claims, err := policy.authorize(fullMethod, token)
+	if err != nil {
+		Meta(ctx).Logger().WithFields(logrus.Fields{
+			"method": fullMethod,
+			"err":    err.Error(),
+		}).Warn("Auth rejected")
+		return ctx, err
+	}
+	if len(policy.Keys) == 0 {
+		return ctx, nil
+	}
+
+	meta := *Meta(ctx)
+	meta.Caller, meta.UserId, meta.Scope, meta.Claims = "", "", "", nil
+	if claims != nil {
+		meta.Caller = claims.Caller
+		meta.UserId = claims.UserId
+		meta.Scope = claims.Scope
+		meta.Claims = claims
+	}
+	return NewContext(ctx, &meta), nil
+}
+
+// authUnaryMW 按auth段验证调用方身份和方法规则
+func authUnaryMW(policies *atomic.Value) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authCtx(ctx, info.FullMethod, policies)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func authStreamMW(policies *atomic.Value) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authCtx(ss.Context(), info.FullMethod, policies)
+		if err != nil {
+			return err
+		}
+		ws := wrapServerStream(ss)
+		ws.ctx = ctx
+		return handler(srv, ws)
+	}
+}
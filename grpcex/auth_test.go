@@ -0,0 +1,146 @@
+package grpcex
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rickone/athena/config"
+	"github.com/rickone/athena/errcode"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthPolicy(t *testing.T) {
+	old := &AuthPolicy{Keys: []string{"k1"}, Ttl: time.Minute}
+	policy := &AuthPolicy{
+		Keys: []string{"k2", "k1"},
+		Ttl:  time.Minute,
+		Methods: map[string]AuthRule{
+			"/user.User/DeleteUser": {Callers: []string{"admin"}},
+			"user.Account":          {Scopes: []string{"account", "admin"}, User: true},
+		},
+	}
+
+	// 轮换期间旧key签名仍可验证
+	token, err := old.Sign(&Claims{Caller: "gateway", UserId: "42", Scope: "read account"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := policy.Verify(token)
+	if err != nil || claims.Caller != "gateway" || claims.UserId != "42" {
+		t.Fatalf("unexpected claims: %+v, %v", claims, err)
+	}
+
+	if _, err := (&AuthPolicy{Keys: []string{"k3"}}).Verify(token); err == nil {
+		t.Fatal("expected sign dismatch")
+	}
+	payload := strings.Split(token, ".")
+	forged, _ := (&AuthPolicy{Keys: []string{"k3"}, Ttl: time.Minute}).Sign(&Claims{Caller: "admin"})
+	if _, err := policy.Verify(strings.Split(forged, ".")[0] + "." + payload[1]); err == nil {
+		t.Fatal("expected forged token rejected")
+	}
+	expired, _ := policy.Sign(&Claims{Caller: "gateway", Expire: time.Now().Add(-time.Second).Unix()})
+	if _, err := policy.Verify(expired); err == nil {
+		t.Fatal("expected token expired")
+	}
+
+	for _, tc := range []struct {
+		method string
+		claims *Claims
+		code   int
+	}{
+		{"/user.User/GetUser", nil, 0},
+		{"/user.User/DeleteUser", nil, errcode.ErrUnauthenticated},
+		{"/user.User/DeleteUser", &Claims{Caller: "gateway"}, errcode.ErrForbidden},
+		{"/user.User/DeleteUser", &Claims{Caller: "admin"}, 0},
+		{"/user.Account/Get", &Claims{Caller: "gateway", Scope: "account"}, errcode.ErrUnauthenticated},
+		{"/user.Account/Get", &Claims{Caller: "gateway", UserId: "42", Scope: "read"}, errcode.ErrForbidden},
+		{"/user.Account/Get", &Claims{Caller: "gateway", UserId: "42", Scope: "read admin"}, 0},
+	} {
+		var token string
+		if tc.claims != nil {
+			token, _ = policy.Sign(tc.claims)
+		}
+		_, err := policy.authorize(tc.method, token)
+		if code := int(status.Code(err)); code != tc.code {
+			t.Fatalf("%s %+v: expected %d, got %v", tc.method, tc.claims, tc.code, err)
+		}
+	}
+
+	// 要求签名时健康检查不受限制
+	policy.Required = true
+	if _, err := policy.authorize("/user.User/GetUser", ""); status.Code(err) != errcode.ErrUnauthenticated {
+		t.Fatalf("expected unauthenticated, got %v", err)
+	}
+	if _, err := policy.authorize(checkMethod, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthUnaryMW(t *testing.T) {
+	policies, err := defaultAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.UpdateValue(authPolicyPath, map[interface{}]interface{}{
+		"keys":     []interface{}{"k1"},
+		"required": false,
+		"methods": map[interface{}]interface{}{
+			"user.Account": map[interface{}]interface{}{"scopes": []interface{}{"account"}, "user": true},
+		},
+	})
+	defer config.UpdateValue(authPolicyPath, nil)
+
+	method := "/user.Account/Get"
+	call := func(meta *RequestMeta, forge func(md metadata.MD)) (*RequestMeta, error) {
+		md, _ := metadata.FromOutgoingContext(outgoingCtx(NewContext(context.Background(), meta), "gateway"))
+		forge(md)
+
+		ctx := incomingCtx(metadata.NewIncomingContext(context.Background(), md), method)
+		var got *RequestMeta
+		_, err := authUnaryMW(policies)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			got = Meta(ctx)
+			return nil, nil
+		})
+		return got, err
+	}
+
+	// metadata中的user_id被篡改时以签名为准
+	got, err := call(&RequestMeta{UserId: "42", Scope: "account"}, func(md metadata.MD) {
+		md.Set(MetaUserId, "1")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserId != "42" || got.Caller != "gateway" || got.Claims == nil {
+		t.Fatalf("unexpected meta: %+v", got)
+	}
+
+	_, err = call(&RequestMeta{UserId: "42"}, func(md metadata.MD) {})
+	if status.Code(err) != errcode.ErrForbidden {
+		t.Fatalf("expected forbidden, got %v", err)
+	}
+
+	_, err = call(&RequestMeta{UserId: "42", Scope: "account"}, func(md metadata.MD) {
+		delete(md, identityKey)
+	})
+	if status.Code(err) != errcode.ErrUnauthenticated {
+		t.Fatalf("expected unauthenticated, got %v", err)
+	}
+
+	// 未签名的请求中伪造的身份被清除
+	method = "/user.User/GetUser"
+	got, err = call(&RequestMeta{UserId: "42", Scope: "admin"}, func(md metadata.MD) {
+		delete(md, identityKey)
+		md.Set(MetaUserId, "1")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserId != "" || got.Caller != "" || got.Scope != "" || got.Claims != nil {
+		t.Fatalf("unverified identity trusted: %+v", got)
+	}
+}
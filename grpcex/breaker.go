@@ -0,0 +1,284 @@
+package grpcex
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rickone/athena/errcode"
+	"github.com/rickone/athena/metrics"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const breakerBuckets = 10
+
+// BreakerState 熔断器状态，也是breaker_state指标的值
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = 0
+	BreakerOpen     BreakerState = 1
+	BreakerHalfOpen BreakerState = 2
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// BreakerPolicy 对应CallPolicy中的breaker，每个服务的每个方法独立熔断:
+//   breaker:
+//     window: 10s               # 统计窗口
+//     min_requests: 20          # 窗口内请求数达到后才判断
+//     error_rate: 0.5           # 失败率达到后熔断，失败指errcode.From判定为failed的错误
+//     slow_call: 1s             # 超过该耗时计为慢调用，0表示不统计
+//     slow_rate: 0.5            # 慢调用比例达到后熔断
+//     open_duration: 5s         # 熔断持续时间，之后进入半开
+//     half_open_requests: 3     # 半开时放行的探测请求数，全部成功后恢复，任一失败重新熔断
+// 熔断中的调用直接返回errcode.ErrCircuitOpen
+type BreakerPolicy struct {
+	Window           time.Duration `default:"10s"`
+	MinRequests      int           `default:"20"`
+	ErrorRate        float64       `default:"0.5"`
+	SlowCall         time.Duration
+	SlowRate         float64       `default:"0.5"`
+	OpenDuration     time.Duration `default:"5s"`
+	HalfOpenRequests int           `default:"3"`
+}
+
+func (bp *BreakerPolicy) validate() error {
+	switch {
+	case bp.Window <= 0:
+		return fmt.Errorf("window must be > 0")
+	case bp.ErrorRate <= 0 || bp.ErrorRate > 1:
+		return fmt.Errorf("error_rate must be in (0, 1]")
+	case bp.SlowRate <= 0 || bp.SlowRate > 1:
+		return fmt.Errorf("slow_rate must be in (0, 1]")
+	case bp.OpenDuration <= 0:
+		return fmt.Errorf("open_duration must be > 0")
+	case bp.HalfOpenRequests < 1:
+		return fmt.Errorf("half_open_requests must be >= 1")
+	}
+	return nil
+}
+
+type breakerBucket struct {
+	total  int
+	failed int
+	slow   int
+}
+
+// circuitBreaker 按时间分桶的滑动窗口统计，gen在每次状态变化时递增，
+// 用于忽略状态变化前发出的调用的结果
+type circuitBreaker struct {
+	target string
+	method string
+	now    func() time.Time
+
+	mu          sync.Mutex
+	state       BreakerState
+	gen         uint64
+	openedAt    time.Time
+	buckets     [breakerBuckets]breakerBucket
+	cur         int
+	bucketStart time.Time
+	probes      int
+	probeOk     int
+}
+
+func newCircuitBreaker(target string, method string) *circuitBreaker {
+	cb := &circuitBreaker{
+		target: target,
+		method: method,
+		now:    time.Now,
+	}
+	cb.bucketStart = cb.now()
+	cb.updateGauge()
+	return cb
+}
+
+func (cb *circuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// allow 返回放行时的gen，熔断中返回ErrCircuitOpen
+func (cb *circuitBreaker) allow(bp *BreakerPolicy) (uint64, error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == BreakerOpen {
+		if cb.now().Sub(cb.openedAt) < bp.OpenDuration {
+			return 0, cb.reject()
+		}
+		cb.transit(BreakerHalfOpen)
+	}
+
+	if cb.state == BreakerHalfOpen {
+		if cb.probes >= bp.HalfOpenRequests {
+			return 0, cb.reject()
+		}
+		cb.probes++
+	}
+	return cb.gen, nil
+}
+
+func (cb *circuitBreaker) reject() error {
+	metrics.NewCounter("breaker_reject", "target", cb.target, "method", cb.method).Inc(1)
+	return status.Errorf(errcode.ErrCircuitOpen, "circuit breaker open: %s %s", cb.target, cb.method)
+}
+
+// record 记录allow放行的调用结果
+func (cb *circuitBreaker) record(bp *BreakerPolicy, gen uint64, err error, latency time.Duration) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if gen != cb.gen {
+		return
+	}
+
+	// 调用方取消不计入
+	if status.Code(err) == codes.Canceled {
+		if cb.state == BreakerHalfOpen {
+			cb.probes--
+		}
+		return
+	}
+
+	_, failed := errcode.From(err)
+	slow := bp.SlowCall > 0 && latency >= bp.SlowCall
+
+	switch cb.state {
+	case BreakerHalfOpen:
+		if failed || slow {
+			cb.transit(BreakerOpen)
+			return
+		}
+		cb.probeOk++
+		if cb.probeOk >= bp.HalfOpenRequests {
+			cb.transit(BreakerClosed)
+		}
+	case BreakerClosed:
+		cb.advance(bp)
+		b := &cb.buckets[cb.cur]
+		b.total++
+		if failed {
+			b.failed++
+		}
+		if slow {
+			b.slow++
+		}
+
+		var sum breakerBucket
+		for _, b := range cb.buckets {
+			sum.total += b.total
+			sum.failed += b.failed
+			sum.slow += b.slow
+		}
+		if sum.total < bp.MinRequests {
+			return
+		}
+		if float64(sum.failed) >= bp.ErrorRate*float64(sum.total) ||
+			(bp.SlowCall > 0 && float64(sum.slow) >= bp.SlowRate*float64(sum.total)) {
+			logrus.WithFields(logrus.Fields{
+				"target": cb.target,
+				"rpc":    cb.method,
+				"total":  sum.total,
+				"failed": sum.failed,
+				"slow":   sum.slow,
+			}).Warn("Circuit breaker open")
+			cb.transit(BreakerOpen)
+		}
+	}
+}
+
+// advance 滑动窗口到当前时间，过期的桶清零
+func (cb *circuitBreaker) advance(bp *BreakerPolicy) {
+	width := bp.Window / breakerBuckets
+	if width <= 0 {
+		width = 1
+	}
+
+	steps := int(cb.now().Sub(cb.bucketStart) / width)
+	if steps <= 0 {
+		return
+	}
+	if steps >= breakerBuckets {
+		cb.buckets = [breakerBuckets]breakerBucket{}
+	} else {
+		for i := 0; i < steps; i++ {
+			cb.cur = (cb.cur + 1) % breakerBuckets
+			cb.buckets[cb.cur] = breakerBucket{}
+		}
+	}
+	cb.bucketStart = cb.bucketStart.Add(time.Duration(steps) * width)
+}
+
+func (cb *circuitBreaker) transit(state BreakerState) {
+	cb.state = state
+	cb.gen++
+	cb.probes, cb.probeOk = 0, 0
+
+	switch state {
+	case BreakerOpen:
+		cb.openedAt = cb.now()
+	case BreakerClosed:
+		cb.buckets = [breakerBuckets]breakerBucket{}
+		cb.bucketStart = cb.now()
+	}
+
+	cb.updateGauge()
+	metrics.NewCounter("breaker_transition", "target", cb.target, "method", cb.method, "state", state.String()).Inc(1)
+}
+
+func (cb *circuitBreaker) updateGauge() {
+	metrics.NewGauge("breaker_state", "target", cb.target, "method", cb.method).Update(int64(cb.state))
+}
+
+// BreakerUnaryClientMW 按grpc_client.<target>中的breaker配置熔断，未配置的方法不受影响
+func BreakerUnaryClientMW(target string) grpc.UnaryClientInterceptor {
+	policies := watchClientPolicy(target)
+	breakers := map[string]*circuitBreaker{}
+	breakersMu := sync.Mutex{}
+
+	getBreaker := func(method string) *circuitBreaker {
+		breakersMu.Lock()
+		defer breakersMu.Unlock()
+
+		cb, ok := breakers[method]
+		if !ok {
+			cb = newCircuitBreaker(target, method)
+			breakers[method] = cb
+		}
+		return cb
+	}
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		bp := policies.Load().(*ClientPolicy).Method(method).Breaker
+		if bp == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		cb := getBreaker(method)
+		gen, err := cb.allow(bp)
+		if err != nil {
+			return err
+		}
+
+		start := time.Now()
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		cb.record(bp, gen, err, time.Since(start))
+		return err
+	}
+}
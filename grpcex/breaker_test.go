@@ -0,0 +1,177 @@
+package grpcex
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rickone/athena/errcode"
+	"github.com/rickone/athena/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	cb := newCircuitBreaker("cb", checkMethod)
+	cb.now = func() time.Time { return now }
+	bp := &BreakerPolicy{
+		Window:           10 * time.Second,
+		MinRequests:      4,
+		ErrorRate:        0.5,
+		SlowCall:         100 * time.Millisecond,
+		SlowRate:         0.5,
+		OpenDuration:     5 * time.Second,
+		HalfOpenRequests: 2,
+	}
+	unavailable := status.Error(codes.Unavailable, "down")
+	call := func(err error, latency time.Duration) error {
+		t.Helper()
+		gen, e := cb.allow(bp)
+		if e != nil {
+			return e
+		}
+		cb.record(bp, gen, err, latency)
+		return nil
+	}
+
+	// 请求数不足时不熔断
+	for i := 0; i < 3; i++ {
+		call(unavailable, 0)
+	}
+	if cb.State() != BreakerClosed {
+		t.Fatalf("unexpected state: %v", cb.State())
+	}
+
+	// 窗口滑过后旧的失败不再计入
+	now = now.Add(11 * time.Second)
+	for i := 0; i < 3; i++ {
+		call(nil, 0)
+	}
+	call(unavailable, 0)
+	if cb.State() != BreakerClosed {
+		t.Fatalf("unexpected state: %v", cb.State())
+	}
+	stale, _ := cb.allow(bp)
+	call(unavailable, 0)
+	call(unavailable, 0)
+	if cb.State() != BreakerOpen {
+		t.Fatalf("unexpected state: %v", cb.State())
+	}
+	gauge := metrics.NewGauge("breaker_state", "target", "cb", "method", checkMethod)
+	if gauge.Value() != int64(BreakerOpen) {
+		t.Fatalf("unexpected gauge: %d", gauge.Value())
+	}
+	if err := call(nil, 0); status.Code(err) != errcode.ErrCircuitOpen {
+		t.Fatalf("expected circuit open, got %v", err)
+	}
+
+	// 半开时只放行half_open_requests个探测，失败重新熔断
+	now = now.Add(5 * time.Second)
+	gen1, err1 := cb.allow(bp)
+	gen2, err2 := cb.allow(bp)
+	if _, err := cb.allow(bp); err1 != nil || err2 != nil || status.Code(err) != errcode.ErrCircuitOpen {
+		t.Fatalf("unexpected probes: %v %v %v", err1, err2, err)
+	}
+	if cb.State() != BreakerHalfOpen || gauge.Value() != int64(BreakerHalfOpen) {
+		t.Fatalf("unexpected state: %v", cb.State())
+	}
+	cb.record(bp, gen1, nil, 0)
+	cb.record(bp, gen2, nil, 200*time.Millisecond)
+	if cb.State() != BreakerOpen {
+		t.Fatalf("slow probe should reopen: %v", cb.State())
+	}
+
+	// 全部探测成功后恢复，熔断前发出的调用结果被忽略
+	now = now.Add(5 * time.Second)
+	call(nil, 0)
+	call(nil, 0)
+	if cb.State() != BreakerClosed || gauge.Value() != int64(BreakerClosed) {
+		t.Fatalf("unexpected state: %v", cb.State())
+	}
+	cb.record(bp, stale, unavailable, 0)
+	call(nil, 0)
+	call(unavailable, 0)
+	call(unavailable, 0)
+	if cb.State() != BreakerClosed {
+		t.Fatalf("unexpected state: %v", cb.State())
+	}
+
+	// 慢调用比例过高同样熔断，调用方取消不计入
+	now = now.Add(11 * time.Second)
+	for i := 0; i < 4; i++ {
+		call(status.Error(codes.Canceled, "canceled"), 0)
+	}
+	call(nil, 0)
+	call(nil, 200*time.Millisecond)
+	call(nil, 200*time.Millisecond)
+	if cb.State() != BreakerClosed {
+		t.Fatalf("unexpected state: %v", cb.State())
+	}
+	call(nil, 200*time.Millisecond)
+	if cb.State() != BreakerOpen {
+		t.Fatalf("unexpected state: %v", cb.State())
+	}
+}
+
+func TestBreakerUnaryClientMW(t *testing.T) {
+	setClientPolicy(t, "breaker", map[interface{}]interface{}{
+		"default": map[interface{}]interface{}{
+			"breaker": map[interface{}]interface{}{"min_requests": 3, "open_duration": "1h"},
+		},
+	})
+
+	listener := bufconn.Listen(1 << 20)
+	health := &flakyHealth{check: func(ctx context.Context, n int32) error {
+		return status.Error(codes.Unavailable, "down")
+	}}
+	s := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(s, health)
+	go s.Serve(listener)
+	defer s.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithChainUnaryInterceptor(BreakerUnaryClientMW("breaker")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := grpc_health_v1.NewHealthClient(conn)
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); status.Code(err) != codes.Unavailable {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	rejects := metrics.NewCounter("breaker_reject", "target", "breaker", "method", checkMethod)
+	before := rejects.Count()
+	_, err = client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if status.Code(err) != errcode.ErrCircuitOpen || health.calls != 3 || rejects.Count()-before != 1 {
+		t.Fatalf("unexpected err=%v calls=%d", err, health.calls)
+	}
+	if _, failed := errcode.From(err); !failed {
+		t.Fatal("circuit open should be a failure")
+	}
+	if v := metrics.NewGauge("breaker_state", "target", "breaker", "method", checkMethod).Value(); v != int64(BreakerOpen) {
+		t.Fatalf("unexpected gauge: %d", v)
+	}
+
+	setClientPolicy(t, "breaker_invalid", map[interface{}]interface{}{
+		"default": map[interface{}]interface{}{
+			"breaker": map[interface{}]interface{}{"error_rate": 2},
+		},
+	})
+	if _, err := LoadClientPolicy("breaker_invalid"); err == nil {
+		t.Fatal("expected error for error_rate > 1")
+	}
+}
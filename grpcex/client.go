@@ -2,13 +2,13 @@ package grpcex
 
 import (
 	"context"
-	"fmt"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/rickone/athena/common"
-	"github.com/rickone/athena/config"
+	"github.com/rickone/athena/consul"
+	"github.com/rickone/athena/discovery"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/balancer/roundrobin"
 )
@@ -23,41 +23,64 @@ var (
 )
 
 func Dial(address string, mws ...grpc.UnaryClientInterceptor) (*grpc.ClientConn, error) {
-	mws = append([]grpc.UnaryClientInterceptor{CtxUnaryClientMW()}, mws...)
+	return dial(address, roundrobin.Name, nil, mws...)
+}
+
+func dial(address string, balancerName string, streamMws []grpc.StreamClientInterceptor, mws ...grpc.UnaryClientInterceptor) (*grpc.ClientConn, error) {
+	mws = append([]grpc.UnaryClientInterceptor{CtxUnaryClientMW(), TraceUnaryClientMW}, mws...)
+	streamMws = append([]grpc.StreamClientInterceptor{CtxStreamClientMW(), TraceStreamClientMW}, streamMws...)
+
+	cred, err := dialCredOption()
+	if err != nil {
+		return nil, err
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
 	defer cancel()
 
 	return grpc.DialContext(ctx, address,
-		grpc.WithInsecure(),
-		grpc.WithBalancerName(roundrobin.Name),
+		cred,
+		grpc.WithBalancerName(balancerName),
 		grpc.WithChainUnaryInterceptor(mws...),
+		grpc.WithChainStreamInterceptor(streamMws...),
 	)
 }
 
+// DialByName 按服务名连接，调用策略见ClientPolicy
 func DialByName(target string, mws ...grpc.UnaryClientInterceptor) (*grpc.ClientConn, error) {
+	var streamMws []grpc.StreamClientInterceptor
 	if os.Getenv("ENV") != "test" {
 		mws = append([]grpc.UnaryClientInterceptor{RerouteUnaryClientMW(target)}, mws...)
+		streamMws = append(streamMws, RerouteStreamClientMW(target))
 	}
+	// 重试的每次调用都经过熔断
+	mws = append([]grpc.UnaryClientInterceptor{PolicyUnaryClientMW(target), BreakerUnaryClientMW(target)}, mws...)
 
-	consulAddress := config.GetString("service", "consul")
-	return Dial(fmt.Sprintf("consul://%s/%s", consulAddress, target), mws...)
+	address, err := discovery.Target(target)
+	if err != nil {
+		return nil, err
+	}
+
+	// 按注册权重选择节点并暂时摘除连续失败的节点，见consul.WithTag、consul.OutlierConfig
+	return dial(address, consul.OutlierBalancerName, streamMws, mws...)
 }
 
-func initGrpcConn(name string) *grpc.ClientConn {
+func initGrpcConn(name string) (*grpc.ClientConn, error) {
 	mu.Lock()
 	defer mu.Unlock()
 
 	conn, ok := clients[name]
 	if ok {
-		return conn
+		return conn, nil
 	}
 
 	conn, err := DialByName(name)
-	common.AssertError(err)
+	if err != nil {
+		return nil, err
+	}
 
 	clients[name] = conn
-	return conn
+	return conn, nil
 }
 
 func getGrpcConn(name string) *grpc.ClientConn {
@@ -68,9 +91,15 @@ func getGrpcConn(name string) *grpc.ClientConn {
 }
 
 func Client(name string) *grpc.ClientConn {
-	conn := getGrpcConn(name)
-	if conn != nil {
-		return conn
+	conn, err := ClientConn(name)
+	common.AssertError(err)
+	return conn
+}
+
+// ClientConn 同Client，连接失败时返回错误
+func ClientConn(name string) (*grpc.ClientConn, error) {
+	if conn := getGrpcConn(name); conn != nil {
+		return conn, nil
 	}
 	return initGrpcConn(name)
 }
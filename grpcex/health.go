@@ -2,19 +2,92 @@ package grpcex
 
 import (
 	"context"
+	"fmt"
+	"sync"
 
+	"github.com/rickone/athena/health"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
+// healthService 由health.Registry提供状态，空服务名为整体状态
 type healthService struct {
+	registry *health.Registry
 }
 
 func (h *healthService) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
-	return &grpc_health_v1.HealthCheckResponse{
-		Status: grpc_health_v1.HealthCheckResponse_SERVING,
-	}, nil
+	st, ok := h.registry.Status(req.Service)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown service %s", req.Service)
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: servingStatus(st)}, nil
 }
 
-func (h *healthService) Watch(*grpc_health_v1.HealthCheckRequest, grpc_health_v1.Health_WatchServer) error {
+// Watch 先返回当前状态，之后状态变化时返回，直到调用方断开
+func (h *healthService) Watch(req *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	ch, cancel := h.registry.Watch(req.Service)
+	defer cancel()
+
+	for {
+		select {
+		case st := <-ch:
+			if err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: servingStatus(st)}); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+func servingStatus(st health.Status) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	switch st {
+	case health.StatusServing:
+		return grpc_health_v1.HealthCheckResponse_SERVING
+	case health.StatusNotServing:
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	default:
+		return grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN
+	}
+}
+
+// selfProbe 通过gRPC健康检查访问服务自身，用于TTL心跳，服务卡死时心跳随之失败
+type selfProbe struct {
+	address string
+	service string
+
+	once sync.Once
+	conn *grpc.ClientConn
+	err  error
+}
+
+func (p *selfProbe) Probe(ctx context.Context) error {
+	p.once.Do(func() {
+		var cred grpc.DialOption
+		if cred, p.err = dialCredOption(); p.err != nil {
+			return
+		}
+		// 按服务名校验证书，与DialByName一致
+		p.conn, p.err = grpc.Dial(p.address, cred, grpc.WithAuthority(p.service))
+	})
+	if p.err != nil {
+		return p.err
+	}
+
+	resp, err := grpc_health_v1.NewHealthClient(p.conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: p.service})
+	if err != nil {
+		return err
+	}
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		return fmt.Errorf("health status %s", resp.Status)
+	}
 	return nil
 }
+
+func (p *selfProbe) Close() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+}
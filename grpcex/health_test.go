@@ -0,0 +1,84 @@
+package grpcex
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rickone/athena/health"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestHealthService(t *testing.T) {
+	registry := health.NewRegistry()
+	registry.AddService("user")
+	var redisErr error
+	registry.Register("redis", func(ctx context.Context) error { return redisErr })
+
+	listener := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(s, &healthService{registry: registry})
+	go s.Serve(listener)
+	defer s.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := grpc_health_v1.NewHealthClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if _, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "pay"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+
+	watch, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{Service: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := func(want grpc_health_v1.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		resp, err := watch.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != want {
+			t.Fatalf("expected %v, got %v", want, resp.Status)
+		}
+
+		check, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "user"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if check.Status != want {
+			t.Fatalf("check expected %v, got %v", want, check.Status)
+		}
+	}
+	expect(grpc_health_v1.HealthCheckResponse_SERVING)
+
+	redisErr = errors.New("down")
+	registry.Refresh(ctx, time.Second)
+	expect(grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+
+	redisErr = nil
+	registry.Refresh(ctx, time.Second)
+	expect(grpc_health_v1.HealthCheckResponse_SERVING)
+
+	// 优雅退出时置为NOT_SERVING
+	registry.Shutdown()
+	expect(grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+}
@@ -0,0 +1,229 @@
+package grpcex
+
+import (
+	"context"
+	"net/textproto"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rickone/athena/logger"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+)
+
+// 随请求传递的固定字段，同时也是gRPC metadata和NSQ消息中的键
+const (
+	MetaCaller    = "caller"
+	MetaRequestId = "request_id"
+	MetaClientIp  = "client_ip"
+	MetaUserId    = "user_id"
+	MetaTopic     = "topic"
+	MetaReroute   = "reroute"
+)
+
+// RerouteHeader gin中reroute标记取自该请求头，见ginex.RerouteMW
+const RerouteHeader = "X-Reroute"
+
+var (
+	metaKeys   = map[string]bool{}
+	metaKeysMu = sync.RWMutex{}
+)
+
+// RegisterMetaKey 注册额外传递的键，如tenant、app_id，
+// 经gRPC metadata、NSQ消息自动传递，gin中取自请求头X-<Key>(如X-App-Id)
+func RegisterMetaKey(keys ...string) {
+	metaKeysMu.Lock()
+	defer metaKeysMu.Unlock()
+
+	for _, key := range keys {
+		metaKeys[strings.ToLower(key)] = true
+	}
+}
+
+func registeredMetaKeys() []string {
+	metaKeysMu.RLock()
+	defer metaKeysMu.RUnlock()
+
+	keys := make([]string, 0, len(metaKeys))
+	for key := range metaKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MetaHeader 注册键对应的HTTP请求头
+func MetaHeader(key string) string {
+	return "X-" + textproto.CanonicalMIMEHeaderKey(strings.ReplaceAll(key, "_", "-"))
+}
+
+// RequestMeta 请求上下文，Service/Method为当前处理的服务和方法，其余字段随调用链传递
+type RequestMeta struct {
+	Service   string
+	Method    string
+	Caller    string
+	RequestId string
+	ClientIp  string
+	UserId    string
+	Topic     string
+	// Reroute 调试流量的路由标记，见redis.SetReroute
+	Reroute string
+	// Extra 通过RegisterMetaKey注册的键
+	Extra map[string]string
+	// Peer mTLS对端证书中的身份，见PeerIdentity，不向下游传递
+	Peer string
+	// Scope 用户的授权范围，仅在签名身份中传递，见AuthPolicy
+	Scope string
+	// Claims 验证通过的签名身份，未启用auth或请求未签名时为nil
+	Claims *Claims
+
+	logger *logrus.Entry
+}
+
+// Meta 返回ctx中的RequestMeta，不存在时返回空值，可直接访问字段；
+// *gin.Context由请求生成，见MetaFromGin
+func Meta(ctx context.Context) *RequestMeta {
+	if meta, ok := ctx.Value(rpcCtxKey).(*RequestMeta); ok {
+		return meta
+	}
+	if c, ok := ctx.(*gin.Context); ok {
+		return MetaFromGin(c)
+	}
+	return &RequestMeta{}
+}
+
+// NewContext 生成带RequestMeta的ctx，并创建附带这些字段的日志
+func NewContext(ctx context.Context, meta *RequestMeta) context.Context {
+	dup := *meta
+	dup.logger = logger.NewEntry(ctx, dup.fields())
+	return context.WithValue(ctx, rpcCtxKey, &dup)
+}
+
+func (m *RequestMeta) Get(key string) string {
+	return m.Extra[key]
+}
+
+// Logger 附带请求字段的日志，ctx中没有RequestMeta时返回标准日志
+func (m *RequestMeta) Logger() *logrus.Entry {
+	if m.logger == nil {
+		return logrus.NewEntry(logrus.StandardLogger())
+	}
+	return m.logger
+}
+
+func (m *RequestMeta) fields() logrus.Fields {
+	fields := logrus.Fields{
+		"request_id": m.RequestId,
+		"service":    m.Service,
+		"method":     m.Method,
+	}
+	if m.Peer != "" {
+		fields["peer"] = m.Peer
+	}
+	for k, v := range m.Propagated() {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// Propagated 需要向下游传递的键值，不含Service和Method
+func (m *RequestMeta) Propagated() map[string]string {
+	kvs := map[string]string{
+		MetaCaller:    m.Caller,
+		MetaRequestId: m.RequestId,
+		MetaClientIp:  m.ClientIp,
+		MetaUserId:    m.UserId,
+		MetaTopic:     m.Topic,
+		MetaReroute:   m.Reroute,
+	}
+	for _, key := range registeredMetaKeys() {
+		if v := m.Extra[key]; v != "" {
+			kvs[key] = v
+		}
+	}
+	return kvs
+}
+
+// MetaFromMap 由Propagated的结果还原，忽略未注册的键
+func MetaFromMap(kvs map[string]string) *RequestMeta {
+	meta := &RequestMeta{
+		Caller:    kvs[MetaCaller],
+		RequestId: kvs[MetaRequestId],
+		ClientIp:  kvs[MetaClientIp],
+		UserId:    kvs[MetaUserId],
+		Topic:     kvs[MetaTopic],
+		Reroute:   kvs[MetaReroute],
+	}
+	for _, key := range registeredMetaKeys() {
+		if v := kvs[key]; v != "" {
+			if meta.Extra == nil {
+				meta.Extra = map[string]string{}
+			}
+			meta.Extra[key] = v
+		}
+	}
+	return meta
+}
+
+// MetaFromGin 由gin请求生成RequestMeta，用户取自AuthInfo.UserId和Scope，注册键取自请求头，
+// reroute标记取自RerouteMW的结果或X-Reroute请求头
+func MetaFromGin(c *gin.Context) *RequestMeta {
+	meta := &RequestMeta{
+		Method:    c.FullPath(),
+		RequestId: c.GetString("Request-Id"),
+		ClientIp:  c.ClientIP(),
+		Reroute:   c.GetString("Reroute"),
+	}
+	if meta.Reroute == "" {
+		meta.Reroute = c.GetHeader(RerouteHeader)
+	}
+
+	if authInfo, ok := c.Get("AuthInfo"); ok {
+		val := reflect.ValueOf(authInfo)
+		if val.Kind() == reflect.Ptr {
+			val = val.Elem()
+		}
+		meta.UserId = strconv.FormatInt(val.FieldByName("UserId").Int(), 10)
+		if scope := val.FieldByName("Scope"); scope.Kind() == reflect.String {
+			meta.Scope = scope.String()
+		}
+	}
+
+	for _, key := range registeredMetaKeys() {
+		if v := c.GetHeader(MetaHeader(key)); v != "" {
+			if meta.Extra == nil {
+				meta.Extra = map[string]string{}
+			}
+			meta.Extra[key] = v
+		}
+	}
+	return meta
+}
+
+// metaFromIncoming 由收到的gRPC metadata生成RequestMeta
+func metaFromIncoming(md metadata.MD) *RequestMeta {
+	kvs := map[string]string{}
+	for key, vals := range md {
+		if len(vals) > 0 {
+			kvs[key] = vals[0]
+		}
+	}
+	return MetaFromMap(kvs)
+}
+
+// appendOutgoing 将RequestMeta写入发出的gRPC metadata，空值不写
+func appendOutgoing(ctx context.Context, meta *RequestMeta) context.Context {
+	var kvs []string
+	for k, v := range meta.Propagated() {
+		if v != "" || k == MetaCaller {
+			kvs = append(kvs, k, v)
+		}
+	}
+	return metadata.AppendToOutgoingContext(ctx, kvs...)
+}
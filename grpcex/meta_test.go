@@ -0,0 +1,66 @@
+package grpcex
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRequestMetaGrpcRoundTrip(t *testing.T) {
+	RegisterMetaKey("tenant", "app_id")
+
+	ctx := NewContext(context.Background(), &RequestMeta{
+		Service:   "order",
+		Method:    "Create",
+		Caller:    "gateway",
+		RequestId: "req-1",
+		UserId:    "42",
+		Extra:     map[string]string{"tenant": "acme", "app_id": "7", "unregistered": "x"},
+	})
+
+	out := outgoingCtx(ctx, "order")
+	md, _ := metadata.FromOutgoingContext(out)
+	if _, ok := md["unregistered"]; ok {
+		t.Fatal("unregistered key should not be propagated")
+	}
+
+	in := incomingCtx(metadata.NewIncomingContext(context.Background(), md), "/pay.Pay/Charge")
+	meta := Meta(in)
+	if meta.Service != "pay.Pay" || meta.Method != "Charge" || meta.Caller != "order" {
+		t.Fatalf("unexpected meta: %+v", meta)
+	}
+	if meta.RequestId != "req-1" || meta.UserId != "42" || meta.Get("tenant") != "acme" || meta.Get("app_id") != "7" {
+		t.Fatalf("unexpected meta: %+v", meta)
+	}
+	if meta.Logger().Data["tenant"] != "acme" {
+		t.Fatalf("logger missing tenant: %v", meta.Logger().Data)
+	}
+
+	// 兼容旧接口
+	if GetCtxValue(in, "request_id") != "req-1" || GetCtxValue(in, "tenant") != "acme" || GetCtxValue(in, "topic") != nil {
+		t.Fatal("GetCtxValue mismatch")
+	}
+}
+
+func TestRequestMetaGin(t *testing.T) {
+	RegisterMetaKey("tenant")
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/orders", nil)
+	c.Request.Header.Set(MetaHeader("tenant"), "acme")
+	c.Set("Request-Id", "req-2")
+	c.Set("AuthInfo", &struct{ UserId int64 }{UserId: 9})
+
+	meta := Meta(c)
+	if meta.RequestId != "req-2" || meta.UserId != "9" || meta.Get("tenant") != "acme" {
+		t.Fatalf("unexpected meta: %+v", meta)
+	}
+
+	md, _ := metadata.FromOutgoingContext(outgoingCtx(c, "gateway"))
+	if md.Get("tenant")[0] != "acme" || md.Get("request_id")[0] != "req-2" || md.Get("caller")[0] != "gateway" {
+		t.Fatalf("unexpected metadata: %v", md)
+	}
+}
@@ -4,19 +4,14 @@ import (
 	"context"
 	"log"
 	"os"
-	"reflect"
 	"regexp"
 	"runtime/debug"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	redigo "github.com/gomodule/redigo/redis"
 	"github.com/rickone/athena/errcode"
-	"github.com/rickone/athena/logger"
 	"github.com/rickone/athena/metrics"
-	"github.com/rickone/athena/redis"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
@@ -37,206 +32,134 @@ func CtxUnaryClientMW() grpc.UnaryClientInterceptor {
 	service := os.Getenv("Service")
 
 	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-		kvs := []string{"caller", service}
-		if c, ok := ctx.(*gin.Context); ok {
-			reqId := c.GetString("Request-Id")
-			clientIp := c.ClientIP()
-			kvs = append(kvs, "request_id", reqId, "client_ip", clientIp)
-
-			userId := ""
-			authInfo, ok := c.Get("AuthInfo")
-			if ok {
-				val := reflect.ValueOf(authInfo)
-				if val.Kind() == reflect.Ptr {
-					val = val.Elem()
-				}
-
-				userId = strconv.FormatInt(val.FieldByName("UserId").Int(), 10)
-				kvs = append(kvs, "user_id", userId)
-			}
-
-			ctx = NewCtxWithValue(ctx, service, c.FullPath(), "", reqId, clientIp, userId, "")
-		} else {
-			reqId := GetCtxValue(ctx, "request_id")
-			if reqId != nil {
-				kvs = append(kvs, "request_id", reqId.(string))
-			}
-
-			clientIp := GetCtxValue(ctx, "client_ip")
-			if clientIp != nil {
-				kvs = append(kvs, "client_ip", clientIp.(string))
-			}
-
-			userId := GetCtxValue(ctx, "user_id")
-			if userId != nil {
-				kvs = append(kvs, "user_id", userId.(string))
-			}
-
-			topic := GetCtxValue(ctx, "topic")
-			if topic != nil {
-				kvs = append(kvs, "topic", topic.(string))
-			}
-		}
-
-		ctx = metadata.AppendToOutgoingContext(ctx, kvs...)
-		return invoker(ctx, method, req, reply, cc, opts...)
+		return invoker(outgoingCtx(ctx, service), method, req, reply, cc, opts...)
 	}
 }
 
-func CtxUnaryServerMW(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return handler(ctx, req)
-	}
-
-	caller := ""
-	vals := md.Get("caller")
-	if len(vals) > 0 {
-		caller = vals[0]
-	}
-
-	reqId := ""
-	vals = md.Get("request_id")
-	if len(vals) > 0 {
-		reqId = vals[0]
+// outgoingCtx 将调用方及请求信息写入发出的metadata，auth.keys配置时附带签名身份
+func outgoingCtx(ctx context.Context, service string) context.Context {
+	var meta *RequestMeta
+	if c, ok := ctx.(*gin.Context); ok {
+		meta = MetaFromGin(c)
+		meta.Service = service
+		ctx = NewContext(ctx, meta)
+	} else {
+		dup := *Meta(ctx)
+		meta = &dup
 	}
 
-	clientIp := ""
-	vals = md.Get("client_ip")
-	if len(vals) > 0 {
-		clientIp = vals[0]
-	}
+	meta.Caller = service
+	return appendIdentity(appendOutgoing(ctx, meta), meta)
+}
 
-	userId := ""
-	vals = md.Get("user_id")
-	if len(vals) > 0 {
-		userId = vals[0]
-	}
+func CtxUnaryServerMW(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(incomingCtx(ctx, info.FullMethod), req)
+}
 
-	topic := ""
-	vals = md.Get("topic")
-	if len(vals) > 0 {
-		topic = vals[0]
+// incomingCtx 从收到的metadata中恢复调用方及请求信息
+func incomingCtx(ctx context.Context, fullMethod string) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
 	}
 
-	subs := regFullMethod.FindStringSubmatch(info.FullMethod)
+	subs := regFullMethod.FindStringSubmatch(fullMethod)
 	if len(subs) != 3 {
-		return handler(ctx, req)
+		return ctx
 	}
 
-	ctx = NewCtxWithValue(ctx, subs[1], subs[2], caller, reqId, clientIp, userId, topic)
-	return handler(ctx, req)
+	meta := metaFromIncoming(md)
+	meta.Service = subs[1]
+	meta.Method = subs[2]
+	meta.Peer = PeerIdentity(ctx)
+	return NewContext(ctx, meta)
 }
 
+// Deprecated: 使用NewContext
 func NewCtxWithValue(ctx context.Context, service string, method string, caller string,
 	requestId string, clientIp string, userId string, topic string) context.Context {
-	fields := map[string]interface{}{
-		"request_id": requestId,
-		"service":    service,
-		"method":     method,
-	}
-	if caller != "" {
-		fields["caller"] = caller
-	}
-	if clientIp != "" {
-		fields["client_ip"] = clientIp
-	}
-	if userId != "" {
-		fields["user_id"] = userId
-	}
-	if topic != "" {
-		fields["topic"] = topic
-	}
-
-	entry := logger.NewEntry(ctx, fields)
-
-	ctxValue := map[string]interface{}{
-		"request_id": requestId,
-		"service":    service,
-		"method":     method,
-		"logger":     entry,
-	}
-	if caller != "" {
-		ctxValue["caller"] = caller
-	}
-	if clientIp != "" {
-		ctxValue["client_ip"] = clientIp
-	}
-	if userId != "" {
-		ctxValue["user_id"] = userId
-	}
-	if topic != "" {
-		ctxValue["topic"] = topic
-	}
-
-	return context.WithValue(ctx, rpcCtxKey, ctxValue)
+	return NewContext(ctx, &RequestMeta{
+		Service:   service,
+		Method:    method,
+		Caller:    caller,
+		RequestId: requestId,
+		ClientIp:  clientIp,
+		UserId:    userId,
+		Topic:     topic,
+	})
 }
 
+// Deprecated: 使用Meta(ctx)的字段
 func GetCtxValue(ctx context.Context, field string) interface{} {
-	obj := ctx.Value(rpcCtxKey)
-	if obj == nil {
-		return nil
-	}
-
-	fields, ok := obj.(map[string]interface{})
+	meta, ok := ctx.Value(rpcCtxKey).(*RequestMeta)
 	if !ok {
 		return nil
 	}
 
-	return fields[field]
-}
-
-func GetLogger(ctx context.Context) *logrus.Entry {
-	entry := GetCtxValue(ctx, "logger")
-	if entry == nil {
+	var val string
+	switch field {
+	case "service":
+		return meta.Service
+	case "method":
+		return meta.Method
+	case MetaRequestId:
+		return meta.RequestId
+	case "logger":
+		return meta.logger
+	case MetaCaller:
+		val = meta.Caller
+	case MetaClientIp:
+		val = meta.ClientIp
+	case MetaUserId:
+		val = meta.UserId
+	case MetaTopic:
+		val = meta.Topic
+	default:
+		val = meta.Extra[field]
+	}
+
+	if val == "" {
 		return nil
 	}
-
-	if obj, ok := entry.(*logrus.Entry); ok {
-		return obj
-	}
-
-	return logrus.StandardLogger().WithContext(ctx)
+	return val
 }
 
-func getRerouteClientConn(ctx context.Context, target string) (*grpc.ClientConn, error) {
-	reqId := GetCtxValue(ctx, "request_id")
-	if reqId == nil {
-		return nil, nil
-	}
-
-	ss := strings.Split(reqId.(string), "#")
-	if len(ss) != 2 {
-		return nil, nil
-	}
-
-	addr, err := redigo.String(redis.DB("reroute").Do("HGET", ss[1], target))
-	if err != nil {
-		if err == redigo.ErrNil {
-			return nil, nil
-		}
-		return nil, err
+func GetLogger(ctx context.Context) *logrus.Entry {
+	meta, ok := ctx.Value(rpcCtxKey).(*RequestMeta)
+	if !ok || meta.logger == nil {
+		return logrus.StandardLogger().WithContext(ctx)
 	}
-
-	return Dial(addr)
+	return meta.logger
 }
 
+// RerouteUnaryClientMW 带有reroute标记的请求按redis中的规则改为访问指定地址，见redis.SetReroute
 func RerouteUnaryClientMW(target string) grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-		conn, err := getRerouteClientConn(ctx, target)
+		conn, release, err := getRerouteClientConn(ctx, target)
 		if err != nil {
 			return err
 		}
 		if conn == nil {
 			return invoker(ctx, method, req, reply, cc, opts...)
 		}
+		defer release()
 		return invoker(ctx, method, req, reply, conn, opts...)
 	}
 }
 
+// AccessLogMW 记录所有请求及请求内容，NewGrpcService按grpc_server的方法策略记录，见ServerPolicy
 func AccessLogMW(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	service := GetCtxValue(ctx, "service")
-	if service == nil || service.(string) == "grpc.health.v1.Health" {
+	return accessLog(ctx, req, handler, &defaultMethodPolicy)
+}
+
+func accessLogMW(policyOf func(fullMethod string) *MethodPolicy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return accessLog(ctx, req, handler, policyOf(info.FullMethod))
+	}
+}
+
+func accessLog(ctx context.Context, req interface{}, handler grpc.UnaryHandler, policy *MethodPolicy) (interface{}, error) {
+	if isHealthMethod(ctx) {
 		return handler(ctx, req)
 	}
 
@@ -244,13 +167,18 @@ func AccessLogMW(ctx context.Context, req interface{}, info *grpc.UnaryServerInf
 	resp, err := handler(ctx, req)
 	latency := time.Now().Sub(start).Milliseconds()
 
+	code, failed := errcode.From(err)
+	if !policy.sampled(code) {
+		return resp, err
+	}
+
 	fields := map[string]interface{}{
-		"req":     req,
 		"latency": latency,
+		"code":    code,
+	}
+	if policy.LogBody {
+		fields["req"] = req
 	}
-
-	code, failed := errcode.From(err)
-	fields["code"] = code
 
 	if err != nil {
 		fields["err"] = err.Error()
@@ -288,8 +216,7 @@ func RecoveryMW(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo
 }
 
 func MetricsUnaryMW(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	service := GetCtxValue(ctx, "service")
-	if service == nil || service.(string) == "grpc.health.v1.Health" {
+	if isHealthMethod(ctx) {
 		return handler(ctx, req)
 	}
 
@@ -316,13 +243,17 @@ func ErrorMapUnaryMW(ctx context.Context, req interface{}, info *grpc.UnaryServe
 
 func TimeoutUnaryMW(timeout time.Duration) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		newCtx, cancel := context.WithTimeout(ctx, timeout)
-		defer cancel()
+		return invokeWithTimeout(ctx, req, handler, timeout)
+	}
+}
 
-		resp, err := handler(newCtx, req)
-		if newCtx.Err() == context.DeadlineExceeded {
-			return nil, status.Error(errcode.ErrRpcTimeout, "rpc timeout")
-		}
-		return resp, err
+func invokeWithTimeout(ctx context.Context, req interface{}, handler grpc.UnaryHandler, timeout time.Duration) (interface{}, error) {
+	newCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	resp, err := handler(newCtx, req)
+	if newCtx.Err() == context.DeadlineExceeded {
+		return nil, status.Error(errcode.ErrRpcTimeout, "rpc timeout")
 	}
+	return resp, err
 }
@@ -0,0 +1,310 @@
+package grpcex
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rickone/athena/config"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+const serverPolicyPath = "grpc_server"
+
+// 默认拦截器的名称，按以下顺序执行，见ReplaceUnaryInterceptor
+const (
+	InterceptorTrace = "trace"
+	InterceptorCtx   = "ctx"
+	// InterceptorAuth 位于访问日志之前，日志记录验证后的身份，拒绝的请求由auth记录
+	InterceptorAuth      = "auth"
+	InterceptorAccessLog = "access_log"
+	InterceptorRecovery  = "recovery"
+	InterceptorMetrics   = "metrics"
+	InterceptorErrorMap  = "error_map"
+	// InterceptorTimeout 仅unary
+	InterceptorTimeout = "timeout"
+)
+
+// ServerPolicy 对应配置中的grpc_server段，消息大小和keepalive在创建时生效，
+// 方法策略变化后自动生效；methods的键为完整方法名或服务名，完整方法名优先，
+// 未配置的字段使用默认值而不是default中的值:
+//   grpc_server:
+//     max_recv_msg_size: 16777216      # 默认4MB
+//     max_send_msg_size: 16777216
+//     keepalive:
+//       time: 2h                       # 连接空闲多久后ping客户端
+//       timeout: 20s
+//       max_connection_idle: 0         # 0表示不限制
+//       max_connection_age: 0
+//       max_connection_age_grace: 0
+//       min_time: 5m                   # 客户端ping的最小间隔，过于频繁时断开
+//       permit_without_stream: false
+//     default:
+//       timeout: 10s                   # 0表示不限制
+//       log_body: true                 # 访问日志是否记录请求内容
+//       log_sample: 1                  # 成功请求的访问日志采样比例，失败总是记录
+//     methods:
+//       /user.User/Export:
+//         timeout: 5m
+//         log_body: false
+//         log_sample: 0.01
+type ServerPolicy struct {
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+	Keepalive      *KeepalivePolicy
+	Default        MethodPolicy
+	Methods        map[string]MethodPolicy
+}
+
+type KeepalivePolicy struct {
+	Time                  time.Duration `default:"2h"`
+	Timeout               time.Duration `default:"20s"`
+	MaxConnectionIdle     time.Duration
+	MaxConnectionAge      time.Duration
+	MaxConnectionAgeGrace time.Duration
+	MinTime               time.Duration `default:"5m"`
+	PermitWithoutStream   bool
+}
+
+type MethodPolicy struct {
+	Timeout   time.Duration `default:"10s"`
+	LogBody   bool          `default:"true"`
+	LogSample float64       `default:"1"`
+}
+
+var defaultMethodPolicy = MethodPolicy{Timeout: rpcTimeout, LogBody: true, LogSample: 1}
+
+// LoadServerPolicy 读取grpc_server，未配置default时使用默认值
+func LoadServerPolicy() (*ServerPolicy, error) {
+	var policy ServerPolicy
+	if err := config.Unmarshal(&policy, serverPolicyPath); err != nil {
+		return nil, err
+	}
+	if config.GetValue(serverPolicyPath, "default") == nil {
+		policy.Default = defaultMethodPolicy
+	}
+
+	if err := policy.Default.validate(serverPolicyPath + ".default"); err != nil {
+		return nil, err
+	}
+	for method, p := range policy.Methods {
+		if err := p.validate(fmt.Sprintf("%s.methods.%s", serverPolicyPath, method)); err != nil {
+			return nil, err
+		}
+	}
+	return &policy, nil
+}
+
+func (p *MethodPolicy) validate(path string) error {
+	if p.Timeout < 0 {
+		return fmt.Errorf("%s.timeout must be >= 0", path)
+	}
+	if p.LogSample < 0 || p.LogSample > 1 {
+		return fmt.Errorf("%s.log_sample must be in [0, 1]", path)
+	}
+	return nil
+}
+
+// Method 返回方法对应的策略
+func (p *ServerPolicy) Method(fullMethod string) *MethodPolicy {
+	if mp, ok := p.Methods[fullMethod]; ok {
+		return &mp
+	}
+	if subs := regFullMethod.FindStringSubmatch(fullMethod); len(subs) == 3 {
+		if mp, ok := p.Methods[subs[1]]; ok {
+			return &mp
+		}
+	}
+	return &p.Default
+}
+
+func (p *ServerPolicy) serverOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if p.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(p.MaxRecvMsgSize))
+	}
+	if p.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(p.MaxSendMsgSize))
+	}
+	if ka := p.Keepalive; ka != nil {
+		opts = append(opts,
+			grpc.KeepaliveParams(keepalive.ServerParameters{
+				Time:                  ka.Time,
+				Timeout:               ka.Timeout,
+				MaxConnectionIdle:     ka.MaxConnectionIdle,
+				MaxConnectionAge:      ka.MaxConnectionAge,
+				MaxConnectionAgeGrace: ka.MaxConnectionAgeGrace,
+			}),
+			grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+				MinTime:             ka.MinTime,
+				PermitWithoutStream: ka.PermitWithoutStream,
+			}),
+		)
+	}
+	return opts
+}
+
+// watchServerPolicy 加载grpc_server并在配置变化后更新，新配置有误时保留原策略
+func watchServerPolicy() (*atomic.Value, error) {
+	policy, err := LoadServerPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	var current atomic.Value
+	current.Store(policy)
+	config.OnChange(serverPolicyPath, func(old, new *config.Value) {
+		policy, err := LoadServerPolicy()
+		if err != nil {
+			logrus.WithField("err", err.Error()).Error("Reload server policy failed")
+			return
+		}
+		current.Store(policy)
+	})
+	return &current, nil
+}
+
+var (
+	defaultServerPolicyOnce sync.Once
+	defaultServerPolicies   *atomic.Value
+	defaultServerPolicyErr  error
+)
+
+// defaultServerPolicy 进程内的GrpcService共用，config.OnChange无法取消订阅，只订阅一次
+func defaultServerPolicy() (*atomic.Value, error) {
+	defaultServerPolicyOnce.Do(func() {
+		defaultServerPolicies, defaultServerPolicyErr = watchServerPolicy()
+	})
+	return defaultServerPolicies, defaultServerPolicyErr
+}
+
+type namedUnaryInterceptor struct {
+	name string
+	mw   grpc.UnaryServerInterceptor
+}
+
+type namedStreamInterceptor struct {
+	name string
+	mw   grpc.StreamServerInterceptor
+}
+
+type serviceOptions struct {
+	unary       []namedUnaryInterceptor
+	stream      []namedStreamInterceptor
+	extraUnary  []grpc.UnaryServerInterceptor
+	extraStream []grpc.StreamServerInterceptor
+	serverOpts  []grpc.ServerOption
+}
+
+// ServiceOption NewGrpcServiceWithOptions的选项
+type ServiceOption func(*serviceOptions)
+
+// WithUnaryInterceptor 追加在默认拦截器之后，如鉴权
+func WithUnaryInterceptor(mws ...grpc.UnaryServerInterceptor) ServiceOption {
+	return func(o *serviceOptions) {
+		o.extraUnary = append(o.extraUnary, mws...)
+	}
+}
+
+func WithStreamInterceptor(mws ...grpc.StreamServerInterceptor) ServiceOption {
+	return func(o *serviceOptions) {
+		o.extraStream = append(o.extraStream, mws...)
+	}
+}
+
+// ReplaceUnaryInterceptor 替换名为name的默认拦截器，mw为nil时移除
+func ReplaceUnaryInterceptor(name string, mw grpc.UnaryServerInterceptor) ServiceOption {
+	return func(o *serviceOptions) {
+		for i := range o.unary {
+			if o.unary[i].name == name {
+				o.unary[i].mw = mw
+			}
+		}
+	}
+}
+
+func ReplaceStreamInterceptor(name string, mw grpc.StreamServerInterceptor) ServiceOption {
+	return func(o *serviceOptions) {
+		for i := range o.stream {
+			if o.stream[i].name == name {
+				o.stream[i].mw = mw
+			}
+		}
+	}
+}
+
+// WithServerOption 在grpc_server配置之后生效，可覆盖配置
+func WithServerOption(opts ...grpc.ServerOption) ServiceOption {
+	return func(o *serviceOptions) {
+		o.serverOpts = append(o.serverOpts, opts...)
+	}
+}
+
+func defaultServiceOptions(policies *atomic.Value, auth *atomic.Value) *serviceOptions {
+	policyOf := func(fullMethod string) *MethodPolicy {
+		return policies.Load().(*ServerPolicy).Method(fullMethod)
+	}
+
+	return &serviceOptions{
+		unary: []namedUnaryInterceptor{
+			{InterceptorTrace, TraceUnaryServerMW},
+			{InterceptorCtx, CtxUnaryServerMW},
+			{InterceptorAuth, authUnaryMW(auth)},
+			{InterceptorAccessLog, accessLogMW(policyOf)},
+			{InterceptorRecovery, RecoveryMW},
+			{InterceptorMetrics, MetricsUnaryMW},
+			{InterceptorErrorMap, ErrorMapUnaryMW},
+			{InterceptorTimeout, methodTimeoutMW(policyOf)},
+		},
+		stream: []namedStreamInterceptor{
+			{InterceptorTrace, TraceStreamServerMW},
+			{InterceptorCtx, CtxStreamServerMW},
+			{InterceptorAuth, authStreamMW(auth)},
+			{InterceptorAccessLog, accessLogStreamMW(policyOf)},
+			{InterceptorRecovery, RecoveryStreamMW},
+			{InterceptorMetrics, MetricsStreamMW},
+			{InterceptorErrorMap, ErrorMapStreamMW},
+		},
+	}
+}
+
+func (o *serviceOptions) chain() []grpc.ServerOption {
+	var unary []grpc.UnaryServerInterceptor
+	for _, n := range o.unary {
+		if n.mw != nil {
+			unary = append(unary, n.mw)
+		}
+	}
+	var stream []grpc.StreamServerInterceptor
+	for _, n := range o.stream {
+		if n.mw != nil {
+			stream = append(stream, n.mw)
+		}
+	}
+
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(append(unary, o.extraUnary...)...),
+		grpc.ChainStreamInterceptor(append(stream, o.extraStream...)...),
+	}
+}
+
+// methodTimeoutMW 按方法策略设置超时
+func methodTimeoutMW(policyOf func(fullMethod string) *MethodPolicy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		timeout := policyOf(info.FullMethod).Timeout
+		if timeout <= 0 {
+			return handler(ctx, req)
+		}
+		return invokeWithTimeout(ctx, req, handler, timeout)
+	}
+}
+
+// sampled 成功请求按log_sample采样
+func (p *MethodPolicy) sampled(code int) bool {
+	return code != 0 || p.LogSample >= 1 || rand.Float64() < p.LogSample
+}
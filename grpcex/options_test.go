@@ -0,0 +1,205 @@
+package grpcex
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rickone/athena/config"
+	"github.com/rickone/athena/errcode"
+	"github.com/sirupsen/logrus/hooks/test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// setServerPolicy 替换grpc_server，测试结束后移除
+func setServerPolicy(t *testing.T, policy map[interface{}]interface{}) {
+	t.Helper()
+
+	config.UpdateValue(serverPolicyPath, nil)
+	if err := config.UpdateValue(serverPolicyPath, policy); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		config.UpdateValue(serverPolicyPath, nil)
+	})
+}
+
+func startService(t *testing.T, check func(ctx context.Context, n int32) error, opts ...ServiceOption) grpc_health_v1.HealthClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	s := NewGrpcServiceWithOptions("", opts...)
+	grpc_health_v1.RegisterHealthServer(s.Server, &flakyHealth{check: check})
+	go s.Server.Serve(listener)
+	t.Cleanup(s.Server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return grpc_health_v1.NewHealthClient(conn)
+}
+
+func TestServiceMethodTimeout(t *testing.T) {
+	setServerPolicy(t, map[interface{}]interface{}{
+		"methods": map[interface{}]interface{}{
+			checkMethod: map[interface{}]interface{}{"timeout": "50ms"},
+		},
+	})
+
+	slow := func(ctx context.Context, n int32) error {
+		select {
+		case <-ctx.Done():
+		case <-time.After(300 * time.Millisecond):
+		}
+		return nil
+	}
+
+	client := startService(t, slow)
+	_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if status.Code(err) != errcode.ErrRpcTimeout {
+		t.Fatalf("expected rpc timeout, got %v", err)
+	}
+
+	// 配置变化后生效，服务名也可匹配
+	setServerPolicy(t, map[interface{}]interface{}{
+		"methods": map[interface{}]interface{}{
+			"grpc.health.v1.Health": map[interface{}]interface{}{"timeout": "1s"},
+		},
+	})
+	if _, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Fatalf("expected longer timeout after reload, got %v", err)
+	}
+}
+
+func TestServiceInterceptorOptions(t *testing.T) {
+	setServerPolicy(t, map[interface{}]interface{}{
+		"default": map[interface{}]interface{}{"timeout": "50ms"},
+	})
+
+	var order []string
+	auth := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		order = append(order, "auth")
+		if Meta(ctx).Caller != "order" {
+			return nil, status.Error(codes.PermissionDenied, "denied")
+		}
+		return handler(ctx, req)
+	}
+	// 替换后的ctx拦截器固定调用方，用于验证顺序
+	ctxMW := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		order = append(order, "ctx")
+		return handler(NewContext(ctx, &RequestMeta{Caller: "order"}), req)
+	}
+
+	client := startService(t, func(ctx context.Context, n int32) error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	},
+		ReplaceUnaryInterceptor(InterceptorTimeout, nil),
+		ReplaceUnaryInterceptor(InterceptorCtx, ctxMW),
+		WithUnaryInterceptor(auth),
+		WithServerOption(grpc.MaxRecvMsgSize(16)),
+	)
+
+	if _, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Fatalf("timeout interceptor should be removed, got %v", err)
+	}
+	if len(order) != 2 || order[0] != "ctx" || order[1] != "auth" {
+		t.Fatalf("unexpected order: %v", order)
+	}
+
+	_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "a service name longer than 16 bytes"})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+}
+
+func TestAccessLogPolicy(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	ctx := NewContext(context.Background(), &RequestMeta{Service: "test.Echo", Method: "Get"})
+	req := &grpc_health_v1.HealthCheckRequest{Service: "secret"}
+	ok := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}
+	failed := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.Unavailable, "down")
+	}
+
+	accessLog(ctx, req, ok, &MethodPolicy{LogBody: false, LogSample: 1})
+	entry := hook.LastEntry()
+	if entry == nil || entry.Message != "Access success" {
+		t.Fatalf("unexpected entry: %v", entry)
+	}
+	if _, ok := entry.Data["req"]; ok {
+		t.Fatal("req should not be logged")
+	}
+
+	// 成功请求不采样时不记录，失败总是记录
+	hook.Reset()
+	accessLog(ctx, req, ok, &MethodPolicy{LogBody: true, LogSample: 0})
+	if len(hook.AllEntries()) != 0 {
+		t.Fatalf("unexpected entries: %v", hook.AllEntries())
+	}
+	accessLog(ctx, req, failed, &MethodPolicy{LogBody: true, LogSample: 0})
+	if entry := hook.LastEntry(); entry == nil || entry.Message != "Access failed" || entry.Data["req"] != req {
+		t.Fatalf("unexpected entry: %v", entry)
+	}
+}
+
+func TestLoadServerPolicy(t *testing.T) {
+	policy, err := LoadServerPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mp := policy.Method(checkMethod); *mp != defaultMethodPolicy {
+		t.Fatalf("unexpected default policy: %+v", mp)
+	}
+
+	setServerPolicy(t, map[interface{}]interface{}{
+		"keepalive": map[interface{}]interface{}{},
+		"default":   map[interface{}]interface{}{"log_sample": 2},
+	})
+	if _, err := LoadServerPolicy(); err == nil {
+		t.Fatal("expected error for log_sample > 1")
+	}
+
+	setServerPolicy(t, map[interface{}]interface{}{
+		"max_recv_msg_size": 1024,
+		"keepalive":         map[interface{}]interface{}{"max_connection_age": "1h"},
+	})
+	policy, err = LoadServerPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ka := policy.Keepalive; ka.Time != 2*time.Hour || ka.MinTime != 5*time.Minute || ka.MaxConnectionAge != time.Hour {
+		t.Fatalf("unexpected keepalive: %+v", ka)
+	}
+	if len(policy.serverOptions()) != 3 {
+		t.Fatalf("unexpected server options: %d", len(policy.serverOptions()))
+	}
+}
+
+func TestDefaultServerPolicyShared(t *testing.T) {
+	a, err := defaultServerPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	NewGrpcService()
+	NewGrpcService()
+	if b, _ := defaultServerPolicy(); a != b {
+		t.Fatal("server policy not shared between services")
+	}
+}
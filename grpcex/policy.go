@@ -0,0 +1,324 @@
+package grpcex
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/rickone/athena/config"
+	"github.com/rickone/athena/metrics"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const clientPolicyPath = "grpc_client"
+
+// ClientPolicy 对应配置中grpc_client下的一个服务，methods的键为完整方法名或服务名，
+// 匹配时完整方法名优先，均未匹配时使用default:
+//   grpc_client:
+//     user:                            # DialByName的服务名
+//       default:
+//         timeout: 2s                  # 调用方ctx没有deadline时使用，包含所有重试
+//         retry:
+//           max_attempts: 3            # 含首次调用
+//           initial_backoff: 50ms
+//           max_backoff: 1s
+//           multiplier: 2
+//           jitter: 0.2                # 退避时间随机浮动±20%
+//           codes: [UNAVAILABLE]       # 可重试的状态码，支持名称或数字
+//       methods:
+//         /user.User/GetUser:          # 幂等的读请求可使用hedging，与retry不能同时配置
+//           timeout: 500ms
+//           hedging:
+//             max_attempts: 2
+//             delay: 100ms             # 超过delay未返回时再发起一次
+//             codes: [UNAVAILABLE]     # 返回这些状态码时不等待delay立即发起下一次
+// 熔断配置见BreakerPolicy
+type ClientPolicy struct {
+	Default CallPolicy
+	Methods map[string]CallPolicy
+}
+
+type CallPolicy struct {
+	Timeout time.Duration
+	Retry   *RetryPolicy
+	Hedging *HedgingPolicy
+	Breaker *BreakerPolicy
+}
+
+type RetryPolicy struct {
+	MaxAttempts    int           `default:"3"`
+	InitialBackoff time.Duration `default:"50ms"`
+	MaxBackoff     time.Duration `default:"1s"`
+	Multiplier     float64       `default:"2"`
+	Jitter         float64       `default:"0.2"`
+	Codes          []string      `default:"[UNAVAILABLE]"`
+
+	codes map[codes.Code]bool
+}
+
+type HedgingPolicy struct {
+	MaxAttempts int           `default:"2"`
+	Delay       time.Duration `default:"100ms"`
+	Codes       []string      `default:"[UNAVAILABLE]"`
+
+	codes map[codes.Code]bool
+}
+
+// LoadClientPolicy 读取grpc_client.<service>，未配置时返回空策略
+func LoadClientPolicy(service string) (*ClientPolicy, error) {
+	var policy ClientPolicy
+	if err := config.Unmarshal(&policy, clientPolicyPath, service); err != nil {
+		return nil, err
+	}
+
+	if err := policy.Default.init(fmt.Sprintf("%s.%s.default", clientPolicyPath, service)); err != nil {
+		return nil, err
+	}
+	for method, p := range policy.Methods {
+		if err := p.init(fmt.Sprintf("%s.%s.methods.%s", clientPolicyPath, service, method)); err != nil {
+			return nil, err
+		}
+		policy.Methods[method] = p
+	}
+	return &policy, nil
+}
+
+func (p *CallPolicy) init(path string) error {
+	if p.Retry != nil && p.Hedging != nil {
+		return fmt.Errorf("%s: retry and hedging are mutually exclusive", path)
+	}
+
+	var err error
+	if p.Retry != nil {
+		if p.Retry.MaxAttempts < 1 {
+			return fmt.Errorf("%s.retry.max_attempts must be >= 1", path)
+		}
+		if p.Retry.codes, err = parseCodes(p.Retry.Codes); err != nil {
+			return fmt.Errorf("%s.retry.codes: %v", path, err)
+		}
+	}
+	if p.Breaker != nil {
+		if err := p.Breaker.validate(); err != nil {
+			return fmt.Errorf("%s.breaker.%v", path, err)
+		}
+	}
+	if p.Hedging != nil {
+		if p.Hedging.MaxAttempts < 1 {
+			return fmt.Errorf("%s.hedging.max_attempts must be >= 1", path)
+		}
+		if p.Hedging.codes, err = parseCodes(p.Hedging.Codes); err != nil {
+			return fmt.Errorf("%s.hedging.codes: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// parseCodes 支持UNAVAILABLE、Unavailable等名称及errcode中的数字
+func parseCodes(names []string) (map[codes.Code]bool, error) {
+	m := map[codes.Code]bool{}
+	for _, name := range names {
+		if n, err := strconv.ParseUint(name, 10, 32); err == nil {
+			m[codes.Code(n)] = true
+			continue
+		}
+
+		var c codes.Code
+		if err := c.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(name)))); err != nil {
+			return nil, fmt.Errorf("unknown code %q", name)
+		}
+		m[c] = true
+	}
+	return m, nil
+}
+
+// Method 返回方法对应的策略
+func (p *ClientPolicy) Method(fullMethod string) *CallPolicy {
+	if cp, ok := p.Methods[fullMethod]; ok {
+		return &cp
+	}
+	if subs := regFullMethod.FindStringSubmatch(fullMethod); len(subs) == 3 {
+		if cp, ok := p.Methods[subs[1]]; ok {
+			return &cp
+		}
+	}
+	return &p.Default
+}
+
+// watchClientPolicy 加载grpc_client.<service>并在配置变化后更新，新配置有误时保留原策略
+func watchClientPolicy(service string) *atomic.Value {
+	var current atomic.Value
+	policy, err := LoadClientPolicy(service)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"service": service,
+			"err":     err.Error(),
+		}).Error("Load client policy failed")
+		policy = &ClientPolicy{}
+	}
+	current.Store(policy)
+
+	config.OnChange(clientPolicyPath+"."+service, func(old, new *config.Value) {
+		policy, err := LoadClientPolicy(service)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"service": service,
+				"err":     err.Error(),
+			}).Error("Reload client policy failed")
+			return
+		}
+		current.Store(policy)
+	})
+	return &current
+}
+
+// PolicyUnaryClientMW 按grpc_client.<service>的配置设置默认deadline、重试和hedging，配置变化后自动生效
+func PolicyUnaryClientMW(service string) grpc.UnaryClientInterceptor {
+	policies := watchClientPolicy(service)
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		cp := policies.Load().(*ClientPolicy).Method(method)
+		if _, ok := ctx.Deadline(); !ok && cp.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, cp.Timeout)
+			defer cancel()
+		}
+
+		switch {
+		case cp.Hedging != nil && cp.Hedging.MaxAttempts > 1:
+			if _, ok := reply.(proto.Message); ok {
+				return invokeHedging(ctx, cp.Hedging, method, req, reply, cc, invoker, opts...)
+			}
+			return invokeAttempt(ctx, method, req, reply, cc, invoker, opts...)
+		case cp.Retry != nil && cp.Retry.MaxAttempts > 1:
+			return invokeRetry(ctx, cp.Retry, method, req, reply, cc, invoker, opts...)
+		default:
+			return invokeAttempt(ctx, method, req, reply, cc, invoker, opts...)
+		}
+	}
+}
+
+// invokeAttempt 发起一次调用并按状态码计数
+func invokeAttempt(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	metrics.NewCounter("client_attempt", "method", method, "code", strconv.Itoa(int(status.Code(err)))).Inc(1)
+	return err
+}
+
+func invokeRetry(ctx context.Context, rp *RetryPolicy, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = invokeAttempt(ctx, method, req, reply, cc, invoker, opts...)
+		if err == nil || !rp.codes[status.Code(err)] || attempt >= rp.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		timer := time.NewTimer(rp.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		metrics.NewCounter("client_retry", "method", method, "kind", "retry").Inc(1)
+		GetLogger(ctx).WithFields(logrus.Fields{
+			"rpc":     method,
+			"attempt": attempt + 1,
+			"err":     err.Error(),
+		}).Warn("Retry rpc")
+	}
+}
+
+// backoff 第attempt次失败后的等待时间
+func (rp *RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(rp.InitialBackoff) * math.Pow(rp.Multiplier, float64(attempt-1))
+	if max := float64(rp.MaxBackoff); max > 0 && d > max {
+		d = max
+	}
+	if rp.Jitter > 0 {
+		d *= 1 + rp.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(d)
+}
+
+type hedgeResult struct {
+	reply interface{}
+	err   error
+}
+
+// invokeHedging 每隔delay或上一次返回可重试的状态码时并发发起下一次，
+// 采用第一个成功或不可重试的结果并取消其余调用
+func invokeHedging(ctx context.Context, hp *HedgingPolicy, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// 容量足够所有调用写入，返回后未结束的调用不会阻塞
+	results := make(chan hedgeResult, hp.MaxAttempts)
+	replyType := reflect.TypeOf(reply).Elem()
+	launch := func() {
+		r := reflect.New(replyType).Interface()
+		go func() {
+			err := invokeAttempt(ctx, method, req, r, cc, invoker, opts...)
+			results <- hedgeResult{reply: r, err: err}
+		}()
+	}
+
+	launch()
+	launched, finished := 1, 0
+	var lastErr error
+	for {
+		var delay <-chan time.Time
+		var timer *time.Timer
+		if launched < hp.MaxAttempts {
+			timer = time.NewTimer(hp.Delay)
+			delay = timer.C
+		}
+
+		select {
+		case res := <-results:
+			if timer != nil {
+				timer.Stop()
+			}
+			finished++
+			if res.err == nil || !hp.codes[status.Code(res.err)] {
+				cancel()
+				if res.err == nil {
+					reply.(proto.Message).Reset()
+					proto.Merge(reply.(proto.Message), res.reply.(proto.Message))
+				}
+				return res.err
+			}
+
+			lastErr = res.err
+			if launched < hp.MaxAttempts && ctx.Err() == nil {
+				metrics.NewCounter("client_retry", "method", method, "kind", "hedge").Inc(1)
+				launch()
+				launched++
+			} else if finished == launched {
+				return lastErr
+			}
+		case <-delay:
+			metrics.NewCounter("client_retry", "method", method, "kind", "hedge").Inc(1)
+			launch()
+			launched++
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			if lastErr != nil {
+				return lastErr
+			}
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
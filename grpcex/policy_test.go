@@ -0,0 +1,258 @@
+package grpcex
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rickone/athena/config"
+	"github.com/rickone/athena/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const checkMethod = "/grpc.health.v1.Health/Check"
+
+// flakyHealth 第n次(从1开始)调用的结果由check决定
+type flakyHealth struct {
+	grpc_health_v1.UnimplementedHealthServer
+	calls int32
+	check func(ctx context.Context, n int32) error
+}
+
+func (h *flakyHealth) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	n := atomic.AddInt32(&h.calls, 1)
+	if err := h.check(ctx, n); err != nil {
+		return nil, err
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func startFlakyServer(t *testing.T, service string, check func(ctx context.Context, n int32) error) (grpc_health_v1.HealthClient, *flakyHealth) {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	health := &flakyHealth{check: check}
+	s := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(s, health)
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithChainUnaryInterceptor(PolicyUnaryClientMW(service)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return grpc_health_v1.NewHealthClient(conn), health
+}
+
+func setClientPolicy(t *testing.T, service string, policy map[interface{}]interface{}) {
+	t.Helper()
+
+	if err := config.UpdateValue("grpc_client", map[interface{}]interface{}{service: policy}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPolicyRetry(t *testing.T) {
+	setClientPolicy(t, "retry", map[interface{}]interface{}{
+		"default": map[interface{}]interface{}{
+			"retry": map[interface{}]interface{}{"max_attempts": 3, "initial_backoff": "1ms"},
+		},
+	})
+
+	client, health := startFlakyServer(t, "retry", func(ctx context.Context, n int32) error {
+		switch n {
+		case 1, 2:
+			return status.Error(codes.Unavailable, "restarting")
+		case 3:
+			return nil
+		default:
+			return status.Error(codes.NotFound, "not found")
+		}
+	})
+
+	retries := metrics.NewCounter("client_retry", "method", checkMethod, "kind", "retry")
+	before := retries.Count()
+	if _, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if health.calls != 3 || retries.Count()-before != 2 {
+		t.Fatalf("unexpected calls=%d retries=%d", health.calls, retries.Count()-before)
+	}
+
+	// 不可重试的状态码直接返回
+	_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if status.Code(err) != codes.NotFound || health.calls != 4 {
+		t.Fatalf("unexpected err=%v calls=%d", err, health.calls)
+	}
+}
+
+func TestPolicyRetryExhausted(t *testing.T) {
+	setClientPolicy(t, "exhausted", map[interface{}]interface{}{
+		"default": map[interface{}]interface{}{
+			"retry": map[interface{}]interface{}{"max_attempts": 2, "initial_backoff": "1ms", "codes": []interface{}{"unavailable", "14"}},
+		},
+	})
+
+	client, health := startFlakyServer(t, "exhausted", func(ctx context.Context, n int32) error {
+		return status.Error(codes.Unavailable, "down")
+	})
+	_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if status.Code(err) != codes.Unavailable || health.calls != 2 {
+		t.Fatalf("unexpected err=%v calls=%d", err, health.calls)
+	}
+}
+
+func TestPolicyTimeout(t *testing.T) {
+	setClientPolicy(t, "timeout", map[interface{}]interface{}{
+		"default": map[interface{}]interface{}{"timeout": "50ms"},
+	})
+
+	client, _ := startFlakyServer(t, "timeout", func(ctx context.Context, n int32) error {
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+		return nil
+	})
+
+	start := time.Now()
+	_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if status.Code(err) != codes.DeadlineExceeded || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("unexpected err=%v after %v", err, time.Since(start))
+	}
+
+	// 调用方设置的deadline优先
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if status.Code(err) != codes.DeadlineExceeded || time.Since(start) < 150*time.Millisecond {
+		t.Fatalf("unexpected err=%v after %v", err, time.Since(start))
+	}
+}
+
+func TestPolicyHedging(t *testing.T) {
+	setClientPolicy(t, "hedging", map[interface{}]interface{}{
+		"methods": map[interface{}]interface{}{
+			"grpc.health.v1.Health": map[interface{}]interface{}{
+				"hedging": map[interface{}]interface{}{"max_attempts": 3, "delay": "20ms"},
+			},
+		},
+	})
+
+	cancelled := make(chan struct{})
+	client, health := startFlakyServer(t, "hedging", func(ctx context.Context, n int32) error {
+		if n == 1 {
+			// 第一次调用很慢，被hedging的结果取代后应被取消
+			select {
+			case <-ctx.Done():
+				close(cancelled)
+			case <-time.After(5 * time.Second):
+			}
+		}
+		return nil
+	})
+
+	start := time.Now()
+	resp, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING || time.Since(start) > time.Second {
+		t.Fatalf("unexpected resp=%v after %v", resp, time.Since(start))
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("slow attempt not cancelled")
+	}
+	if calls := atomic.LoadInt32(&health.calls); calls != 2 {
+		t.Fatalf("unexpected calls: %d", calls)
+	}
+}
+
+func TestPolicyHedgingFailFast(t *testing.T) {
+	setClientPolicy(t, "hedging_fail", map[interface{}]interface{}{
+		"default": map[interface{}]interface{}{
+			"hedging": map[interface{}]interface{}{"max_attempts": 3, "delay": "1s"},
+		},
+	})
+
+	// 可重试的状态码立即发起下一次，不等待delay
+	client, health := startFlakyServer(t, "hedging_fail", func(ctx context.Context, n int32) error {
+		return status.Error(codes.Unavailable, "down")
+	})
+	start := time.Now()
+	_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if status.Code(err) != codes.Unavailable || atomic.LoadInt32(&health.calls) != 3 || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("unexpected err=%v calls=%d after %v", err, health.calls, time.Since(start))
+	}
+}
+
+func TestLoadClientPolicy(t *testing.T) {
+	setClientPolicy(t, "invalid", map[interface{}]interface{}{
+		"default": map[interface{}]interface{}{
+			"retry":   map[interface{}]interface{}{},
+			"hedging": map[interface{}]interface{}{},
+		},
+	})
+	if _, err := LoadClientPolicy("invalid"); err == nil {
+		t.Fatal("expected error for retry with hedging")
+	}
+
+	setClientPolicy(t, "codes", map[interface{}]interface{}{
+		"default": map[interface{}]interface{}{
+			"retry": map[interface{}]interface{}{"codes": []interface{}{"NOPE"}},
+		},
+	})
+	if _, err := LoadClientPolicy("codes"); err == nil {
+		t.Fatal("expected error for unknown code")
+	}
+
+	policy, err := LoadClientPolicy("missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp := policy.Method(checkMethod); cp.Retry != nil || cp.Hedging != nil || cp.Timeout != 0 {
+		t.Fatalf("unexpected policy: %+v", cp)
+	}
+}
+
+func TestPolicyReload(t *testing.T) {
+	setClientPolicy(t, "reload", map[interface{}]interface{}{})
+
+	client, health := startFlakyServer(t, "reload", func(ctx context.Context, n int32) error {
+		if n <= 2 {
+			return status.Error(codes.Unavailable, "flaky")
+		}
+		return nil
+	})
+	if _, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable without retry, got %v", err)
+	}
+
+	setClientPolicy(t, "reload", map[interface{}]interface{}{
+		"default": map[interface{}]interface{}{
+			"retry": map[interface{}]interface{}{"initial_backoff": "1ms"},
+		},
+	})
+	if _, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Fatalf("expected retry after reload, got %v", err)
+	}
+	if calls := atomic.LoadInt32(&health.calls); calls != 3 {
+		t.Fatalf("unexpected calls: %d", calls)
+	}
+}
@@ -0,0 +1,119 @@
+package grpcex
+
+import (
+	"context"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var proxyDesc = &grpc.StreamDesc{
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+// ProxyDirector 返回转发fullMethod使用的连接
+type ProxyDirector func(ctx context.Context, fullMethod string) (grpc.ClientConnInterface, error)
+
+// ProxyByName 按服务名经服务发现转发，target返回方法对应的服务名，为空时返回Unimplemented
+func ProxyByName(target func(fullMethod string) string) ProxyDirector {
+	return func(ctx context.Context, fullMethod string) (grpc.ClientConnInterface, error) {
+		name := target(fullMethod)
+		if name == "" {
+			return nil, status.Errorf(codes.Unimplemented, "unknown method %s", fullMethod)
+		}
+		return ClientConn(name)
+	}
+}
+
+// WithProxy 未注册的方法原样转发；grpc.CustomCodec作用于整个Server，本服务注册的方法
+// 也使用RawCodec，其对非RawBuf的消息按proto编解码，行为不变
+func WithProxy(director ProxyDirector) ServiceOption {
+	return WithServerOption(grpc.CustomCodec(RawCodec{}), grpc.UnknownServiceHandler(ProxyHandler(director)))
+}
+
+// ProxyHandler 不解码消息，转发unary和流式调用，收到的metadata和deadline随请求传递，
+// 后端的header、trailer和状态原样返回；需配合RawCodec，见WithProxy
+func ProxyHandler(director ProxyDirector) grpc.StreamHandler {
+	return func(srv interface{}, ss grpc.ServerStream) error {
+		fullMethod, ok := grpc.MethodFromServerStream(ss)
+		if !ok {
+			return status.Error(codes.Internal, "method not found in stream")
+		}
+
+		conn, err := director(ss.Context(), fullMethod)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(ss.Context())
+		defer cancel()
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			ctx = metadata.NewOutgoingContext(ctx, md.Copy())
+		}
+
+		cs, err := conn.NewStream(ctx, proxyDesc, fullMethod, grpc.ForceCodec(RawCodec{}))
+		if err != nil {
+			return err
+		}
+
+		sent := make(chan error, 1)
+		go func() {
+			sent <- forwardToBackend(ss, cs)
+		}()
+		recvErr := forwardToClient(cs, ss)
+		ss.SetTrailer(cs.Trailer())
+		if recvErr != io.EOF {
+			return recvErr
+		}
+
+		// 后端正常结束时，等待转发客户端消息结束，其失败的错误需要返回
+		select {
+		case err := <-sent:
+			if err != nil && err != io.EOF {
+				return err
+			}
+		case <-ss.Context().Done():
+		}
+		return nil
+	}
+}
+
+// forwardToBackend 客户端发送结束后关闭发送端
+func forwardToBackend(ss grpc.ServerStream, cs grpc.ClientStream) error {
+	var msg RawBuf
+	for {
+		if err := ss.RecvMsg(&msg); err != nil {
+			if err == io.EOF {
+				return cs.CloseSend()
+			}
+			return err
+		}
+		if err := cs.SendMsg(&msg); err != nil {
+			return err
+		}
+	}
+}
+
+// forwardToClient 返回io.EOF表示后端正常结束
+func forwardToClient(cs grpc.ClientStream, ss grpc.ServerStream) error {
+	// 后端直接返回错误时Header失败，错误由RecvMsg返回
+	if md, err := cs.Header(); err == nil {
+		if err := ss.SendHeader(md); err != nil {
+			return err
+		}
+	}
+
+	var msg RawBuf
+	for {
+		if err := cs.RecvMsg(&msg); err != nil {
+			return err
+		}
+		if err := ss.SendMsg(&msg); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package grpcex
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// backendHealth 回显metadata，用于验证代理的透传
+type backendHealth struct {
+	grpc_health_v1.UnimplementedHealthServer
+	cancelled chan struct{}
+}
+
+func (h *backendHealth) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	switch req.Service {
+	case "missing":
+		return nil, status.Error(codes.NotFound, "service missing")
+	case "slow":
+		<-ctx.Done()
+		close(h.cancelled)
+		return nil, ctx.Err()
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if _, ok := ctx.Deadline(); !ok || len(md.Get("tenant")) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "deadline or tenant missing")
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("backend", "b1"))
+	grpc.SetTrailer(ctx, metadata.Pairs("tenant", md.Get("tenant")[0]))
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func (h *backendHealth) Watch(req *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	for _, st := range []grpc_health_v1.HealthCheckResponse_ServingStatus{
+		grpc_health_v1.HealthCheckResponse_SERVING,
+		grpc_health_v1.HealthCheckResponse_NOT_SERVING,
+	} {
+		if err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: st}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bufDial(t *testing.T, listener *bufconn.Listener) *grpc.ClientConn {
+	t.Helper()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestProxyHandler(t *testing.T) {
+	backend := &backendHealth{cancelled: make(chan struct{})}
+	backendListener := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(s, backend)
+	go s.Serve(backendListener)
+	defer s.Stop()
+	backendConn := bufDial(t, backendListener)
+
+	proxyListener := bufconn.Listen(1 << 20)
+	proxy := NewGrpcServiceWithOptions("", WithProxy(func(ctx context.Context, fullMethod string) (grpc.ClientConnInterface, error) {
+		return backendConn, nil
+	}))
+	go proxy.Server.Serve(proxyListener)
+	defer proxy.Server.Stop()
+	client := grpc_health_v1.NewHealthClient(bufDial(t, proxyListener))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// metadata、deadline透传，header和trailer原样返回
+	var header, trailer metadata.MD
+	resp, err := client.Check(metadata.AppendToOutgoingContext(ctx, "tenant", "t1"),
+		&grpc_health_v1.HealthCheckRequest{}, grpc.Header(&header), grpc.Trailer(&trailer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("unexpected status: %v", resp.Status)
+	}
+	if len(header.Get("backend")) == 0 || len(trailer.Get("tenant")) == 0 || trailer.Get("tenant")[0] != "t1" {
+		t.Fatalf("unexpected header %v trailer %v", header, trailer)
+	}
+
+	_, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "missing"})
+	if st := status.Convert(err); st.Code() != codes.NotFound || st.Message() != "service missing" {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	watch, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []grpc_health_v1.HealthCheckResponse_ServingStatus
+	for {
+		resp, err := watch.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, resp.Status)
+	}
+	if len(got) != 2 || got[1] != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("unexpected stream: %v", got)
+	}
+
+	// 客户端超时后后端的调用随之取消
+	slowCtx, slowCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer slowCancel()
+	_, err = client.Check(slowCtx, &grpc_health_v1.HealthCheckRequest{Service: "slow"})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	select {
+	case <-backend.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("backend not cancelled")
+	}
+}
+
+func TestRawCodec(t *testing.T) {
+	data, err := RawCodec{}.Marshal(&grpc_health_v1.HealthCheckRequest{Service: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rb RawBuf
+	if err := (RawCodec{}).Unmarshal(data, &rb); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rb, data) {
+		t.Fatalf("unexpected raw buf: %s", rb)
+	}
+
+	var req grpc_health_v1.HealthCheckRequest
+	raw, _ := RawCodec{}.Marshal(&rb)
+	if err := (RawCodec{}).Unmarshal(raw, &req); err != nil || req.Service != "user" {
+		t.Fatalf("unexpected req: %v, %v", &req, err)
+	}
+}
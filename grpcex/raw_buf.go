@@ -1,24 +1,57 @@
 package grpcex
 
-import "fmt"
+import (
+	"fmt"
 
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/proto"
+)
+
+// RawBuf 未解码的消息，配合RawCodec原样收发，接收时需传入*RawBuf
 type RawBuf []byte
 
-func (rb RawBuf) Reset() {
+func (rb *RawBuf) Reset() {
+	*rb = nil
 }
 
 func (rb RawBuf) String() string {
-	return fmt.Sprintf("%x", rb)
+	return fmt.Sprintf("%x", []byte(rb))
 }
 
 func (rb RawBuf) ProtoMessage() {
 }
 
-func (rb RawBuf) Unmarshal(data []byte) error {
-	rb = data
+func (rb *RawBuf) Unmarshal(data []byte) error {
+	*rb = append((*rb)[:0], data...)
 	return nil
 }
 
 func (rb RawBuf) Marshal() ([]byte, error) {
 	return rb, nil
 }
+
+// RawCodec *RawBuf不经proto编解码，其他消息使用proto编码，名称也为proto，
+// 服务端通过grpc.CustomCodec、客户端通过grpc.ForceCodec使用
+type RawCodec struct{}
+
+func (RawCodec) Marshal(v interface{}) ([]byte, error) {
+	if rb, ok := v.(*RawBuf); ok {
+		return *rb, nil
+	}
+	return encoding.GetCodec(proto.Name).Marshal(v)
+}
+
+func (RawCodec) Unmarshal(data []byte, v interface{}) error {
+	if rb, ok := v.(*RawBuf); ok {
+		return rb.Unmarshal(data)
+	}
+	return encoding.GetCodec(proto.Name).Unmarshal(data, v)
+}
+
+func (RawCodec) Name() string {
+	return proto.Name
+}
+
+func (RawCodec) String() string {
+	return proto.Name
+}
@@ -0,0 +1,173 @@
+package grpcex
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rickone/athena/redis"
+	"google.golang.org/grpc"
+)
+
+// rerouteIdleTimeout 地址超过该时间没有调用时关闭连接
+const rerouteIdleTimeout = 5 * time.Minute
+
+type rerouteConn struct {
+	conn *grpc.ClientConn
+	refs int
+	used time.Time
+}
+
+// rerouteCache 按地址复用reroute的连接，空闲超时且没有进行中的调用时关闭；
+// 有连接时每idle定时检查一次，reroute的调用停止后连接也会关闭
+type rerouteCache struct {
+	mu    sync.Mutex
+	conns map[string]*rerouteConn
+	idle  time.Duration
+	swept time.Time
+	timer *time.Timer
+
+	now  func() time.Time
+	dial func(addr string) (*grpc.ClientConn, error)
+}
+
+var reroutes = newRerouteCache(rerouteIdleTimeout, func(addr string) (*grpc.ClientConn, error) {
+	return Dial(addr)
+})
+
+func newRerouteCache(idle time.Duration, dial func(addr string) (*grpc.ClientConn, error)) *rerouteCache {
+	return &rerouteCache{
+		conns: map[string]*rerouteConn{},
+		idle:  idle,
+		now:   time.Now,
+		dial:  dial,
+	}
+}
+
+// acquire 调用结束后需调用release
+func (c *rerouteCache) acquire(addr string) (*grpc.ClientConn, func(), error) {
+	c.mu.Lock()
+	now := c.now()
+	expired := c.sweep(now)
+
+	rc, ok := c.conns[addr]
+	if !ok {
+		conn, err := c.dial(addr)
+		if err != nil {
+			c.mu.Unlock()
+			closeConns(expired)
+			return nil, nil, err
+		}
+		rc = &rerouteConn{conn: conn}
+		c.conns[addr] = rc
+		c.scheduleSweep()
+	}
+	rc.refs++
+	rc.used = now
+	c.mu.Unlock()
+	closeConns(expired)
+
+	var once sync.Once
+	return rc.conn, func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			rc.refs--
+			rc.used = c.now()
+		})
+	}, nil
+}
+
+// sweep 每idle/2检查一次，返回过期的连接，由调用方在锁外关闭
+func (c *rerouteCache) sweep(now time.Time) []*grpc.ClientConn {
+	if now.Sub(c.swept) < c.idle/2 {
+		return nil
+	}
+	c.swept = now
+
+	var expired []*grpc.ClientConn
+	for addr, rc := range c.conns {
+		if rc.refs == 0 && now.Sub(rc.used) >= c.idle {
+			expired = append(expired, rc.conn)
+			delete(c.conns, addr)
+		}
+	}
+	return expired
+}
+
+// scheduleSweep 需持有锁，没有连接时停止定时检查
+func (c *rerouteCache) scheduleSweep() {
+	if c.timer != nil || len(c.conns) == 0 {
+		return
+	}
+	c.timer = time.AfterFunc(c.idle, func() {
+		c.mu.Lock()
+		c.timer = nil
+		c.swept = time.Time{}
+		expired := c.sweep(c.now())
+		c.scheduleSweep()
+		c.mu.Unlock()
+		closeConns(expired)
+	})
+}
+
+func (c *rerouteCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.conns)
+}
+
+func closeConns(conns []*grpc.ClientConn) {
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// rerouteTag Reroute为空时兼容request_id的x#tag格式
+func rerouteTag(meta *RequestMeta) string {
+	if meta.Reroute != "" {
+		return meta.Reroute
+	}
+	if ss := strings.Split(meta.RequestId, "#"); len(ss) == 2 {
+		return ss[1]
+	}
+	return ""
+}
+
+// getRerouteClientConn 没有reroute标记或规则时返回nil
+func getRerouteClientConn(ctx context.Context, target string) (*grpc.ClientConn, func(), error) {
+	tag := rerouteTag(Meta(ctx))
+	if tag == "" {
+		return nil, nil, nil
+	}
+
+	addr, err := redis.GetReroute(tag, target)
+	if err != nil || addr == "" {
+		return nil, nil, err
+	}
+	return reroutes.acquire(addr)
+}
+
+// rerouteClientStream 流结束时释放连接：收到错误、非服务端流收到响应或流的context结束
+type rerouteClientStream struct {
+	grpc.ClientStream
+	desc    *grpc.StreamDesc
+	release func()
+}
+
+func newRerouteClientStream(cs grpc.ClientStream, desc *grpc.StreamDesc, release func()) *rerouteClientStream {
+	go func() {
+		<-cs.Context().Done()
+		release()
+	}()
+	return &rerouteClientStream{ClientStream: cs, desc: desc, release: release}
+}
+
+func (s *rerouteClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.desc.ServerStreams {
+		s.release()
+	}
+	return err
+}
@@ -0,0 +1,262 @@
+package grpcex
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/rickone/athena/redis"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestRerouteCache(t *testing.T) {
+	var dials int32
+	cache := newRerouteCache(time.Minute, func(addr string) (*grpc.ClientConn, error) {
+		atomic.AddInt32(&dials, 1)
+		return grpc.Dial(addr, grpc.WithInsecure())
+	})
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	conn1, release1, err := cache.acquire("127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn2, release2, _ := cache.acquire("127.0.0.1:1")
+	if conn1 != conn2 || dials != 1 {
+		t.Fatalf("connection not reused, dials=%d", dials)
+	}
+	release1()
+	release1()
+
+	// 仍有调用时不关闭
+	now = now.Add(2 * time.Minute)
+	_, release3, _ := cache.acquire("127.0.0.1:2")
+	release3()
+	if cache.len() != 2 {
+		t.Fatalf("in-use connection expired, len=%d", cache.len())
+	}
+
+	release2()
+	now = now.Add(2 * time.Minute)
+	_, release4, _ := cache.acquire("127.0.0.1:3")
+	defer release4()
+	if cache.len() != 1 {
+		t.Fatalf("idle connections not expired, len=%d", cache.len())
+	}
+	if conn1.GetState().String() != "SHUTDOWN" {
+		t.Fatalf("expired connection not closed: %v", conn1.GetState())
+	}
+}
+
+func TestRerouteUnaryClientMW(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+	redis.SetDB("reroute", redis.NewRedisClient(mr.Addr(), "", "0"))
+	defer redis.SetDB("reroute", nil)
+
+	saved := reroutes
+	reroutes = newRerouteCache(time.Minute, func(addr string) (*grpc.ClientConn, error) {
+		return grpc.Dial(addr, grpc.WithInsecure())
+	})
+	defer func() { reroutes = saved }()
+
+	// 开发者本地的服务
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := &flakyHealth{check: func(ctx context.Context, n int32) error { return nil }}
+	s := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(s, dev)
+	go s.Serve(listener)
+	defer s.Stop()
+
+	prodClient, prod := startFlakyServer(t, "user", func(ctx context.Context, n int32) error { return nil })
+
+	mw := RerouteUnaryClientMW("user")
+	call := func(meta *RequestMeta) {
+		t.Helper()
+		ctx := NewContext(context.Background(), meta)
+		err := mw(ctx, checkMethod, &grpc_health_v1.HealthCheckRequest{}, &grpc_health_v1.HealthCheckResponse{}, nil,
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				if cc == nil {
+					_, err := prodClient.Check(ctx, req.(*grpc_health_v1.HealthCheckRequest))
+					return err
+				}
+				return cc.Invoke(ctx, method, req, reply, opts...)
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := redis.SetReroute("alice", "user", listener.Addr().String(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	call(&RequestMeta{Reroute: "alice"})
+	call(&RequestMeta{RequestId: "req-1#alice"})
+	call(&RequestMeta{Reroute: "bob"})
+	if atomic.LoadInt32(&dev.calls) != 2 || atomic.LoadInt32(&prod.calls) != 1 {
+		t.Fatalf("unexpected calls: dev=%d prod=%d", dev.calls, prod.calls)
+	}
+
+	rules, err := redis.ListReroute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Targets["user"] != listener.Addr().String() || rules[0].TTL <= 0 {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+
+	if err := redis.ExpireReroute("alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	call(&RequestMeta{Reroute: "alice"})
+	if atomic.LoadInt32(&dev.calls) != 2 || atomic.LoadInt32(&prod.calls) != 2 {
+		t.Fatalf("rule not expired: dev=%d prod=%d", dev.calls, prod.calls)
+	}
+}
+
+// streamHealth Check直接返回SERVING，Watch同backendHealth
+type streamHealth struct {
+	backendHealth
+}
+
+func (h *streamHealth) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func (c *rerouteCache) refs() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for _, rc := range c.conns {
+		n += rc.refs
+	}
+	return n
+}
+
+func TestRerouteStreamClientMW(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+	redis.SetDB("reroute", redis.NewRedisClient(mr.Addr(), "", "0"))
+	defer redis.SetDB("reroute", nil)
+
+	saved := reroutes
+	reroutes = newRerouteCache(time.Minute, func(addr string) (*grpc.ClientConn, error) {
+		return grpc.Dial(addr, grpc.WithInsecure())
+	})
+	defer func() { reroutes = saved }()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(s, &streamHealth{})
+	go s.Serve(listener)
+	defer s.Stop()
+
+	if err := redis.SetReroute("alice", "user", listener.Addr().String(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	mw := RerouteStreamClientMW("user")
+	open := func(ctx context.Context, desc *grpc.StreamDesc, method string) grpc.ClientStream {
+		t.Helper()
+		ctx = NewContext(ctx, &RequestMeta{Reroute: "alice"})
+		cs, err := mw(ctx, desc, nil, method,
+			func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+				return cc.NewStream(ctx, desc, method, opts...)
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cs.SendMsg(&grpc_health_v1.HealthCheckRequest{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := cs.CloseSend(); err != nil {
+			t.Fatal(err)
+		}
+		if reroutes.refs() != 1 {
+			t.Fatalf("connection not acquired, refs=%d", reroutes.refs())
+		}
+		return cs
+	}
+
+	// 服务端流读到结束时释放
+	cs := open(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/grpc.health.v1.Health/Watch")
+	for {
+		if err := cs.RecvMsg(&grpc_health_v1.HealthCheckResponse{}); err != nil {
+			break
+		}
+	}
+	if reroutes.refs() != 0 {
+		t.Fatalf("server stream not released, refs=%d", reroutes.refs())
+	}
+
+	// 非服务端流收到响应后即释放，调用方不再读到io.EOF
+	cs = open(context.Background(), &grpc.StreamDesc{}, checkMethod)
+	if err := cs.RecvMsg(&grpc_health_v1.HealthCheckResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if reroutes.refs() != 0 {
+		t.Fatalf("unary response stream not released, refs=%d", reroutes.refs())
+	}
+
+	// 调用方不读取直接取消时，流的context结束后释放
+	ctx, cancel := context.WithCancel(context.Background())
+	open(ctx, &grpc.StreamDesc{ServerStreams: true}, "/grpc.health.v1.Health/Watch")
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for reroutes.refs() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("abandoned stream not released, refs=%d", reroutes.refs())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRerouteCacheIdleSweep(t *testing.T) {
+	cache := newRerouteCache(50*time.Millisecond, func(addr string) (*grpc.ClientConn, error) {
+		return grpc.Dial(addr, grpc.WithInsecure())
+	})
+
+	conn, release, err := cache.acquire("127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 调用进行中时不关闭
+	time.Sleep(150 * time.Millisecond)
+	if cache.len() != 1 {
+		t.Fatal("in-use connection closed")
+	}
+
+	// 之后没有新的调用，由定时检查关闭
+	release()
+	deadline := time.Now().Add(time.Second)
+	for cache.len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("idle connection not closed without further calls")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for conn.GetState().String() != "SHUTDOWN" {
+		if time.Now().After(deadline) {
+			t.Fatalf("idle connection not shut down: %v", conn.GetState())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,55 +1,89 @@
 package grpcex
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	_ "net/http/pprof" // http pprof
 	"os"
+	"sync"
 	"time"
 
-	"github.com/hashicorp/consul/api"
 	"github.com/rickone/athena/common"
 	"github.com/rickone/athena/config"
-	"github.com/rickone/athena/consul"
+	"github.com/rickone/athena/discovery"
+	"github.com/rickone/athena/health"
+	"github.com/rickone/athena/lifecycle"
 	"github.com/rickone/athena/metrics"
+	"github.com/rickone/athena/redis"
+	"github.com/rickone/athena/trace"
+	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health/grpc_health_v1"
 )
 
 const (
-	rpcTimeout = 10 * time.Second
+	rpcTimeout      = 10 * time.Second
+	configAdminPath = "/debug/config"
+	// rerouteAdminPath service.reroute_admin开启时挂载，见redis.RerouteAdminHandler
+	rerouteAdminPath = "/debug/reroute"
 )
 
 type GrpcService struct {
 	*grpc.Server
 	name     string
-	register *consul.Register
+	registry discovery.Registry
+	instance *discovery.Instance
+	probe    *selfProbe
 	listener net.Listener
 	address  string
 }
 
+// NewGrpcService serviceName为空时使用注册的第一个gRPC服务名；tls段开启时使用TLS，见TLSConfig；
+// 消息大小、keepalive和方法超时、访问日志见ServerPolicy，调用方身份验证见AuthPolicy
 func NewGrpcService(serviceName ...string) *GrpcService {
-	serv := &GrpcService{
-		Server: grpc.NewServer(
-			grpc.ChainUnaryInterceptor(
-				CtxUnaryServerMW,
-				AccessLogMW,
-				RecoveryMW,
-				MetricsUnaryMW,
-				ErrorMapUnaryMW,
-				TimeoutUnaryMW(rpcTimeout),
-			),
-		),
-	}
+	var name string
 	if len(serviceName) > 0 {
-		serv.name = serviceName[0]
+		name = serviceName[0]
+	}
+	return NewGrpcServiceWithOptions(name)
+}
+
+// NewGrpcServiceWithOptions 同NewGrpcService，opts可替换默认拦截器或追加grpc.ServerOption
+func NewGrpcServiceWithOptions(name string, opts ...ServiceOption) *GrpcService {
+	policies, err := defaultServerPolicy()
+	common.AssertError(err)
+
+	serverOpts, err := serverCredOption()
+	common.AssertError(err)
+	serverOpts = append(serverOpts, policies.Load().(*ServerPolicy).serverOptions()...)
+
+	auth, err := defaultAuth()
+	common.AssertError(err)
+
+	o := defaultServiceOptions(policies, auth)
+	for _, opt := range opts {
+		opt(o)
+	}
+	serverOpts = append(serverOpts, o.chain()...)
+	serverOpts = append(serverOpts, o.serverOpts...)
+
+	return &GrpcService{
+		Server: grpc.NewServer(serverOpts...),
+		name:   name,
 	}
-	return serv
 }
 
+// Serve 阻塞直到服务停止，配合lifecycle.Manager时使用Attach
 func (s *GrpcService) Serve() {
+	common.AssertError(s.listen())
+	s.Server.Serve(s.listener)
+}
+
+// listen 监听端口并注册到服务发现
+func (s *GrpcService) listen() error {
 	serviceName := s.name
 	if serviceName == "" {
 		for name := range s.GetServiceInfo() {
@@ -62,7 +96,7 @@ func (s *GrpcService) Serve() {
 
 	ip4, port, err := common.AddressToIp4Port(address)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	if ip4 == "" {
@@ -71,28 +105,52 @@ func (s *GrpcService) Serve() {
 	s.address = fmt.Sprintf("%s:%d", ip4, port)
 
 	listener, err := net.Listen("tcp", address)
-	common.AssertError(err)
+	if err != nil {
+		return err
+	}
 	s.listener = listener
 
 	if os.Getenv("ENV") != "test" {
-		grpc_health_v1.RegisterHealthServer(s.Server, new(healthService))
+		grpc_health_v1.RegisterHealthServer(s.Server, &healthService{registry: health.Default})
+		health.AddService(serviceName)
+		for name := range s.GetServiceInfo() {
+			health.AddService(name)
+		}
+		health.Start()
 
-		register := &consul.Register{
+		s.probe = &selfProbe{address: s.address, service: serviceName}
+		instance := &discovery.Instance{
 			Service: serviceName,
 			Address: ip4,
 			Port:    port,
-			Check: &api.AgentServiceCheck{
-				Interval:                       "3s",
-				DeregisterCriticalServiceAfter: "1m",
-				GRPC:                           fmt.Sprintf("%s:%d/%s", ip4, port, serviceName),
+			Check: &discovery.Check{
+				Interval:        3 * time.Second,
+				DeregisterAfter: time.Minute,
+				GRPC:            fmt.Sprintf("%s:%d/%s", ip4, port, serviceName),
+				TLS:             config.GetBool("tls", "enabled"),
+				Probe:           s.probe.Probe,
 			},
 		}
+		if err := instance.LoadConfig(); err != nil {
+			return err
+		}
 
-		consulAddress := config.GetString("service", "consul")
-		common.AssertError(register.Register(consulAddress))
-		s.register = register
+		registry, err := discovery.Default()
+		if err != nil {
+			return err
+		}
+		if err := registry.Register(instance); err != nil {
+			return err
+		}
+		s.registry = registry
+		s.instance = instance
 	}
 	os.Setenv("Service", serviceName)
+	if err := trace.Init(serviceName); err != nil {
+		return err
+	}
+
+	registerAdminHandlers()
 
 	go func() {
 		addr := fmt.Sprintf("%s:%d", ip4, port+10000)
@@ -103,30 +161,111 @@ func (s *GrpcService) Serve() {
 	go metrics.ReportInfluxDBV2(serviceName)
 
 	log.Printf("gRPC start serving on: %s\n", address)
-	s.Server.Serve(listener)
+	return nil
+}
+
+// adminOnce http.Handle重复注册同一路径会panic，多个GrpcService或多次listen时只注册一次
+var adminOnce sync.Once
+
+func registerAdminHandlers() {
+	adminOnce.Do(func() {
+		if config.GetBool("service", "config_admin") {
+			http.Handle(configAdminPath, config.AdminHandler())
+		}
+		if config.GetBool("service", "reroute_admin") {
+			http.Handle(rerouteAdminPath, redis.RerouteAdminHandler())
+		}
+	})
+}
+
+// Attach 将启动、注销和drain加入m，Serve异常退出时触发m退出
+func (s *GrpcService) Attach(m *lifecycle.Manager) {
+	m.Append(lifecycle.Hook{
+		Name:  "grpc deregister",
+		Phase: lifecycle.PhaseDeregister,
+		OnStart: func(ctx context.Context) error {
+			if err := s.listen(); err != nil {
+				return err
+			}
+			listener := s.listener
+			go func() {
+				if err := s.Server.Serve(listener); err != nil {
+					m.Fail(fmt.Errorf("grpc serve: %v", err))
+				}
+			}()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return s.deregister()
+		},
+	})
+	m.Append(lifecycle.Hook{
+		Name:   "grpc drain",
+		Phase:  lifecycle.PhaseDrain,
+		OnStop: s.Shutdown,
+	})
 }
 
 func (s *GrpcService) Address() string {
 	return s.address
 }
 
+// deregister 置为NOT_SERVING后注销，Watch的调用方随即摘除本节点；
+// 注销可能需要等待drain_period，期间仍正常提供服务
+func (s *GrpcService) deregister() error {
+	health.Shutdown()
+
+	var err error
+	if s.instance != nil {
+		err = s.registry.Deregister(s.instance)
+		s.instance = nil
+	}
+
+	if s.probe != nil {
+		s.probe.Close()
+		s.probe = nil
+	}
+	return err
+}
+
+// Shutdown 停止接收新连接并等待处理中的RPC结束，ctx到期后强制关闭
+func (s *GrpcService) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.Server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Server.Stop()
+		<-done
+		return fmt.Errorf("graceful stop: %v", ctx.Err())
+	}
+}
+
+// Close 注销并在rpcTimeout内drain，使用lifecycle.Manager时不需要调用
 func (s *GrpcService) Close() {
-	if s.register != nil {
-		consulAddress := config.GetString("service", "consul")
-		s.register.Deregister(consulAddress)
-		s.register = nil
+	if err := s.deregister(); err != nil {
+		logrus.WithField("err", err.Error()).Error("Deregister failed")
 	}
 
-	if s.listener != nil {
-		s.listener.Close()
-		s.listener = nil
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		logrus.WithField("err", err.Error()).Error("Shutdown grpc server failed")
 	}
+	s.listener = nil
+
+	trace.Shutdown(context.Background())
 }
 
 func RecvServerStreamForever(stream grpc.ServerStream) error {
+	var msg RawBuf
 	for {
-		var msg RawBuf
-		if err := stream.RecvMsg(msg); err != nil {
+		if err := stream.RecvMsg(&msg); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,85 @@
+package grpcex
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rickone/athena/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGrpcServiceShutdown(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		timeout time.Duration
+		drained bool
+	}{
+		{"drained", time.Second, true},
+		{"deadline", 20 * time.Millisecond, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			listener := bufconn.Listen(1 << 20)
+			s := NewGrpcService("")
+			started := make(chan struct{})
+			grpc_health_v1.RegisterHealthServer(s.Server, &flakyHealth{check: func(ctx context.Context, n int32) error {
+				close(started)
+				select {
+				case <-ctx.Done():
+				case <-time.After(200 * time.Millisecond):
+				}
+				return nil
+			}})
+			go s.Server.Serve(listener)
+
+			conn, err := grpc.Dial("bufnet",
+				grpc.WithInsecure(),
+				grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+					return listener.Dial()
+				}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			result := make(chan error, 1)
+			go func() {
+				_, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+				result <- err
+			}()
+			<-started
+
+			ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+			defer cancel()
+			shutdownErr := s.Shutdown(ctx)
+			rpcErr := <-result
+			if tc.drained && (shutdownErr != nil || rpcErr != nil) {
+				t.Fatalf("in-flight rpc should finish: shutdown=%v rpc=%v", shutdownErr, rpcErr)
+			}
+			if !tc.drained && (shutdownErr == nil || rpcErr == nil) {
+				t.Fatalf("expected forced stop: shutdown=%v rpc=%v", shutdownErr, rpcErr)
+			}
+		})
+	}
+}
+
+func TestRegisterAdminHandlers(t *testing.T) {
+	config.UpdateValue("service", map[interface{}]interface{}{"config_admin": true, "reroute_admin": true})
+	defer config.UpdateValue("service", map[interface{}]interface{}{"config_admin": nil, "reroute_admin": nil})
+
+	// 多次listen时不重复注册
+	registerAdminHandlers()
+	registerAdminHandlers()
+
+	for _, path := range []string{configAdminPath, rerouteAdminPath} {
+		if _, pattern := http.DefaultServeMux.Handler(httptest.NewRequest(http.MethodGet, path, nil)); pattern != path {
+			t.Fatalf("%s not registered, got %q", path, pattern)
+		}
+	}
+}
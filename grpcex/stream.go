@@ -0,0 +1,214 @@
+package grpcex
+
+import (
+	"context"
+	"log"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/rickone/athena/errcode"
+	"github.com/rickone/athena/metrics"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// serverStream 替换Context并统计收发的消息数
+type serverStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	recv int64
+	sent int64
+
+	onRecv func()
+	onSent func()
+}
+
+func wrapServerStream(ss grpc.ServerStream) *serverStream {
+	return &serverStream{ServerStream: ss, ctx: ss.Context()}
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.recv, 1)
+		if s.onRecv != nil {
+			s.onRecv()
+		}
+	}
+	return err
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.sent, 1)
+		if s.onSent != nil {
+			s.onSent()
+		}
+	}
+	return err
+}
+
+func isHealthMethod(ctx context.Context) bool {
+	service := Meta(ctx).Service
+	return service == "" || service == "grpc.health.v1.Health"
+}
+
+func CtxStreamServerMW(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ws := wrapServerStream(ss)
+	ws.ctx = incomingCtx(ss.Context(), info.FullMethod)
+	return handler(srv, ws)
+}
+
+// AccessLogStreamMW 流结束时记录一条访问日志，含持续时间和收发消息数
+func AccessLogStreamMW(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return accessLogStream(srv, ss, handler, &defaultMethodPolicy)
+}
+
+// accessLogStreamMW 按方法策略的log_sample采样
+func accessLogStreamMW(policyOf func(fullMethod string) *MethodPolicy) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return accessLogStream(srv, ss, handler, policyOf(info.FullMethod))
+	}
+}
+
+func accessLogStream(srv interface{}, ss grpc.ServerStream, handler grpc.StreamHandler, policy *MethodPolicy) error {
+	ctx := ss.Context()
+	if isHealthMethod(ctx) {
+		return handler(srv, ss)
+	}
+
+	ws := wrapServerStream(ss)
+	start := time.Now()
+	err := handler(srv, ws)
+	latency := time.Now().Sub(start).Milliseconds()
+
+	code, failed := errcode.From(err)
+	if !policy.sampled(code) {
+		return err
+	}
+
+	fields := map[string]interface{}{
+		"latency":  latency,
+		"recv_msg": atomic.LoadInt64(&ws.recv),
+		"sent_msg": atomic.LoadInt64(&ws.sent),
+		"code":     code,
+	}
+
+	if err != nil {
+		fields["err"] = err.Error()
+	}
+
+	logger := GetLogger(ctx).WithFields(fields)
+	if code == 0 {
+		logger.Info("Stream access success")
+	} else if failed {
+		logger.Error("Stream access failed")
+	} else {
+		logger.Warn("Stream access denied")
+	}
+	return err
+}
+
+func RecoveryStreamMW(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if ret := recover(); ret != nil {
+			stack := string(debug.Stack())
+			GetLogger(ss.Context()).WithFields(logrus.Fields{
+				"stack": stack,
+				"err":   ret,
+			}).Error("Recover panic")
+			log.Printf("panic: %v\n%s\n", ret, stack)
+
+			if retErr, ok := ret.(error); ok {
+				err = retErr
+			} else {
+				err = status.Errorf(errcode.ErrRpcPanic, "recover panic: %v", ret)
+			}
+		}
+	}()
+	return handler(srv, ss)
+}
+
+// MetricsStreamMW 记录流持续时间、收发消息数和调用结果
+func MetricsStreamMW(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if isHealthMethod(ss.Context()) {
+		return handler(srv, ss)
+	}
+
+	ws := wrapServerStream(ss)
+	recv := metrics.NewCounter("stream_msg", "method", info.FullMethod, "direction", "recv")
+	sent := metrics.NewCounter("stream_msg", "method", info.FullMethod, "direction", "sent")
+	ws.onRecv = func() { recv.Inc(1) }
+	ws.onSent = func() { sent.Inc(1) }
+
+	ts := time.Now()
+	duration := metrics.NewHistogram("stream_duration", "method", info.FullMethod)
+	err := handler(srv, ws)
+	duration.Update(time.Since(ts).Nanoseconds())
+
+	code, failed := errcode.From(err)
+	status := "success"
+	if failed {
+		status = "failed"
+	}
+
+	call := metrics.NewCounter("call", "method", info.FullMethod, "status", status, "code", strconv.Itoa(code))
+	call.Inc(1)
+	return err
+}
+
+func ErrorMapStreamMW(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return errcode.ErrorMap(handler(srv, ss))
+}
+
+// TimeoutStreamMW 限制整个流的持续时间，长连接的流不应使用，默认不在服务端链中
+func TimeoutStreamMW(timeout time.Duration) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		newCtx, cancel := context.WithTimeout(ss.Context(), timeout)
+		defer cancel()
+
+		ws := wrapServerStream(ss)
+		ws.ctx = newCtx
+		err := handler(srv, ws)
+		if newCtx.Err() == context.DeadlineExceeded {
+			return status.Error(errcode.ErrRpcTimeout, "rpc timeout")
+		}
+		return err
+	}
+}
+
+func CtxStreamClientMW() grpc.StreamClientInterceptor {
+	service := os.Getenv("Service")
+
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoingCtx(ctx, service), desc, cc, method, opts...)
+	}
+}
+
+func RerouteStreamClientMW(target string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		conn, release, err := getRerouteClientConn(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		if conn == nil {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		cs, err := streamer(ctx, desc, conn, method, opts...)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		return newRerouteClientStream(cs, desc, release), nil
+	}
+}
@@ -0,0 +1,131 @@
+package grpcex
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/rickone/athena/errcode"
+	"github.com/rickone/athena/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// echoDesc 双向流，收到"panic"时panic，否则原样返回并附上caller
+var echoDesc = grpc.ServiceDesc{
+	ServiceName: "test.Echo",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Stream",
+		ServerStreams: true,
+		ClientStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			for {
+				req := &grpc_health_v1.HealthCheckRequest{}
+				if err := stream.RecvMsg(req); err != nil {
+					if err == io.EOF {
+						return nil
+					}
+					return err
+				}
+				if req.Service == "panic" {
+					panic("boom")
+				}
+
+				caller := Meta(stream.Context()).Caller
+				if err := stream.SendMsg(&grpc_health_v1.HealthCheckRequest{Service: req.Service + "@" + caller}); err != nil {
+					return err
+				}
+			}
+		},
+	}},
+}
+
+func withService(service string, f func() grpc.StreamClientInterceptor) grpc.StreamClientInterceptor {
+	old := os.Getenv("Service")
+	os.Setenv("Service", service)
+	defer os.Setenv("Service", old)
+	return f()
+}
+
+func startStreamServer(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	s := NewGrpcService("")
+	s.RegisterService(&echoDesc, struct{}{})
+	go s.Server.Serve(listener)
+	t.Cleanup(s.Server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithChainStreamInterceptor(withService("tester", CtxStreamClientMW)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestStreamInterceptors(t *testing.T) {
+	conn := startStreamServer(t)
+
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.MD{})
+	stream, err := conn.NewStream(ctx, &echoDesc.Streams[0], "/test.Echo/Stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		if err := stream.SendMsg(&grpc_health_v1.HealthCheckRequest{Service: name}); err != nil {
+			t.Fatal(err)
+		}
+		resp := &grpc_health_v1.HealthCheckRequest{}
+		if err := stream.RecvMsg(resp); err != nil {
+			t.Fatal(err)
+		}
+		// caller由CtxStreamClientMW写入，服务端CtxStreamServerMW解出
+		if resp.Service != name+"@tester" {
+			t.Fatalf("unexpected response: %q", resp.Service)
+		}
+	}
+	stream.CloseSend()
+	if err := stream.RecvMsg(&grpc_health_v1.HealthCheckRequest{}); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	recv := metrics.NewCounter("stream_msg", "method", "/test.Echo/Stream", "direction", "recv").Count()
+	sent := metrics.NewCounter("stream_msg", "method", "/test.Echo/Stream", "direction", "sent").Count()
+	if recv != 2 || sent != 2 {
+		t.Fatalf("unexpected message counters: recv=%d sent=%d", recv, sent)
+	}
+	if metrics.NewHistogram("stream_duration", "method", "/test.Echo/Stream").Count() != 1 {
+		t.Fatal("stream duration not recorded")
+	}
+}
+
+func TestStreamRecovery(t *testing.T) {
+	conn := startStreamServer(t)
+
+	stream, err := conn.NewStream(context.Background(), &echoDesc.Streams[0], "/test.Echo/Stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.SendMsg(&grpc_health_v1.HealthCheckRequest{Service: "panic"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err = stream.RecvMsg(&grpc_health_v1.HealthCheckRequest{})
+	if st, ok := status.FromError(err); !ok || int(st.Code()) != errcode.ErrRpcPanic {
+		t.Fatalf("expected panic error, got %v", err)
+	}
+}
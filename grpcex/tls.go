@@ -0,0 +1,264 @@
+package grpcex
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rickone/athena/config"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// TLSConfig 对应配置中的tls段，服务端和客户端共用:
+//   tls:
+//     enabled: true
+//     cert_file: /etc/athena/tls/tls.crt   # 服务端证书，同时作为客户端证书，mTLS时需包含serverAuth和clientAuth用途
+//     key_file: /etc/athena/tls/tls.key
+//     ca_file: /etc/athena/tls/ca.crt      # 校验对端证书的CA，为空时使用系统CA
+//     client_auth: true                    # 服务端要求并校验客户端证书
+//     server_name: ""                      # 客户端校验服务端证书的名称，默认为连接的服务名或地址
+//     reload_interval: 1m                  # 握手时检查证书文件变化的最小间隔
+// client_auth开启后consul agent的gRPC检查需要enable_agent_tls_for_checks，或改用ttl检查
+type TLSConfig struct {
+	Enabled        bool
+	CertFile       string
+	KeyFile        string
+	CaFile         string
+	ClientAuth     bool
+	ServerName     string
+	ReloadInterval time.Duration `default:"1m"`
+}
+
+func LoadTLSConfig() (*TLSConfig, error) {
+	var conf TLSConfig
+	if err := config.Unmarshal(&conf, "tls"); err != nil {
+		return nil, err
+	}
+	if conf.Enabled && (conf.CertFile == "") != (conf.KeyFile == "") {
+		return nil, fmt.Errorf("tls.cert_file and tls.key_file must be set together")
+	}
+	if conf.Enabled && conf.ClientAuth && conf.CertFile == "" {
+		return nil, fmt.Errorf("tls.client_auth requires tls.cert_file")
+	}
+	return &conf, nil
+}
+
+var (
+	defaultTLSOnce  sync.Once
+	defaultTLSStore *tlsStore
+	defaultTLSErr   error
+)
+
+// defaultTLS 按tls段创建，进程内共用，未开启时返回nil
+func defaultTLS() (*tlsStore, error) {
+	defaultTLSOnce.Do(func() {
+		conf, err := LoadTLSConfig()
+		if err != nil {
+			defaultTLSErr = err
+			return
+		}
+		if conf.Enabled {
+			defaultTLSStore, defaultTLSErr = newTLSStore(conf)
+		}
+	})
+	return defaultTLSStore, defaultTLSErr
+}
+
+// serverCredOption tls开启时返回服务端证书选项
+func serverCredOption() ([]grpc.ServerOption, error) {
+	store, err := defaultTLS()
+	if err != nil || store == nil {
+		return nil, err
+	}
+	if store.conf.CertFile == "" {
+		return nil, fmt.Errorf("tls.cert_file is required by server")
+	}
+	return []grpc.ServerOption{grpc.Creds(store.ServerCredentials())}, nil
+}
+
+// dialCredOption tls开启时使用客户端证书，否则不加密
+func dialCredOption() (grpc.DialOption, error) {
+	store, err := defaultTLS()
+	if err != nil {
+		return nil, err
+	}
+	if store == nil {
+		return grpc.WithInsecure(), nil
+	}
+	return grpc.WithTransportCredentials(store.ClientCredentials()), nil
+}
+
+// tlsStore 持有当前的证书和CA，握手时按reload_interval检查文件是否变化并重新加载，
+// 加载失败时保留原证书
+type tlsStore struct {
+	conf *TLSConfig
+	now  func() time.Time
+
+	mu      sync.Mutex
+	checked time.Time
+	version string
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+}
+
+func newTLSStore(conf *TLSConfig) (*tlsStore, error) {
+	s := &tlsStore{conf: conf, now: time.Now}
+	if err := s.load(s.fileVersion()); err != nil {
+		return nil, err
+	}
+	s.checked = s.now()
+	return s, nil
+}
+
+// fileVersion 由证书文件的修改时间和大小组成，用于判断是否需要重新加载
+func (s *tlsStore) fileVersion() string {
+	var version string
+	for _, path := range []string{s.conf.CertFile, s.conf.KeyFile, s.conf.CaFile} {
+		if path == "" {
+			continue
+		}
+		if fi, err := os.Stat(path); err == nil {
+			version += fmt.Sprintf("%d:%d;", fi.ModTime().UnixNano(), fi.Size())
+		}
+	}
+	return version
+}
+
+func (s *tlsStore) load(version string) error {
+	var cert *tls.Certificate
+	if s.conf.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(s.conf.CertFile, s.conf.KeyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+
+	var pool *x509.CertPool
+	if s.conf.CaFile != "" {
+		pem, err := ioutil.ReadFile(s.conf.CaFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %s", s.conf.CaFile)
+		}
+	}
+
+	s.cert, s.pool, s.version = cert, pool, version
+	return nil
+}
+
+// current 返回当前证书和CA，pool为nil表示使用系统CA
+func (s *tlsStore) current() (*tls.Certificate, *x509.CertPool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.checked) < s.conf.ReloadInterval {
+		return s.cert, s.pool
+	}
+	s.checked = now
+
+	if version := s.fileVersion(); version != s.version {
+		if err := s.load(version); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"cert_file": s.conf.CertFile,
+				"err":       err.Error(),
+			}).Error("Reload tls certificate failed")
+		} else {
+			logrus.WithField("cert_file", s.conf.CertFile).Info("Reload tls certificate")
+		}
+	}
+	return s.cert, s.pool
+}
+
+func (s *tlsStore) ServerCredentials() credentials.TransportCredentials {
+	return credentials.NewTLS(&tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := s.current()
+			if cert == nil {
+				return nil, fmt.Errorf("no server certificate")
+			}
+
+			conf := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				NextProtos:   []string{"h2"},
+			}
+			if s.conf.ClientAuth {
+				conf.ClientAuth = tls.RequireAndVerifyClientCert
+				conf.ClientCAs = pool
+			}
+			return conf, nil
+		},
+	})
+}
+
+// ClientCredentials 由VerifyConnection按当前的CA校验服务端证书，以便CA也能重新加载
+func (s *tlsStore) ClientCredentials() credentials.TransportCredentials {
+	return credentials.NewTLS(&tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         s.conf.ServerName,
+		InsecureSkipVerify: true,
+		VerifyConnection:   s.verifyServer,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := s.current()
+			if cert == nil {
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		},
+	})
+}
+
+func (s *tlsStore) verifyServer(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("no server certificate")
+	}
+
+	_, pool := s.current()
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// PeerIdentity 返回mTLS客户端证书中的身份，依次取DNS SAN、URI SAN、CN，
+// 未校验客户端证书时返回空
+func PeerIdentity(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	cert := info.State.VerifiedChains[0][0]
+	switch {
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	default:
+		return cert.Subject.CommonName
+	}
+}
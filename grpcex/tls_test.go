@@ -0,0 +1,188 @@
+package grpcex
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issueCert 由parent签发证书，parent为nil时生成自签名CA
+func issueCert(t *testing.T, parent *testCert, cn string, dnsNames ...string) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// write 写入dir/name.crt和dir/name.key
+func (c *testCert) write(t *testing.T, dir string, name string) (certFile string, keyFile string) {
+	t.Helper()
+
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, nil, "athena-ca")
+	caFile, _ := ca.write(t, dir, "ca")
+	serverCert, serverKey := issueCert(t, ca, "user", "user").write(t, dir, "server")
+	clientCert, clientKey := issueCert(t, ca, "order").write(t, dir, "client")
+
+	serverTLS, err := newTLSStore(&TLSConfig{Enabled: true, CertFile: serverCert, KeyFile: serverKey, CaFile: caFile, ClientAuth: true, ReloadInterval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var peers []string
+	var peersMu sync.Mutex
+	listener := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(grpc.Creds(serverTLS.ServerCredentials()), grpc.ChainUnaryInterceptor(CtxUnaryServerMW))
+	grpc_health_v1.RegisterHealthServer(s, &flakyHealth{check: func(ctx context.Context, n int32) error {
+		peersMu.Lock()
+		defer peersMu.Unlock()
+		peers = append(peers, Meta(ctx).Peer)
+		return nil
+	}})
+	go s.Serve(listener)
+	defer s.Stop()
+
+	check := func(cred credentials.TransportCredentials) error {
+		t.Helper()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, "bufnet",
+			grpc.WithTransportCredentials(cred),
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.Dial()
+			}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		_, err = grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+		return err
+	}
+	lastPeer := func() string {
+		peersMu.Lock()
+		defer peersMu.Unlock()
+		return peers[len(peers)-1]
+	}
+
+	clientTLS, err := newTLSStore(&TLSConfig{Enabled: true, CertFile: clientCert, KeyFile: clientKey, CaFile: caFile, ServerName: "user", ReloadInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := check(clientTLS.ClientCredentials()); err != nil {
+		t.Fatal(err)
+	}
+	if peer := lastPeer(); peer != "order" {
+		t.Fatalf("unexpected peer: %q", peer)
+	}
+
+	// 服务端要求客户端证书
+	noCert, err := newTLSStore(&TLSConfig{Enabled: true, CaFile: caFile, ServerName: "user", ReloadInterval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := check(noCert.ClientCredentials()); err == nil {
+		t.Fatal("expected error without client certificate")
+	}
+
+	// 服务端证书名称不匹配
+	wrongName, err := newTLSStore(&TLSConfig{Enabled: true, CertFile: clientCert, KeyFile: clientKey, CaFile: caFile, ServerName: "pay", ReloadInterval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := check(wrongName.ClientCredentials()); err == nil {
+		t.Fatal("expected error for mismatched server name")
+	}
+
+	// 证书文件更新后重新加载，SAN优先于CN
+	issueCert(t, ca, "order", "order-v2").write(t, dir, "client")
+	time.Sleep(20 * time.Millisecond)
+	if err := check(clientTLS.ClientCredentials()); err != nil {
+		t.Fatal(err)
+	}
+	if peer := lastPeer(); peer != "order-v2" {
+		t.Fatalf("unexpected peer after reload: %q", peer)
+	}
+
+	// 加载失败时保留原证书
+	if err := ioutil.WriteFile(clientKey, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := check(clientTLS.ClientCredentials()); err != nil {
+		t.Fatal(err)
+	}
+	if peer := lastPeer(); peer != "order-v2" {
+		t.Fatalf("unexpected peer after failed reload: %q", peer)
+	}
+}
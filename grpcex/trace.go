@@ -0,0 +1,111 @@
+package grpcex
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/rickone/athena/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// 健康检查不记录span
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// startServerSpan 以收到的traceparent为父节点开始服务端span
+func startServerSpan(ctx context.Context, fullMethod string) (context.Context, *trace.Span) {
+	if strings.HasPrefix(fullMethod, healthMethodPrefix) {
+		return ctx, nil
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(trace.TraceparentHeader); len(vals) > 0 {
+			ctx = trace.Extract(ctx, vals[0])
+		}
+	}
+
+	ctx, span := trace.Start(ctx, fullMethod, trace.KindServer)
+	setRpcAttrs(span, fullMethod)
+	return ctx, span
+}
+
+// startClientSpan 开始客户端span并将traceparent写入发出的metadata
+func startClientSpan(ctx context.Context, fullMethod string) (context.Context, *trace.Span) {
+	if strings.HasPrefix(fullMethod, healthMethodPrefix) {
+		return ctx, nil
+	}
+	ctx, span := trace.Start(ctx, fullMethod, trace.KindClient)
+	setRpcAttrs(span, fullMethod)
+	return metadata.AppendToOutgoingContext(ctx, trace.TraceparentHeader, span.SpanContext().Traceparent()), span
+}
+
+func setRpcAttrs(span *trace.Span, fullMethod string) {
+	span.SetAttr("rpc.system", "grpc")
+	if subs := regFullMethod.FindStringSubmatch(fullMethod); len(subs) == 3 {
+		span.SetAttr("rpc.service", subs[1])
+		span.SetAttr("rpc.method", subs[2])
+	}
+}
+
+func endSpan(span *trace.Span, err error) {
+	span.SetAttr("rpc.grpc.status_code", int(status.Code(err)))
+	span.SetError(err)
+	span.End()
+}
+
+// TraceUnaryServerMW 位于服务端链的最前面，之后的日志均附带trace_id
+func TraceUnaryServerMW(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, span := startServerSpan(ctx, info.FullMethod)
+	resp, err := handler(ctx, req)
+	endSpan(span, err)
+	return resp, err
+}
+
+func TraceStreamServerMW(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ws := wrapServerStream(ss)
+	var span *trace.Span
+	ws.ctx, span = startServerSpan(ss.Context(), info.FullMethod)
+	err := handler(srv, ws)
+	endSpan(span, err)
+	return err
+}
+
+func TraceUnaryClientMW(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx, span := startClientSpan(ctx, method)
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	endSpan(span, err)
+	return err
+}
+
+// TraceStreamClientMW 客户端流的span在RecvMsg返回错误(含io.EOF)时结束
+func TraceStreamClientMW(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx, span := startClientSpan(ctx, method)
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tracedClientStream{ClientStream: cs, span: span}, nil
+}
+
+type tracedClientStream struct {
+	grpc.ClientStream
+	span *trace.Span
+	once sync.Once
+}
+
+func (s *tracedClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.once.Do(func() {
+			if err == io.EOF {
+				endSpan(s.span, nil)
+			} else {
+				endSpan(s.span, err)
+			}
+		})
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package grpcex
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/rickone/athena/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type memExporter struct {
+	mu    sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (e *memExporter) ExportSpans(ctx context.Context, spans []*trace.SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func TestTracePropagation(t *testing.T) {
+	exporter := &memExporter{}
+	trace.SetExporter(exporter)
+	defer trace.SetExporter(nil)
+
+	listener := bufconn.Listen(1 << 20)
+	s := NewGrpcService()
+	s.RegisterService(&echoDesc, struct{}{})
+	go s.Server.Serve(listener)
+	defer s.Server.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithChainStreamInterceptor(CtxStreamClientMW(), TraceStreamClientMW),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, root := trace.Start(context.Background(), "job", trace.KindInternal)
+	stream, err := conn.NewStream(ctx, &echoDesc.Streams[0], "/test.Echo/Stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.SendMsg(&grpc_health_v1.HealthCheckRequest{Service: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.RecvMsg(&grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	stream.CloseSend()
+	if err := stream.RecvMsg(&grpc_health_v1.HealthCheckRequest{}); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	root.End()
+	s.Server.Stop()
+	trace.Flush()
+
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+
+	byKind := map[trace.Kind]*trace.SpanData{}
+	for _, span := range exporter.spans {
+		byKind[span.Kind] = span
+	}
+	server, client := byKind[trace.KindServer], byKind[trace.KindClient]
+	if server == nil || client == nil || len(exporter.spans) != 3 {
+		t.Fatalf("unexpected spans: %+v", exporter.spans)
+	}
+	if client.TraceID != root.SpanContext().TraceID || client.Parent != root.SpanContext().SpanID {
+		t.Fatalf("client span not a child of root: %+v", client)
+	}
+	if server.TraceID != client.TraceID || server.Parent != client.SpanID || server.Name != "/test.Echo/Stream" {
+		t.Fatalf("server span not a child of client: %+v", server)
+	}
+	if server.Attributes["rpc.service"] != "test.Echo" || server.Attributes["rpc.grpc.status_code"] != 0 {
+		t.Fatalf("unexpected attributes: %v", server.Attributes)
+	}
+}
+
+func TestTraceLogFields(t *testing.T) {
+	ctx, span := trace.Start(context.Background(), "job", trace.KindInternal)
+	defer span.End()
+
+	ctx = NewContext(ctx, &RequestMeta{RequestId: "req-1"})
+	data := Meta(ctx).Logger().Data
+	if data["trace_id"] != span.SpanContext().TraceID.String() || data["span_id"] != span.SpanContext().SpanID.String() {
+		t.Fatalf("unexpected logger fields: %v", data)
+	}
+}
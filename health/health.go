@@ -0,0 +1,328 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rickone/athena/config"
+	"github.com/rickone/athena/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// Status 服务状态，与gRPC健康检查协议一致
+type Status int
+
+const (
+	StatusUnknown Status = iota
+	StatusServing
+	StatusNotServing
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusServing:
+		return "SERVING"
+	case StatusNotServing:
+		return "NOT_SERVING"
+	default:
+		return "SERVICE_UNKNOWN"
+	}
+}
+
+// Checker 检查组件是否可用，如redis.Checker、mysql.Checker
+type Checker func(ctx context.Context) error
+
+type checker struct {
+	check    Checker
+	services []string
+	err      error
+}
+
+// Result 一个检查的结果，Err为空表示通过
+type Result struct {
+	Name     string   `json:"name"`
+	Services []string `json:"services,omitempty"`
+	Err      string   `json:"err,omitempty"`
+}
+
+// Registry 汇总组件检查的结果，服务的状态由作用于该服务的检查决定，
+// 空服务名表示整体状态，由全部检查决定
+type Registry struct {
+	mu       sync.Mutex
+	checkers map[string]*checker
+	services map[string]bool
+	statuses map[string]Status
+	watchers map[string]map[chan Status]bool
+	shutdown bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		checkers: map[string]*checker{},
+		services: map[string]bool{"": true},
+		statuses: map[string]Status{"": StatusServing},
+		watchers: map[string]map[chan Status]bool{},
+	}
+}
+
+// Register 注册检查，services为空时作用于所有服务，同名检查会被替换
+func (r *Registry) Register(name string, check Checker, services ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checkers[name] = &checker{check: check, services: services}
+	r.update()
+}
+
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.checkers, name)
+	r.update()
+}
+
+// AddService 声明对外提供的服务，未声明的服务状态为StatusUnknown
+func (r *Registry) AddService(services ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, service := range services {
+		r.services[service] = true
+	}
+	r.update()
+}
+
+// Status 返回服务状态，未声明的服务返回false
+func (r *Registry) Status(service string) (Status, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status, ok := r.statuses[service]
+	return status, ok
+}
+
+// Results 返回各检查最近一次的结果，按名称排序
+func (r *Registry) Results() []Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	results := make([]Result, 0, len(r.checkers))
+	for name, c := range r.checkers {
+		result := Result{Name: name, Services: c.services}
+		if c.err != nil {
+			result.Err = c.err.Error()
+		}
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	return results
+}
+
+// Watch 先发送当前状态，之后在状态变化时发送，消费不及时只保留最新状态；
+// 返回的cancel用于停止监听
+func (r *Registry) Watch(service string) (<-chan Status, func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch := make(chan Status, 1)
+	ch <- r.statuses[service]
+	if r.watchers[service] == nil {
+		r.watchers[service] = map[chan Status]bool{}
+	}
+	r.watchers[service][ch] = true
+
+	return ch, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.watchers[service], ch)
+	}
+}
+
+// Shutdown 所有服务置为StatusNotServing且不再恢复，用于优雅退出时让调用方先摘除本节点
+func (r *Registry) Shutdown() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.shutdown = true
+	r.update()
+}
+
+// Refresh 并发执行所有检查，超过timeout未返回的检查视为失败
+func (r *Registry) Refresh(ctx context.Context, timeout time.Duration) {
+	r.mu.Lock()
+	checkers := make(map[string]*checker, len(r.checkers))
+	for name, c := range r.checkers {
+		checkers[name] = c
+	}
+	r.mu.Unlock()
+
+	errs := make(map[string]error, len(checkers))
+	errsMu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for name, c := range checkers {
+		wg.Add(1)
+		go func(name string, c *checker) {
+			defer wg.Done()
+			err := runCheck(ctx, c.check, timeout)
+
+			errsMu.Lock()
+			defer errsMu.Unlock()
+			errs[name] = err
+		}(name, c)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for name, err := range errs {
+		c := r.checkers[name]
+		// 检查期间被替换或注销
+		if c != checkers[name] {
+			continue
+		}
+
+		if (err == nil) != (c.err == nil) {
+			fields := logrus.Fields{"check": name}
+			if err != nil {
+				fields["err"] = err.Error()
+				logrus.WithFields(fields).Error("Health check failed")
+			} else {
+				logrus.WithFields(fields).Info("Health check recovered")
+			}
+		}
+		c.err = err
+
+		failed := int64(0)
+		if err != nil {
+			failed = 1
+		}
+		metrics.NewGauge("health_check_failed", "check", name).Update(failed)
+	}
+	r.update()
+}
+
+func runCheck(ctx context.Context, check Checker, timeout time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if ret := recover(); ret != nil {
+				done <- fmt.Errorf("panic: %v", ret)
+			}
+		}()
+		done <- check(ctx)
+	}()
+
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timeout after %v", timeout)
+	}
+}
+
+// update 重新计算各服务状态并通知监听者，需持有锁
+func (r *Registry) update() {
+	for service := range r.services {
+		status := StatusServing
+		if r.shutdown {
+			status = StatusNotServing
+		}
+		for _, c := range r.checkers {
+			if c.err != nil && appliesTo(c, service) {
+				status = StatusNotServing
+				break
+			}
+		}
+
+		if old, ok := r.statuses[service]; ok && old == status {
+			continue
+		}
+		r.statuses[service] = status
+		metrics.NewGauge("health_status", "service", service).Update(int64(status))
+
+		for ch := range r.watchers[service] {
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- status
+		}
+	}
+}
+
+func appliesTo(c *checker, service string) bool {
+	if service == "" || len(c.services) == 0 {
+		return true
+	}
+	for _, s := range c.services {
+		if s == service {
+			return true
+		}
+	}
+	return false
+}
+
+// Config 对应配置中的health段:
+//   health:
+//     interval: 5s    # 执行检查的间隔
+//     timeout: 2s     # 单个检查的超时
+type Config struct {
+	Interval time.Duration `default:"5s"`
+	Timeout  time.Duration `default:"2s"`
+}
+
+var (
+	// Default GrpcService和GinService共用的健康状态
+	Default   = NewRegistry()
+	startOnce = sync.Once{}
+)
+
+func Register(name string, check Checker, services ...string) {
+	Default.Register(name, check, services...)
+}
+
+func AddService(services ...string) {
+	Default.AddService(services...)
+}
+
+func Shutdown() {
+	Default.Shutdown()
+}
+
+// loadConfig 配置有误或非正值时使用默认的5s、2s
+func loadConfig() Config {
+	var conf Config
+	if err := config.Unmarshal(&conf, "health"); err != nil {
+		logrus.WithField("err", err.Error()).Error("Load health config failed")
+	}
+	if conf.Interval <= 0 {
+		conf.Interval = 5 * time.Second
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 2 * time.Second
+	}
+	return conf
+}
+
+// Start 先执行一次检查，再按health.interval定期执行，只在第一次调用时生效
+func Start() {
+	startOnce.Do(func() {
+		conf := loadConfig()
+		Default.Refresh(context.Background(), conf.Timeout)
+		go func() {
+			for range time.Tick(conf.Interval) {
+				Default.Refresh(context.Background(), conf.Timeout)
+			}
+		}()
+	})
+}
@@ -0,0 +1,144 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rickone/athena/config"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.AddService("user", "order")
+
+	var redisErr, mysqlErr error
+	r.Register("redis", func(ctx context.Context) error { return redisErr })
+	r.Register("mysql", func(ctx context.Context) error { return mysqlErr }, "order")
+
+	expect := func(service string, want Status) {
+		t.Helper()
+		if got, _ := r.Status(service); got != want {
+			t.Fatalf("%q: expected %v, got %v", service, want, got)
+		}
+	}
+
+	r.Refresh(context.Background(), time.Second)
+	expect("", StatusServing)
+	expect("user", StatusServing)
+	if _, ok := r.Status("pay"); ok {
+		t.Fatal("pay should be unknown")
+	}
+
+	// 只作用于order的检查不影响user，但影响整体状态
+	mysqlErr = errors.New("connection refused")
+	r.Refresh(context.Background(), time.Second)
+	expect("", StatusNotServing)
+	expect("user", StatusServing)
+	expect("order", StatusNotServing)
+	if results := r.Results(); len(results) != 2 || results[0].Name != "mysql" || results[0].Err != "connection refused" || results[1].Err != "" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+
+	mysqlErr = nil
+	redisErr = errors.New("timeout")
+	r.Refresh(context.Background(), time.Second)
+	expect("user", StatusNotServing)
+	expect("order", StatusNotServing)
+
+	// 超时和panic视为失败
+	redisErr = nil
+	r.Register("slow", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	r.Register("panic", func(ctx context.Context) error { panic("boom") })
+	r.Refresh(context.Background(), 10*time.Millisecond)
+	for _, result := range r.Results() {
+		if (result.Name == "slow" || result.Name == "panic") != (result.Err != "") {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+	}
+	r.Unregister("slow")
+	r.Unregister("panic")
+	expect("", StatusServing)
+}
+
+func TestRegistryWatch(t *testing.T) {
+	r := NewRegistry()
+	r.AddService("user")
+
+	next := func(ch <-chan Status) Status {
+		t.Helper()
+		select {
+		case st := <-ch:
+			return st
+		case <-time.After(time.Second):
+			t.Fatal("no status")
+			return StatusUnknown
+		}
+	}
+
+	ch, cancel := r.Watch("user")
+	defer cancel()
+	if st := next(ch); st != StatusServing {
+		t.Fatalf("unexpected status: %v", st)
+	}
+
+	// 未声明的服务在声明后变为可用
+	pay, cancelPay := r.Watch("pay")
+	if st := next(pay); st != StatusUnknown {
+		t.Fatalf("unexpected status: %v", st)
+	}
+	r.AddService("pay")
+	if st := next(pay); st != StatusServing {
+		t.Fatalf("unexpected status: %v", st)
+	}
+	cancelPay()
+
+	// 状态不变时不通知，消费不及时只保留最新状态
+	fail := true
+	r.Register("redis", func(ctx context.Context) error {
+		if fail {
+			return errors.New("down")
+		}
+		return nil
+	})
+	r.Refresh(context.Background(), time.Second)
+	r.Refresh(context.Background(), time.Second)
+	fail = false
+	r.Refresh(context.Background(), time.Second)
+	fail = true
+	r.Refresh(context.Background(), time.Second)
+	if st := next(ch); st != StatusNotServing {
+		t.Fatalf("unexpected status: %v", st)
+	}
+	select {
+	case st := <-ch:
+		t.Fatalf("unexpected status: %v", st)
+	default:
+	}
+
+	// 关闭后不再恢复
+	fail = false
+	r.Shutdown()
+	r.Refresh(context.Background(), time.Second)
+	if st, _ := r.Status("user"); st != StatusNotServing {
+		t.Fatalf("unexpected status after shutdown: %v", st)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	config.UpdateValue("health", nil)
+	defer config.UpdateValue("health", nil)
+
+	if conf := loadConfig(); conf.Interval != 5*time.Second || conf.Timeout != 2*time.Second {
+		t.Fatalf("unexpected defaults: %+v", conf)
+	}
+
+	config.UpdateValue("health", map[interface{}]interface{}{"interval": "0s", "timeout": "-1s"})
+	if conf := loadConfig(); conf.Interval != 5*time.Second || conf.Timeout != 2*time.Second {
+		t.Fatalf("non-positive values not clamped: %+v", conf)
+	}
+}
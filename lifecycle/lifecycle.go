@@ -0,0 +1,248 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rickone/athena/config"
+	"github.com/rickone/athena/logger"
+	"github.com/rickone/athena/metrics"
+	"github.com/rickone/athena/trace"
+	"github.com/sirupsen/logrus"
+)
+
+// Phase 停止阶段，按从小到大的顺序执行，同一阶段内按添加的逆序执行
+type Phase int
+
+const (
+	// PhaseDeregister 从服务发现注销并置为NOT_SERVING，不再接收新流量
+	PhaseDeregister Phase = iota
+	// PhaseDrain 等待处理中的RPC和HTTP请求结束
+	PhaseDrain
+	// PhaseWorker 停止mq消费者等后台任务
+	PhaseWorker
+	// PhaseFlush 刷新trace、metrics和日志，使用独立的flush_timeout
+	PhaseFlush
+)
+
+// Hook OnStart按添加顺序执行，OnStop按Phase执行，均可为nil
+type Hook struct {
+	Name    string
+	Phase   Phase
+	OnStart func(ctx context.Context) error
+	OnStop  func(ctx context.Context) error
+}
+
+// Config 对应配置中的lifecycle段:
+//   lifecycle:
+//     stop_timeout: 30s    # 注销、drain和停止后台任务的总时长，需大于registry.drain_period
+//     flush_timeout: 5s
+type Config struct {
+	StopTimeout  time.Duration `default:"30s"`
+	FlushTimeout time.Duration `default:"5s"`
+}
+
+// Errors 多个hook返回的错误
+type Errors []error
+
+func (errs Errors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Manager 管理进程的启动和优雅退出，见Run
+type Manager struct {
+	conf Config
+
+	mu      sync.Mutex
+	hooks   []Hook
+	started []Hook
+
+	quit     chan struct{}
+	quitOnce sync.Once
+	failErr  error
+}
+
+// New 读取lifecycle段，并添加刷新trace、metrics和日志的hook
+func New() (*Manager, error) {
+	var conf Config
+	if err := config.Unmarshal(&conf, "lifecycle"); err != nil {
+		return nil, err
+	}
+
+	m := NewWithConfig(conf)
+	m.Append(Hook{Name: "logger", Phase: PhaseFlush, OnStop: func(ctx context.Context) error {
+		return logger.Flush()
+	}})
+	m.Append(Hook{Name: "metrics", Phase: PhaseFlush, OnStop: func(ctx context.Context) error {
+		return metrics.Flush()
+	}})
+	m.Append(Hook{Name: "trace", Phase: PhaseFlush, OnStop: trace.Shutdown})
+	return m, nil
+}
+
+// NewWithConfig 不添加默认hook，非正的超时使用默认的30s、5s
+func NewWithConfig(conf Config) *Manager {
+	if conf.StopTimeout <= 0 {
+		conf.StopTimeout = 30 * time.Second
+	}
+	if conf.FlushTimeout <= 0 {
+		conf.FlushTimeout = 5 * time.Second
+	}
+	return &Manager{
+		conf: conf,
+		quit: make(chan struct{}),
+	}
+}
+
+func (m *Manager) Append(hook Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hooks = append(m.hooks, hook)
+}
+
+// Start 按顺序执行OnStart，失败时停止已启动的hook并返回错误
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	hooks := append([]Hook(nil), m.hooks...)
+	m.mu.Unlock()
+
+	for _, hook := range hooks {
+		if hook.OnStart != nil {
+			if err := hook.OnStart(ctx); err != nil {
+				err = fmt.Errorf("start %s: %v", hook.Name, err)
+				if stopErr := m.Stop(ctx); stopErr != nil {
+					return Errors{err, stopErr}
+				}
+				return err
+			}
+		}
+
+		m.mu.Lock()
+		m.started = append(m.started, hook)
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+// Stop 按阶段执行已启动hook的OnStop，出错时继续执行后续hook并汇总返回，
+// PhaseFlush使用独立的超时，前面的阶段超时后仍会刷新日志
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	hooks := make([]Hook, 0, len(m.started))
+	for i := len(m.started) - 1; i >= 0; i-- {
+		hooks = append(hooks, m.started[i])
+	}
+	m.started = nil
+	m.mu.Unlock()
+
+	sort.SliceStable(hooks, func(i, j int) bool {
+		return hooks[i].Phase < hooks[j].Phase
+	})
+
+	var errs Errors
+	var flushCtx context.Context
+	for _, hook := range hooks {
+		if hook.OnStop == nil {
+			continue
+		}
+
+		hookCtx := ctx
+		if hook.Phase == PhaseFlush {
+			if flushCtx == nil {
+				var cancel context.CancelFunc
+				flushCtx, cancel = context.WithTimeout(context.Background(), m.conf.FlushTimeout)
+				defer cancel()
+			}
+			hookCtx = flushCtx
+		}
+
+		start := time.Now()
+		if err := hook.OnStop(hookCtx); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"hook": hook.Name,
+				"err":  err.Error(),
+			}).Error("Stop hook failed")
+			errs = append(errs, fmt.Errorf("stop %s: %v", hook.Name, err))
+			continue
+		}
+		logrus.WithFields(logrus.Fields{
+			"hook":    hook.Name,
+			"latency": time.Since(start).Milliseconds(),
+		}).Info("Stop hook done")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Fail 后台任务(如Serve)异常退出时调用，触发退出并由Run返回该错误
+func (m *Manager) Fail(err error) {
+	m.quitOnce.Do(func() {
+		m.failErr = err
+		close(m.quit)
+	})
+}
+
+// Shutdown 主动触发退出
+func (m *Manager) Shutdown() {
+	m.quitOnce.Do(func() {
+		close(m.quit)
+	})
+}
+
+// Run 启动后等待SIGTERM、SIGINT、SIGQUIT、Shutdown或Fail，再在stop_timeout内停止，
+// 返回启动、运行或停止过程中的错误，由调用方决定退出码
+func (m *Manager) Run() error {
+	if err := m.Start(context.Background()); err != nil {
+		return err
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	defer signal.Stop(sig)
+
+	select {
+	case s := <-sig:
+		logrus.WithField("signal", s.String()).Info("Shutting down")
+	case <-m.quit:
+		logrus.Info("Shutting down")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.conf.StopTimeout)
+	defer cancel()
+
+	var errs Errors
+	if m.failed() != nil {
+		errs = append(errs, m.failed())
+	}
+	if err := m.Stop(ctx); err != nil {
+		errs = append(errs, err.(Errors)...)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (m *Manager) failed() error {
+	select {
+	case <-m.quit:
+		return m.failErr
+	default:
+		return nil
+	}
+}
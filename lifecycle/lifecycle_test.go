@@ -0,0 +1,140 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rickone/athena/config"
+)
+
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) hook(name string, phase Phase, stopErr error) Hook {
+	return Hook{
+		Name:  name,
+		Phase: phase,
+		OnStart: func(ctx context.Context) error {
+			r.add("start " + name)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			r.add("stop " + name)
+			return stopErr
+		},
+	}
+}
+
+func (r *recorder) add(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.events, ",")
+}
+
+func TestManagerOrder(t *testing.T) {
+	r := &recorder{}
+	m := NewWithConfig(Config{StopTimeout: time.Second, FlushTimeout: time.Second})
+	m.Append(r.hook("log", PhaseFlush, nil))
+	m.Append(r.hook("grpc", PhaseDeregister, nil))
+	m.Append(r.hook("grpc-drain", PhaseDrain, errors.New("timeout")))
+	m.Append(r.hook("http", PhaseDeregister, nil))
+	m.Append(r.hook("mq", PhaseWorker, nil))
+
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	err := m.Stop(context.Background())
+	if err == nil || err.Error() != "stop grpc-drain: timeout" {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// 按阶段停止，同一阶段内逆序，出错后继续
+	expected := "start log,start grpc,start grpc-drain,start http,start mq," +
+		"stop http,stop grpc,stop grpc-drain,stop mq,stop log"
+	if r.String() != expected {
+		t.Fatalf("unexpected events: %s", r)
+	}
+}
+
+func TestManagerStartFailed(t *testing.T) {
+	r := &recorder{}
+	m := NewWithConfig(Config{StopTimeout: time.Second, FlushTimeout: time.Second})
+	m.Append(r.hook("log", PhaseFlush, nil))
+	m.Append(Hook{Name: "grpc", OnStart: func(ctx context.Context) error {
+		return errors.New("address in use")
+	}})
+	m.Append(r.hook("mq", PhaseWorker, nil))
+
+	err := m.Start(context.Background())
+	if err == nil || err.Error() != "start grpc: address in use" {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	// 只停止已启动的hook
+	if r.String() != "start log,stop log" {
+		t.Fatalf("unexpected events: %s", r)
+	}
+}
+
+func TestManagerRun(t *testing.T) {
+	m := NewWithConfig(Config{StopTimeout: 20 * time.Millisecond, FlushTimeout: time.Second})
+
+	var flushErr error
+	m.Append(Hook{Name: "drain", Phase: PhaseDrain, OnStop: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	m.Append(Hook{Name: "log", Phase: PhaseFlush, OnStop: func(ctx context.Context) error {
+		// drain超时后仍有时间刷新
+		flushErr = ctx.Err()
+		return nil
+	}})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Run()
+	}()
+	m.Fail(errors.New("serve: listener closed"))
+	m.Shutdown()
+
+	select {
+	case err := <-done:
+		if err == nil || err.Error() != "serve: listener closed; stop drain: context deadline exceeded" {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("run not returned")
+	}
+	if flushErr != nil {
+		t.Fatalf("flush context expired: %v", flushErr)
+	}
+}
+
+func TestNewDefaults(t *testing.T) {
+	config.UpdateValue("lifecycle", nil)
+	defer config.UpdateValue("lifecycle", nil)
+
+	m, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.conf.StopTimeout != 30*time.Second || m.conf.FlushTimeout != 5*time.Second {
+		t.Fatalf("unexpected defaults: %+v", m.conf)
+	}
+
+	m = NewWithConfig(Config{StopTimeout: -time.Second})
+	if m.conf.StopTimeout != 30*time.Second || m.conf.FlushTimeout != 5*time.Second {
+		t.Fatalf("non-positive timeouts not clamped: %+v", m.conf)
+	}
+}
@@ -34,6 +34,13 @@ func (hook *FileRotateHook) Fire(entry *logrus.Entry) error {
 	return err
 }
 
+func (hook *FileRotateHook) Close() error {
+	if c, ok := hook.writer.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
 func NewFileRotateHook(fileName string, levels ...logrus.Level) (*FileRotateHook, error) {
 	logPath, err := filepath.Abs("./log")
 	if err != nil {
@@ -3,16 +3,26 @@ package logger
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"runtime"
 	"strings"
 
 	"github.com/rickone/athena/common"
 	"github.com/rickone/athena/config"
+	"github.com/rickone/athena/trace"
 	"github.com/sirupsen/logrus"
 )
 
+// closers Init添加的hook，Flush时关闭
+var closers []io.Closer
+
 func Init(name string) {
+	setLevel(config.GetString("service", "log_level"))
+	config.OnChange("service.log_level", func(old, new *config.Value) {
+		setLevel(config.GetString("service", "log_level"))
+	})
+
 	if os.Getenv("DEBUG") != "" {
 		return
 	}
@@ -20,16 +30,19 @@ func Init(name string) {
 	traceLogHook, err := NewFileRotateHook(fmt.Sprintf("%s/trace.log", name), logrus.WarnLevel, logrus.InfoLevel, logrus.DebugLevel, logrus.TraceLevel)
 	common.AssertError(err)
 	logrus.AddHook(traceLogHook)
+	closers = append(closers, traceLogHook)
 
 	errorLogHook, err := NewFileRotateHook(fmt.Sprintf("%s/error.log", name), logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel)
 	common.AssertError(err)
 	logrus.AddHook(errorLogHook)
+	closers = append(closers, errorLogHook)
 
 	graylogAddress := config.GetString("service", "graylog")
 	if graylogAddress != "" {
 		gelfHook, err := NewUdpGelfHook(graylogAddress, logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel, logrus.WarnLevel, logrus.InfoLevel)
 		common.AssertError(err)
 		logrus.AddHook(gelfHook)
+		closers = append(closers, gelfHook)
 	}
 	logrus.SetFormatter(&logrus.TextFormatter{
 		//DisableColors:   true,
@@ -38,8 +51,56 @@ func Init(name string) {
 	})
 }
 
+// Flush 退出前移除并关闭Init添加的日志文件和graylog hook，之后的日志只输出到标准错误
+func Flush() error {
+	if len(closers) == 0 {
+		return nil
+	}
+
+	closing := map[interface{}]bool{}
+	for _, c := range closers {
+		closing[c] = true
+	}
+	hooks := logrus.LevelHooks{}
+	for level, levelHooks := range logrus.StandardLogger().Hooks {
+		for _, hook := range levelHooks {
+			if !closing[hook] {
+				hooks[level] = append(hooks[level], hook)
+			}
+		}
+	}
+	logrus.StandardLogger().ReplaceHooks(hooks)
+
+	var err error
+	for _, c := range closers {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	closers = nil
+	return err
+}
+
+func setLevel(name string) {
+	if name == "" {
+		name = logrus.InfoLevel.String()
+	}
+
+	level, err := logrus.ParseLevel(name)
+	if err != nil {
+		logrus.WithField("level", name).Warn("Invalid log level")
+		return
+	}
+	logrus.SetLevel(level)
+}
+
+// NewEntry ctx中有trace时附加trace_id和span_id
 func NewEntry(ctx context.Context, fields map[string]interface{}) *logrus.Entry {
-	return logrus.WithContext(ctx).WithFields(fields)
+	entry := logrus.WithContext(ctx).WithFields(fields)
+	if traceFields := trace.LogFields(ctx); traceFields != nil {
+		entry = entry.WithFields(traceFields)
+	}
+	return entry
 }
 
 func getFileAndLine() (string, int) {
@@ -47,6 +47,10 @@ func (hook *UdpGelfHook) Fire(entry *logrus.Entry) error {
 	return err
 }
 
+func (hook *UdpGelfHook) Close() error {
+	return hook.conn.Close()
+}
+
 func NewUdpGelfHook(address string, levels ...logrus.Level) (*UdpGelfHook, error) {
 	host, err := os.Hostname()
 	if err != nil {
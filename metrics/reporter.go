@@ -3,6 +3,7 @@ package metrics
 import (
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
@@ -16,26 +17,34 @@ const (
 )
 
 var (
-	client influxdb2.Client
+	client       influxdb2.Client
+	clientOrg    string
+	clientBucket string
+	clientTags   map[string]string
+	clientMu     sync.Mutex
 )
 
 func ReportInfluxDBV2(service string) {
+	clientMu.Lock()
 	if client != nil {
+		clientMu.Unlock()
 		return
 	}
 
 	influxdb := config.GetValue("influxdb")
 	common.Assert(influxdb != nil, "config influxdb empty")
 
-	client = influxdb2.NewClient(fmt.Sprintf("http://%s", influxdb.GetString("address")), influxdb.GetString("token"))
-
 	host, err := os.Hostname()
 	common.AssertError(err)
 
-	tags := map[string]string{
+	client = influxdb2.NewClient(fmt.Sprintf("http://%s", influxdb.GetString("address")), influxdb.GetString("token"))
+	clientOrg = influxdb.GetString("org")
+	clientBucket = influxdb.GetString("bucket")
+	clientTags = map[string]string{
 		"host":    host,
 		"service": service,
 	}
+	clientMu.Unlock()
 
 	go watchNumGoroutine()
 
@@ -43,11 +52,30 @@ func ReportInfluxDBV2(service string) {
 	for {
 		select {
 		case <-t:
-			report(metrics.DefaultRegistry, influxdb.GetString("org"), influxdb.GetString("bucket"), tags)
+			clientMu.Lock()
+			if client != nil {
+				report(metrics.DefaultRegistry, clientOrg, clientBucket, clientTags)
+			}
+			clientMu.Unlock()
 		}
 	}
 }
 
+// Flush 退出前上报一次并关闭客户端，之后不再上报
+func Flush() error {
+	clientMu.Lock()
+	defer clientMu.Unlock()
+
+	if client == nil {
+		return nil
+	}
+
+	report(metrics.DefaultRegistry, clientOrg, clientBucket, clientTags)
+	client.Close()
+	client = nil
+	return nil
+}
+
 func report(r metrics.Registry, org string, bucket string, tags map[string]string) {
 	w := client.WriteAPI(org, bucket)
 
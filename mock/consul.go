@@ -0,0 +1,545 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	consulDefaultWait = 10 * time.Second
+)
+
+// Consul 进程内的Consul HTTP API替身，支持KV、服务注册、健康查询、会话锁及阻塞查询
+type Consul struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	index       uint64
+	changed     chan struct{}
+	kvs         map[string]*api.KVPair
+	services    map[string]*consulService
+	sessions    map[string]*api.SessionEntry
+	nextSession int
+	healthQuery url.Values
+	failing     bool
+}
+
+type consulService struct {
+	reg    *api.AgentServiceRegistration
+	status string
+	output string
+}
+
+func NewConsul() *Consul {
+	c := &Consul{
+		index:    1,
+		changed:  make(chan struct{}),
+		kvs:      map[string]*api.KVPair{},
+		services: map[string]*consulService{},
+		sessions: map[string]*api.SessionEntry{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/kv/", c.handleKV)
+	mux.HandleFunc("/v1/agent/service/register", c.handleRegister)
+	mux.HandleFunc("/v1/agent/service/deregister/", c.handleDeregister)
+	mux.HandleFunc("/v1/agent/service/maintenance/", c.handleMaintenance)
+	mux.HandleFunc("/v1/agent/check/update/", c.handleCheckUpdate)
+	mux.HandleFunc("/v1/health/service/", c.handleHealth)
+	mux.HandleFunc("/v1/session/create", c.handleSessionCreate)
+	mux.HandleFunc("/v1/session/renew/", c.handleSessionRenew)
+	mux.HandleFunc("/v1/session/destroy/", c.handleSessionDestroy)
+	c.Server = httptest.NewServer(c.wrap(mux))
+	return c
+}
+
+// Address 可直接用作api.Config.Address
+func (c *Consul) Address() string {
+	return strings.TrimPrefix(c.URL, "http://")
+}
+
+// SetFailing 为true时所有请求返回500
+func (c *Consul) SetFailing(failing bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failing = failing
+	c.bump()
+}
+
+func (c *Consul) PutKV(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.putKV(key, value)
+}
+
+func (c *Consul) DeleteKV(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.kvs, key)
+	c.bump()
+}
+
+func (c *Consul) GetKV(key string) *api.KVPair {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	kv := c.kvs[key]
+	if kv == nil {
+		return nil
+	}
+	dup := *kv
+	return &dup
+}
+
+func (c *Consul) putKV(key string, value []byte) *api.KVPair {
+	c.bump()
+	kv := c.kvs[key]
+	if kv == nil {
+		kv = &api.KVPair{Key: key, CreateIndex: c.index}
+		c.kvs[key] = kv
+	}
+	kv.Value = value
+	kv.ModifyIndex = c.index
+	return kv
+}
+
+// bump 推进索引并唤醒阻塞查询，调用方需持有mu
+func (c *Consul) bump() {
+	c.index++
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *Consul) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		failing := c.failing
+		c.mu.Unlock()
+
+		if failing {
+			http.Error(w, "consul unavailable", http.StatusInternalServerError)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// block 实现阻塞查询：请求的index不小于当前索引时等待变化或超时
+func (c *Consul) block(r *http.Request) {
+	index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	if index == 0 {
+		return
+	}
+
+	wait := consulDefaultWait
+	if s := r.URL.Query().Get("wait"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			wait = d
+		}
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		c.mu.Lock()
+		current, changed := c.index, c.changed
+		c.mu.Unlock()
+
+		if index < current {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (c *Consul) writeJSON(w http.ResponseWriter, val interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(val)
+}
+
+func (c *Consul) setIndex(w http.ResponseWriter) {
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(c.index, 10))
+}
+
+func (c *Consul) handleKV(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+
+	switch r.Method {
+	case http.MethodGet:
+		c.block(r)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.setIndex(w)
+
+		_, recurse := r.URL.Query()["recurse"]
+		var pairs api.KVPairs
+		for k, kv := range c.kvs {
+			if k == key || (recurse && strings.HasPrefix(k, key)) {
+				dup := *kv
+				pairs = append(pairs, &dup)
+			}
+		}
+		if len(pairs) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sort.Slice(pairs, func(i, j int) bool {
+			return pairs[i].Key < pairs[j].Key
+		})
+		c.writeJSON(w, pairs)
+
+	case http.MethodPut:
+		value, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		query := r.URL.Query()
+		if sid := query.Get("acquire"); sid != "" {
+			if c.sessions[sid] == nil {
+				http.Error(w, "invalid session", http.StatusInternalServerError)
+				return
+			}
+			if kv := c.kvs[key]; kv != nil && kv.Session != "" && kv.Session != sid {
+				c.writeJSON(w, false)
+				return
+			}
+			kv := c.putKV(key, value)
+			if kv.Session != sid {
+				kv.LockIndex++
+			}
+			kv.Session = sid
+			c.writeJSON(w, true)
+			return
+		}
+		if sid := query.Get("release"); sid != "" {
+			kv := c.kvs[key]
+			if kv == nil || kv.Session != sid {
+				c.writeJSON(w, false)
+				return
+			}
+			kv = c.putKV(key, value)
+			kv.Session = ""
+			c.writeJSON(w, true)
+			return
+		}
+
+		c.putKV(key, value)
+		c.writeJSON(w, true)
+
+	case http.MethodDelete:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.kvs, key)
+		c.bump()
+		c.writeJSON(w, true)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Service 返回已注册的服务，不存在时返回nil
+func (c *Consul) Service(id string) *api.AgentServiceRegistration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	svc := c.services[id]
+	if svc == nil {
+		return nil
+	}
+	return svc.reg
+}
+
+// ServiceStatus 返回服务健康状态(passing/warning/critical)，不存在时返回""
+func (c *Consul) ServiceStatus(id string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	svc := c.services[id]
+	if svc == nil {
+		return ""
+	}
+	return svc.status
+}
+
+func (c *Consul) SetServiceStatus(id string, status string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if svc := c.services[id]; svc != nil {
+		svc.status = status
+		c.bump()
+	}
+}
+
+// ServiceOutput 返回服务检查最近上报的输出
+func (c *Consul) ServiceOutput(id string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	svc := c.services[id]
+	if svc == nil {
+		return ""
+	}
+	return svc.output
+}
+
+// ResetAgent 模拟agent重启丢失所有注册信息
+func (c *Consul) ResetAgent() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.services = map[string]*consulService{}
+	c.bump()
+}
+
+// LastHealthQuery 最近一次健康查询的参数，用于断言tag/dc/near等过滤条件
+func (c *Consul) LastHealthQuery() url.Values {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.healthQuery
+}
+
+func (c *Consul) handleRegister(w http.ResponseWriter, r *http.Request) {
+	reg := &api.AgentServiceRegistration{}
+	if err := json.NewDecoder(r.Body).Decode(reg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if reg.ID == "" {
+		reg.ID = reg.Name
+	}
+
+	status := api.HealthPassing
+	if reg.Check != nil && reg.Check.TTL != "" {
+		status = api.HealthCritical
+	}
+	if reg.Check != nil && reg.Check.Status != "" {
+		status = reg.Check.Status
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.services[reg.ID] = &consulService{reg: reg, status: status}
+	c.bump()
+}
+
+func (c *Consul) handleDeregister(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.services[id]; !ok {
+		http.Error(w, "Unknown service ID", http.StatusNotFound)
+		return
+	}
+	delete(c.services, id)
+	c.bump()
+}
+
+func (c *Consul) handleHealth(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/v1/health/service/")
+	query := r.URL.Query()
+	c.block(r)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.healthQuery = query
+	c.setIndex(w)
+
+	_, passingOnly := query["passing"]
+	entries := []*api.ServiceEntry{}
+	for _, svc := range c.services {
+		if svc.reg.Name != name || (passingOnly && svc.status != api.HealthPassing) || !hasTags(svc.reg.Tags, query["tag"]) {
+			continue
+		}
+
+		service := &api.AgentService{
+			ID:      svc.reg.ID,
+			Service: svc.reg.Name,
+			Tags:    svc.reg.Tags,
+			Meta:    svc.reg.Meta,
+			Port:    svc.reg.Port,
+			Address: svc.reg.Address,
+		}
+		if svc.reg.Weights != nil {
+			service.Weights = *svc.reg.Weights
+		}
+		entries = append(entries, &api.ServiceEntry{
+			Node:    &api.Node{Node: "mock", Address: "127.0.0.1"},
+			Service: service,
+			Checks:  api.HealthChecks{{ServiceID: svc.reg.ID, Status: svc.status}},
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Service.ID < entries[j].Service.ID
+	})
+	c.writeJSON(w, entries)
+}
+
+func hasTags(tags []string, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, t := range tags {
+			if t == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// handleMaintenance 开启维护模式时服务状态为critical
+func (c *Consul) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/agent/service/maintenance/")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	svc := c.services[id]
+	if svc == nil {
+		http.Error(w, "Unknown service ID", http.StatusNotFound)
+		return
+	}
+
+	if r.URL.Query().Get("enable") == "true" {
+		svc.status, svc.output = api.HealthCritical, r.URL.Query().Get("reason")
+	} else {
+		svc.status, svc.output = api.HealthPassing, ""
+	}
+	c.bump()
+}
+
+// handleCheckUpdate 只支持注册时内联的检查，ID为"service:<服务ID>"
+func (c *Consul) handleCheckUpdate(w http.ResponseWriter, r *http.Request) {
+	checkID := strings.TrimPrefix(r.URL.Path, "/v1/agent/check/update/")
+
+	var update struct {
+		Status string
+		Output string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	svc := c.services[strings.TrimPrefix(checkID, "service:")]
+	if svc == nil || svc.reg.Check == nil || svc.reg.Check.TTL == "" {
+		http.Error(w, "CheckID does not have associated TTL", http.StatusInternalServerError)
+		return
+	}
+
+	if svc.status != update.Status || svc.output != update.Output {
+		svc.status, svc.output = update.Status, update.Output
+		c.bump()
+	}
+}
+
+// Sessions 返回当前有效的会话ID
+func (c *Consul) Sessions() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := make([]string, 0, len(c.sessions))
+	for id := range c.sessions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// InvalidateSession 模拟会话TTL过期，释放其持有的锁
+func (c *Consul) InvalidateSession(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.destroySession(id)
+}
+
+func (c *Consul) destroySession(id string) bool {
+	if c.sessions[id] == nil {
+		return false
+	}
+	delete(c.sessions, id)
+
+	for _, kv := range c.kvs {
+		if kv.Session == id {
+			kv.Session = ""
+			kv.ModifyIndex = c.index + 1
+		}
+	}
+	c.bump()
+	return true
+}
+
+func (c *Consul) handleSessionCreate(w http.ResponseWriter, r *http.Request) {
+	entry := &api.SessionEntry{}
+	if err := json.NewDecoder(r.Body).Decode(entry); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextSession++
+	entry.ID = fmt.Sprintf("session-%d", c.nextSession)
+	entry.CreateIndex = c.index
+	c.sessions[entry.ID] = entry
+	c.writeJSON(w, map[string]string{"ID": entry.ID})
+}
+
+func (c *Consul) handleSessionRenew(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/session/renew/")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.sessions[id]
+	if entry == nil {
+		http.Error(w, "Session id not found", http.StatusNotFound)
+		return
+	}
+	c.writeJSON(w, []*api.SessionEntry{entry})
+}
+
+func (c *Consul) handleSessionDestroy(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/session/destroy/")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.destroySession(id)
+	c.writeJSON(w, true)
+}
@@ -3,6 +3,7 @@ package mq
 import (
 	"context"
 	"encoding/base64"
+	"fmt"
 	"log"
 	"runtime/debug"
 	"time"
@@ -13,6 +14,9 @@ import (
 	"github.com/rickone/athena/config"
 	"github.com/rickone/athena/errcode"
 	"github.com/rickone/athena/grpcex"
+	"github.com/rickone/athena/health"
+	"github.com/rickone/athena/lifecycle"
+	"github.com/rickone/athena/trace"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc/status"
 )
@@ -21,6 +25,7 @@ type Consumer struct {
 	address string
 	channel string
 	clients []*nsq.Consumer
+	topics  []string
 }
 
 func NewConsumer(channel string) *Consumer {
@@ -40,10 +45,32 @@ func (c *Consumer) OnWithTimeout(topic string, f func(ctx context.Context, m *ns
 		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
 
-		reqId := uuid.New().String()
-		ctx = grpcex.NewCtxWithValue(ctx, c.channel, "", "", reqId, "", "", topic)
+		// 上游通过PublishCtx等发布时沿用其请求上下文和trace
+		meta, traceparent, body := decodeMeta(m.Body)
+		if meta == nil {
+			meta = &grpcex.RequestMeta{}
+		}
+		if meta.RequestId == "" {
+			meta.RequestId = uuid.New().String()
+		}
+		meta.Service = c.channel
+		meta.Topic = topic
+		m.Body = body
+
+		ctx, span := trace.Start(trace.Extract(ctx, traceparent), "consume "+topic, trace.KindConsumer)
+		span.SetAttr("messaging.system", "nsq")
+		span.SetAttr("messaging.destination", topic)
+		span.SetAttr("messaging.nsq.channel", c.channel)
+		span.SetAttr("messaging.nsq.attempts", int(m.Attempts))
+
+		ctx = grpcex.NewContext(ctx, meta)
 		start := time.Now()
 
+		defer func() {
+			span.SetError(err)
+			span.End()
+		}()
+
 		defer func() {
 			latency := time.Now().Sub(start).Milliseconds()
 
@@ -85,14 +112,49 @@ func (c *Consumer) OnWithTimeout(topic string, f func(ctx context.Context, m *ns
 	common.AssertError(err)
 
 	c.clients = append(c.clients, consumer)
+	c.topics = append(c.topics, topic)
 }
 
 func (c *Consumer) On(topic string, f func(ctx context.Context, m *nsq.Message) error) {
 	c.OnWithTimeout(topic, f, 8*time.Second)
 }
 
+// Checker 任一topic没有可用的nsqd连接时失败，用于health.Register
+func (c *Consumer) Checker() health.Checker {
+	return func(ctx context.Context) error {
+		for i, cli := range c.clients {
+			if cli.Stats().Connections == 0 {
+				return fmt.Errorf("nsq consumer %s of topic %s not connected", c.channel, c.topics[i])
+			}
+		}
+		return nil
+	}
+}
+
 func (c *Consumer) Stop() {
 	for _, cli := range c.clients {
 		cli.Stop()
 	}
 }
+
+// Shutdown 停止接收消息并等待处理中的消息结束
+func (c *Consumer) Shutdown(ctx context.Context) error {
+	c.Stop()
+	for i, cli := range c.clients {
+		select {
+		case <-cli.StopChan:
+		case <-ctx.Done():
+			return fmt.Errorf("nsq consumer %s of topic %s: %v", c.channel, c.topics[i], ctx.Err())
+		}
+	}
+	return nil
+}
+
+// Attach 在lifecycle.PhaseWorker停止消费
+func (c *Consumer) Attach(m *lifecycle.Manager) {
+	m.Append(lifecycle.Hook{
+		Name:   "mq consumer " + c.channel,
+		Phase:  lifecycle.PhaseWorker,
+		OnStop: c.Shutdown,
+	})
+}
@@ -0,0 +1,66 @@
+package mq
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+
+	"github.com/rickone/athena/grpcex"
+	"github.com/rickone/athena/trace"
+)
+
+// 带请求上下文的消息体: metaMagic + 2字节长度(大端) + JSON键值 + 原消息体
+// protobuf和JSON消息体不会以0x00开头，未带上下文的旧消息按原样处理
+const (
+	metaMagic  = "\x00ATHM"
+	metaLenLen = 2
+)
+
+// encodeMeta 将ctx中需传递的RequestMeta及traceparent附在消息体前
+func encodeMeta(ctx context.Context, body []byte) []byte {
+	meta := *grpcex.Meta(ctx)
+	meta.Caller = os.Getenv("Service")
+
+	kvs := map[string]string{}
+	for k, v := range meta.Propagated() {
+		if v != "" {
+			kvs[k] = v
+		}
+	}
+	if tp := trace.Inject(ctx); tp != "" {
+		kvs[trace.TraceparentHeader] = tp
+	}
+	data, _ := json.Marshal(kvs)
+	if len(data) > 0xffff {
+		return body
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(metaMagic)+metaLenLen+len(data)+len(body)))
+	buf.WriteString(metaMagic)
+	binary.Write(buf, binary.BigEndian, uint16(len(data)))
+	buf.Write(data)
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// decodeMeta 拆出消息体前的RequestMeta和traceparent，没有时返回nil和原消息体
+func decodeMeta(body []byte) (*grpcex.RequestMeta, string, []byte) {
+	if !bytes.HasPrefix(body, []byte(metaMagic)) || len(body) < len(metaMagic)+metaLenLen {
+		return nil, "", body
+	}
+
+	data := body[len(metaMagic):]
+	n := int(binary.BigEndian.Uint16(data))
+	data = data[metaLenLen:]
+	if len(data) < n {
+		return nil, "", body
+	}
+
+	var kvs map[string]string
+	if err := json.Unmarshal(data[:n], &kvs); err != nil {
+		return nil, "", body
+	}
+	return grpcex.MetaFromMap(kvs), kvs[trace.TraceparentHeader], data[n:]
+}
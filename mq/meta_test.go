@@ -0,0 +1,48 @@
+package mq
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/rickone/athena/grpcex"
+	"github.com/rickone/athena/trace"
+)
+
+func TestMetaEnvelope(t *testing.T) {
+	grpcex.RegisterMetaKey("tenant")
+	os.Setenv("Service", "order")
+	defer os.Unsetenv("Service")
+
+	ctx := grpcex.NewContext(context.Background(), &grpcex.RequestMeta{
+		RequestId: "req-1",
+		UserId:    "42",
+		Extra:     map[string]string{"tenant": "acme"},
+	})
+	body := []byte(`{"id":1}`)
+
+	ctx, span := trace.Start(ctx, "publish order", trace.KindProducer)
+	defer span.End()
+
+	meta, traceparent, payload := decodeMeta(encodeMeta(ctx, body))
+	if meta == nil || !bytes.Equal(payload, body) {
+		t.Fatalf("unexpected decode: %+v %q", meta, payload)
+	}
+	if meta.RequestId != "req-1" || meta.UserId != "42" || meta.Caller != "order" || meta.Get("tenant") != "acme" {
+		t.Fatalf("unexpected meta: %+v", meta)
+	}
+	if traceparent != span.SpanContext().Traceparent() {
+		t.Fatalf("unexpected traceparent: %q", traceparent)
+	}
+
+	// 旧消息原样返回
+	meta, _, payload = decodeMeta(body)
+	if meta != nil || !bytes.Equal(payload, body) {
+		t.Fatalf("unexpected decode of plain body: %+v %q", meta, payload)
+	}
+	meta, _, _ = decodeMeta([]byte(metaMagic + "\xff"))
+	if meta != nil {
+		t.Fatal("truncated envelope should be ignored")
+	}
+}
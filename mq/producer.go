@@ -1,6 +1,7 @@
 package mq
 
 import (
+	"context"
 	"encoding/json"
 	"sync"
 	"time"
@@ -9,6 +10,9 @@ import (
 	"github.com/nsqio/go-nsq"
 	"github.com/rickone/athena/common"
 	"github.com/rickone/athena/config"
+	"github.com/rickone/athena/health"
+	"github.com/rickone/athena/lifecycle"
+	"github.com/rickone/athena/trace"
 	"github.com/sirupsen/logrus"
 )
 
@@ -28,7 +32,32 @@ func getProducer() *nsq.Producer {
 	return producerCli
 }
 
+// ProducerChecker 检查与nsqd的连接，用于health.Register
+func ProducerChecker() health.Checker {
+	return func(ctx context.Context) error {
+		return getProducer().Ping()
+	}
+}
+
+// AttachProducer 在lifecycle.PhaseFlush关闭与nsqd的连接，此前消费者已停止
+func AttachProducer(m *lifecycle.Manager) {
+	m.Append(lifecycle.Hook{
+		Name:  "mq producer",
+		Phase: lifecycle.PhaseFlush,
+		OnStop: func(ctx context.Context) error {
+			if producerCli != nil {
+				producerCli.Stop()
+			}
+			return nil
+		},
+	})
+}
+
 func Publish(topic string, body []byte) {
+	publish(topic, body)
+}
+
+func publish(topic string, body []byte) error {
 	err := getProducer().Publish(topic, body)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
@@ -36,9 +65,14 @@ func Publish(topic string, body []byte) {
 			"err":   err.Error(),
 		}).Error("Publish failed")
 	}
+	return err
 }
 
 func DeferredPublish(topic string, delay time.Duration, body []byte) {
+	deferredPublish(topic, delay, body)
+}
+
+func deferredPublish(topic string, delay time.Duration, body []byte) error {
 	err := getProducer().DeferredPublish(topic, delay, body)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
@@ -47,6 +81,7 @@ func DeferredPublish(topic string, delay time.Duration, body []byte) {
 			"err":   err.Error(),
 		}).Error("DeferredPublish failed")
 	}
+	return err
 }
 
 func PublishJSON(topic string, value interface{}) {
@@ -76,3 +111,38 @@ func DeferredPublishProto(topic string, delay time.Duration, value proto.Message
 
 	DeferredPublish(topic, delay, data)
 }
+
+// PublishCtx 发布时附带ctx中的请求上下文(request_id、user_id及注册键等)和trace，消费端自动还原
+func PublishCtx(ctx context.Context, topic string, body []byte) {
+	ctx, span := startProducerSpan(ctx, topic)
+	span.SetError(publish(topic, encodeMeta(ctx, body)))
+	span.End()
+}
+
+func DeferredPublishCtx(ctx context.Context, topic string, delay time.Duration, body []byte) {
+	ctx, span := startProducerSpan(ctx, topic)
+	span.SetAttr("messaging.delay_ms", delay.Milliseconds())
+	span.SetError(deferredPublish(topic, delay, encodeMeta(ctx, body)))
+	span.End()
+}
+
+func startProducerSpan(ctx context.Context, topic string) (context.Context, *trace.Span) {
+	ctx, span := trace.Start(ctx, "publish "+topic, trace.KindProducer)
+	span.SetAttr("messaging.system", "nsq")
+	span.SetAttr("messaging.destination", topic)
+	return ctx, span
+}
+
+func PublishJSONCtx(ctx context.Context, topic string, value interface{}) {
+	data, err := json.Marshal(value)
+	common.AssertError(err)
+
+	PublishCtx(ctx, topic, data)
+}
+
+func PublishProtoCtx(ctx context.Context, topic string, value proto.Message) {
+	data, err := proto.Marshal(value)
+	common.AssertError(err)
+
+	PublishCtx(ctx, topic, data)
+}
@@ -1,6 +1,7 @@
 package mysql
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -8,6 +9,7 @@ import (
 	_ "github.com/jinzhu/gorm/dialects/mysql"
 	"github.com/rickone/athena/common"
 	"github.com/rickone/athena/config"
+	"github.com/rickone/athena/health"
 )
 
 var (
@@ -21,9 +23,17 @@ func NewClient(addr, username, password, db string) *gorm.DB {
 	common.AssertError(err)
 
 	cli.SingularTable(true)
+	RegisterTrace(cli)
 	return cli.Debug()
 }
 
+// Checker 返回ping指定库的健康检查，用于health.Register
+func Checker(name string) health.Checker {
+	return func(ctx context.Context) error {
+		return DB(name).DB().PingContext(ctx)
+	}
+}
+
 func DB(name string) *gorm.DB {
 	cli := getMySQLCli(name)
 	if cli != nil {
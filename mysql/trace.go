@@ -0,0 +1,72 @@
+package mysql
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+	"github.com/rickone/athena/trace"
+)
+
+const (
+	traceCtxKey  = "trace:ctx"
+	traceSpanKey = "trace:span"
+)
+
+// WithContext 返回携带ctx的*gorm.DB，ctx中有trace时每条语句记录一个客户端span
+//   mysql.WithContext(ctx, mysql.DB("user")).Where("id = ?", id).First(&user)
+func WithContext(ctx context.Context, db *gorm.DB) *gorm.DB {
+	return db.Set(traceCtxKey, ctx)
+}
+
+// RegisterTrace 注册记录span的回调，NewClient创建的客户端已注册
+func RegisterTrace(db *gorm.DB) {
+	cb := db.Callback()
+	cb.Create().Before("gorm:begin_transaction").Register("trace:before_create", traceBefore("create"))
+	cb.Create().After("gorm:commit_or_rollback_transaction").Register("trace:after_create", traceAfter)
+	cb.Update().Before("gorm:begin_transaction").Register("trace:before_update", traceBefore("update"))
+	cb.Update().After("gorm:commit_or_rollback_transaction").Register("trace:after_update", traceAfter)
+	cb.Delete().Before("gorm:begin_transaction").Register("trace:before_delete", traceBefore("delete"))
+	cb.Delete().After("gorm:commit_or_rollback_transaction").Register("trace:after_delete", traceAfter)
+	cb.Query().Before("gorm:query").Register("trace:before_query", traceBefore("query"))
+	cb.Query().After("gorm:after_query").Register("trace:after_query", traceAfter)
+	cb.RowQuery().Before("gorm:row_query").Register("trace:before_row_query", traceBefore("row_query"))
+	cb.RowQuery().After("gorm:row_query").Register("trace:after_row_query", traceAfter)
+}
+
+func traceBefore(operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		val, ok := scope.Get(traceCtxKey)
+		if !ok {
+			return
+		}
+		ctx, ok := val.(context.Context)
+		if !ok {
+			return
+		}
+
+		table := scope.TableName()
+		_, span := trace.StartChild(ctx, "mysql "+operation+" "+table, trace.KindClient)
+		if span == nil {
+			return
+		}
+		span.SetAttr("db.system", "mysql")
+		span.SetAttr("db.operation", operation)
+		span.SetAttr("db.sql.table", table)
+		scope.InstanceSet(traceSpanKey, span)
+	}
+}
+
+func traceAfter(scope *gorm.Scope) {
+	val, ok := scope.InstanceGet(traceSpanKey)
+	if !ok {
+		return
+	}
+	span := val.(*trace.Span)
+
+	span.SetAttr("db.statement", scope.SQL)
+	span.SetAttr("db.rows_affected", scope.DB().RowsAffected)
+	if err := scope.DB().Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		span.SetError(err)
+	}
+	span.End()
+}
@@ -1,18 +1,31 @@
 package redis
 
 import (
+	"context"
+	"fmt"
+	"reflect"
 	"sync"
 	"time"
 
 	redigo "github.com/gomodule/redigo/redis"
 	"github.com/rickone/athena/config"
+	"github.com/rickone/athena/health"
+	"github.com/rickone/athena/trace"
 )
 
 var (
-	clients = map[string]*RedisClient{}
-	mu      = sync.RWMutex{}
+	clients    = map[string]*RedisClient{}
+	configured = map[string]bool{}
+	mu         = sync.RWMutex{}
+
+	// drainDelay 配置变化后旧连接池延迟关闭，等待正在使用的调用结束
+	drainDelay = 30 * time.Second
 )
 
+func init() {
+	config.OnChange("redis", onConfigChange)
+}
+
 type RedisClient struct {
 	*redigo.Pool
 }
@@ -40,6 +53,32 @@ func (cli *RedisClient) Do(cmd string, args ...interface{}) (interface{}, error)
 	return conn.Do(cmd, args...)
 }
 
+// DoCtx 同Do，ctx中有trace时记录一个客户端span
+func (cli *RedisClient) DoCtx(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	_, span := trace.StartChild(ctx, "redis "+cmd, trace.KindClient)
+	span.SetAttr("db.system", "redis")
+	span.SetAttr("db.operation", cmd)
+
+	reply, err := cli.Do(cmd, args...)
+	if err != redigo.ErrNil {
+		span.SetError(err)
+	}
+	span.End()
+	return reply, err
+}
+
+// Checker 返回PING指定库的健康检查，用于health.Register
+func Checker(name string) health.Checker {
+	return func(ctx context.Context) error {
+		cli := DB(name)
+		if cli == nil {
+			return fmt.Errorf("redis %s not configured", name)
+		}
+		_, err := cli.DoCtx(ctx, "PING")
+		return err
+	}
+}
+
 func dial(network, address, password, db string) (redigo.Conn, error) {
 	c, err := redigo.Dial(network, address)
 	if err != nil {
@@ -70,6 +109,7 @@ func DB(name string) *RedisClient {
 
 func SetDB(name string, cli *RedisClient) {
 	clients[name] = cli
+	delete(configured, name)
 }
 
 func initRedisCli(name string) *RedisClient {
@@ -88,9 +128,41 @@ func initRedisCli(name string) *RedisClient {
 
 	cli = NewRedisClient(conf.GetString("address"), conf.GetString("auth"), conf.GetString("db"))
 	SetDB(name, cli)
+	configured[name] = true
 	return cli
 }
 
+// onConfigChange 配置变化时先按新配置替换客户端，旧连接池在drainDelay后关闭，
+// 配置被删除时移除客户端；通过SetDB注入的客户端不受影响
+func onConfigChange(old, new *config.Value) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for name := range configured {
+		var oldConf, newConf *config.Value
+		if old != nil {
+			oldConf = old.GetValue(name)
+		}
+		if new != nil {
+			newConf = new.GetValue(name)
+		}
+		if reflect.DeepEqual(oldConf, newConf) {
+			continue
+		}
+
+		oldCli := clients[name]
+		if newConf != nil {
+			clients[name] = NewRedisClient(newConf.GetString("address"), newConf.GetString("auth"), newConf.GetString("db"))
+		} else {
+			delete(clients, name)
+			delete(configured, name)
+		}
+		time.AfterFunc(drainDelay, func() {
+			oldCli.Close()
+		})
+	}
+}
+
 func getRedisCli(name string) *RedisClient {
 	mu.RLock()
 	defer mu.RUnlock()
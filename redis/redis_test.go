@@ -0,0 +1,65 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/rickone/athena/config"
+)
+
+func TestConfigChange(t *testing.T) {
+	saved := drainDelay
+	drainDelay = 50 * time.Millisecond
+	defer func() { drainDelay = saved }()
+
+	mr1, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr1.Close()
+	mr2, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr2.Close()
+
+	setConf := func(addr interface{}) {
+		config.UpdateValue("redis", map[interface{}]interface{}{"swap": addr})
+	}
+	setConf(map[interface{}]interface{}{"address": mr1.Addr()})
+	defer setConf(nil)
+
+	old := DB("swap")
+	if old == nil {
+		t.Fatal("client not created")
+	}
+	// 配置变化前取出的连接在变化后仍可使用
+	conn := old.Get()
+	defer conn.Close()
+
+	setConf(map[interface{}]interface{}{"address": mr2.Addr()})
+	cli := DB("swap")
+	if cli == nil || cli == old {
+		t.Fatal("client not replaced")
+	}
+	if _, err := cli.Do("SET", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if !mr2.Exists("k") || mr1.Exists("k") {
+		t.Fatal("new client not using new config")
+	}
+	if _, err := conn.Do("PING"); err != nil {
+		t.Fatalf("old pool closed while in use: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := old.Get().Do("PING"); err == nil {
+		t.Fatal("old pool not closed after drain delay")
+	}
+
+	setConf(nil)
+	if DB("swap") != nil {
+		t.Fatal("client not removed with config")
+	}
+}
@@ -0,0 +1,190 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+// 调试流量路由规则，DB reroute中每个tag一个hash，field为服务名，value为地址，
+// 带有tag的请求调用该服务时改为访问对应地址，见grpcex.RerouteUnaryClientMW
+const (
+	rerouteDB         = "reroute"
+	DefaultRerouteTTL = 24 * time.Hour
+)
+
+type RerouteRule struct {
+	Tag     string
+	Targets map[string]string
+	// TTL 整个tag的剩余有效期，0表示不过期
+	TTL time.Duration
+}
+
+// GetReroute 未配置reroute库或规则不存在时返回空
+func GetReroute(tag string, target string) (string, error) {
+	cli := DB(rerouteDB)
+	if cli == nil {
+		return "", nil
+	}
+
+	addr, err := redigo.String(cli.Do("HGET", tag, target))
+	if err == redigo.ErrNil {
+		return "", nil
+	}
+	return addr, err
+}
+
+// SetReroute 设置规则并将整个tag的有效期重置为ttl，ttl为0时不过期
+func SetReroute(tag string, target string, addr string, ttl time.Duration) error {
+	cli, err := rerouteCli()
+	if err != nil {
+		return err
+	}
+
+	if _, err := cli.Do("HSET", tag, target, addr); err != nil {
+		return err
+	}
+	if ttl > 0 {
+		_, err = cli.Do("PEXPIRE", tag, ttl.Milliseconds())
+	} else {
+		_, err = cli.Do("PERSIST", tag)
+	}
+	return err
+}
+
+// ExpireReroute target为空时删除整个tag
+func ExpireReroute(tag string, target string) error {
+	cli, err := rerouteCli()
+	if err != nil {
+		return err
+	}
+
+	if target == "" {
+		_, err = cli.Do("DEL", tag)
+	} else {
+		_, err = cli.Do("HDEL", tag, target)
+	}
+	return err
+}
+
+// ListReroute 按tag排序返回全部规则
+func ListReroute() ([]*RerouteRule, error) {
+	cli, err := rerouteCli()
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	cursor := 0
+	for {
+		reply, err := redigo.Values(cli.Do("SCAN", cursor, "COUNT", 100))
+		if err != nil {
+			return nil, err
+		}
+		keys, err := redigo.Strings(reply[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, keys...)
+
+		if cursor, err = redigo.Int(reply[0], nil); err != nil {
+			return nil, err
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	sort.Strings(tags)
+
+	rules := make([]*RerouteRule, 0, len(tags))
+	for _, tag := range tags {
+		targets, err := redigo.StringMap(cli.Do("HGETALL", tag))
+		if err != nil {
+			return nil, err
+		}
+		// 列出期间过期的tag忽略
+		if len(targets) == 0 {
+			continue
+		}
+		ttl, err := redigo.Int64(cli.Do("PTTL", tag))
+		if err != nil {
+			return nil, err
+		}
+		if ttl < 0 {
+			ttl = 0
+		}
+		rules = append(rules, &RerouteRule{Tag: tag, Targets: targets, TTL: time.Duration(ttl) * time.Millisecond})
+	}
+	return rules, nil
+}
+
+func rerouteCli() (*RedisClient, error) {
+	cli := DB(rerouteDB)
+	if cli == nil {
+		return nil, fmt.Errorf("redis.%s not configured", rerouteDB)
+	}
+	return cli, nil
+}
+
+// RerouteAdminHandler 管理规则的HTTP接口:
+//   GET                                            列出全部规则
+//   PUT    ?tag=alice&target=user&addr=10.0.0.8:8001&ttl=2h   ttl默认24h，0表示不过期
+//   DELETE ?tag=alice[&target=user]                删除服务或整个tag的规则
+func RerouteAdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		tag, target := query.Get("tag"), query.Get("target")
+
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			var rules []*RerouteRule
+			if rules, err = ListReroute(); err == nil {
+				views := make([]map[string]interface{}, len(rules))
+				for i, rule := range rules {
+					views[i] = map[string]interface{}{
+						"tag":     rule.Tag,
+						"targets": rule.Targets,
+						"ttl":     rule.TTL.Round(time.Second).String(),
+					}
+				}
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				json.NewEncoder(w).Encode(views)
+				return
+			}
+		case http.MethodPut, http.MethodPost:
+			addr := query.Get("addr")
+			if tag == "" || target == "" || addr == "" {
+				http.Error(w, "tag, target and addr are required", http.StatusBadRequest)
+				return
+			}
+			ttl := DefaultRerouteTTL
+			if s := query.Get("ttl"); s != "" {
+				if ttl, err = time.ParseDuration(s); err != nil || ttl < 0 {
+					http.Error(w, fmt.Sprintf("invalid ttl %q", s), http.StatusBadRequest)
+					return
+				}
+			}
+			err = SetReroute(tag, target, addr, ttl)
+		case http.MethodDelete:
+			if tag == "" {
+				http.Error(w, "tag is required", http.StatusBadRequest)
+				return
+			}
+			err = ExpireReroute(tag, target)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
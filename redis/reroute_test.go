@@ -0,0 +1,138 @@
+package redis
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func setupReroute(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDB(rerouteDB, NewRedisClient(mr.Addr(), "", "0"))
+	t.Cleanup(func() {
+		SetDB(rerouteDB, nil)
+		mr.Close()
+	})
+	return mr
+}
+
+func TestReroute(t *testing.T) {
+	mr := setupReroute(t)
+
+	if err := SetReroute("bob", "order", "10.0.0.9:8002", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetReroute("alice", "user", "10.0.0.8:8001", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetReroute("alice", "order", "10.0.0.8:8002", 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if addr, err := GetReroute("alice", "user"); err != nil || addr != "10.0.0.8:8001" {
+		t.Fatalf("unexpected addr %q, %v", addr, err)
+	}
+	if addr, err := GetReroute("alice", "wallet"); err != nil || addr != "" {
+		t.Fatalf("unexpected addr %q, %v", addr, err)
+	}
+
+	rules, err := ListReroute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].Tag != "alice" || len(rules[0].Targets) != 2 || rules[1].Tag != "bob" {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+	// 设置时重置整个tag的有效期，0表示不过期
+	if rules[0].TTL != 2*time.Hour || rules[1].TTL != 0 {
+		t.Fatalf("unexpected ttl: %v, %v", rules[0].TTL, rules[1].TTL)
+	}
+
+	if err := ExpireReroute("alice", "user"); err != nil {
+		t.Fatal(err)
+	}
+	if addr, _ := GetReroute("alice", "user"); addr != "" {
+		t.Fatalf("target not expired: %q", addr)
+	}
+	if err := ExpireReroute("bob", ""); err != nil {
+		t.Fatal(err)
+	}
+	if rules, _ = ListReroute(); len(rules) != 1 || rules[0].Tag != "alice" {
+		t.Fatalf("tag not expired: %+v", rules)
+	}
+
+	mr.FastForward(3 * time.Hour)
+	if rules, _ = ListReroute(); len(rules) != 0 {
+		t.Fatalf("ttl not applied: %+v", rules)
+	}
+}
+
+func TestRerouteNotConfigured(t *testing.T) {
+	SetDB(rerouteDB, nil)
+
+	if addr, err := GetReroute("alice", "user"); err != nil || addr != "" {
+		t.Fatalf("unexpected addr %q, %v", addr, err)
+	}
+	if err := SetReroute("alice", "user", "10.0.0.8:8001", time.Hour); err == nil {
+		t.Fatal("expected error without reroute db")
+	}
+}
+
+func TestRerouteAdminHandler(t *testing.T) {
+	setupReroute(t)
+	h := RerouteAdminHandler()
+
+	do := func(method, query string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, "/reroute"+query, nil))
+		return w
+	}
+
+	if w := do(http.MethodPut, "?tag=alice&target=user&addr=10.0.0.8:8001&ttl=2h"); w.Code != http.StatusNoContent {
+		t.Fatalf("put: %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPost, "?tag=alice&target=order&addr=10.0.0.8:8002&ttl=2h"); w.Code != http.StatusNoContent {
+		t.Fatalf("post: %d %s", w.Code, w.Body)
+	}
+	for _, query := range []string{"?tag=alice&target=user", "?tag=alice&target=user&addr=a:1&ttl=-1s", "?tag=alice&target=user&addr=a:1&ttl=abc"} {
+		if w := do(http.MethodPut, query); w.Code != http.StatusBadRequest {
+			t.Fatalf("put %s: %d", query, w.Code)
+		}
+	}
+
+	w := do(http.MethodGet, "")
+	var views []struct {
+		Tag     string
+		Targets map[string]string
+		TTL     string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &views); err != nil {
+		t.Fatal(err)
+	}
+	if len(views) != 1 || views[0].Tag != "alice" || views[0].Targets["order"] != "10.0.0.8:8002" || views[0].TTL != "2h0m0s" {
+		t.Fatalf("unexpected list: %s", w.Body)
+	}
+
+	if w := do(http.MethodDelete, ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("delete without tag: %d", w.Code)
+	}
+	if w := do(http.MethodDelete, "?tag=alice&target=user"); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d %s", w.Code, w.Body)
+	}
+	if addr, _ := GetReroute("alice", "user"); addr != "" {
+		t.Fatalf("target not deleted: %q", addr)
+	}
+	if w := do(http.MethodPatch, ""); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("patch: %d", w.Code)
+	}
+}
@@ -0,0 +1,71 @@
+package trace
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rickone/athena/config"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+	ExporterOTLP   = "otlp"
+)
+
+// Config 对应配置中的trace段:
+//   trace:
+//     exporter: otlp                     # none(默认)|stdout|file|otlp
+//     file: ./trace.log                  # exporter为file时使用
+//     endpoint: http://127.0.0.1:4318    # exporter为otlp时使用
+//     sample_ratio: 0.1                  # 新建trace的采样率，默认1
+type Config struct {
+	Exporter    string `default:"none"`
+	File        string
+	Endpoint    string
+	Headers     map[string]string
+	SampleRatio float64 `default:"1"`
+}
+
+// Init 按配置的trace段设置导出器，service为导出时附带的服务名
+func Init(service string) error {
+	var conf Config
+	if err := config.Unmarshal(&conf, "trace"); err != nil {
+		return err
+	}
+
+	exporter, err := NewExporter(&conf)
+	if err != nil {
+		return err
+	}
+
+	SetServiceName(service)
+	SetSampleRatio(conf.SampleRatio)
+	SetExporter(exporter)
+	return nil
+}
+
+// NewExporter 按配置创建导出器，none时返回nil
+func NewExporter(conf *Config) (Exporter, error) {
+	switch conf.Exporter {
+	case ExporterNone, "":
+		return nil, nil
+	case ExporterStdout:
+		return NewWriterExporter(os.Stdout), nil
+	case ExporterFile:
+		if conf.File == "" {
+			return nil, fmt.Errorf("trace: exporter file requires trace.file")
+		}
+		return NewFileExporter(conf.File)
+	case ExporterOTLP:
+		if conf.Endpoint == "" {
+			return nil, fmt.Errorf("trace: exporter otlp requires trace.endpoint")
+		}
+		e := NewOTLPExporter(conf.Endpoint)
+		e.Headers = conf.Headers
+		return e, nil
+	default:
+		return nil, fmt.Errorf("trace: unknown exporter %q", conf.Exporter)
+	}
+}
@@ -0,0 +1,293 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	batchSize     = 512
+	batchQueue    = 4096
+	batchInterval = time.Second
+)
+
+// Exporter 导出结束的span，由批处理协程串行调用
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []*SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+var (
+	global   *batcher
+	globalMu = sync.RWMutex{}
+
+	serviceName string
+	sampleRatio = 1.0
+)
+
+// SetServiceName 导出时附带的服务名
+func SetServiceName(name string) {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	serviceName = name
+}
+
+func ServiceName() string {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	return serviceName
+}
+
+// SetSampleRatio 新建trace的采样率，有父节点时沿用父节点的采样标记
+func SetSampleRatio(ratio float64) {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	sampleRatio = ratio
+}
+
+func shouldSample() bool {
+	globalMu.RLock()
+	ratio := sampleRatio
+	globalMu.RUnlock()
+
+	if ratio >= 1 {
+		return true
+	}
+	if ratio <= 0 {
+		return false
+	}
+	idRandMu.Lock()
+	defer idRandMu.Unlock()
+	return idRand.Float64() < ratio
+}
+
+// SetExporter 替换导出器，旧的导出器刷新剩余span后关闭，nil表示不导出
+func SetExporter(e Exporter) {
+	var b *batcher
+	if e != nil {
+		b = newBatcher(e)
+	}
+
+	globalMu.Lock()
+	old := global
+	global = b
+	globalMu.Unlock()
+
+	old.shutdown(context.Background())
+}
+
+// Shutdown 导出剩余span并关闭导出器，进程退出前调用
+func Shutdown(ctx context.Context) error {
+	globalMu.Lock()
+	old := global
+	global = nil
+	globalMu.Unlock()
+
+	return old.shutdown(ctx)
+}
+
+func export(data *SpanData) {
+	globalMu.RLock()
+	b := global
+	globalMu.RUnlock()
+
+	b.enqueue(data)
+}
+
+// batcher 缓存span并按数量或时间批量导出，队列满时丢弃
+type batcher struct {
+	exporter Exporter
+	queue    chan *SpanData
+	flush    chan chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newBatcher(e Exporter) *batcher {
+	b := &batcher{
+		exporter: e,
+		queue:    make(chan *SpanData, batchQueue),
+		flush:    make(chan chan struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *batcher) enqueue(data *SpanData) {
+	if b == nil || b.exporter == nil {
+		return
+	}
+
+	select {
+	case b.queue <- data:
+	case <-b.stop:
+	default:
+	}
+}
+
+// Flush 导出已结束的span
+func Flush() {
+	globalMu.RLock()
+	b := global
+	globalMu.RUnlock()
+
+	if b == nil || b.exporter == nil {
+		return
+	}
+
+	ch := make(chan struct{})
+	select {
+	case b.flush <- ch:
+		<-ch
+	case <-b.done:
+	}
+}
+
+func (b *batcher) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+
+	var spans []*SpanData
+	send := func() {
+		if len(spans) == 0 {
+			return
+		}
+		if err := b.exporter.ExportSpans(context.Background(), spans); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"spans": len(spans),
+				"err":   err.Error(),
+			}).Error("Trace export failed")
+		}
+		spans = nil
+	}
+	drain := func() {
+		for {
+			select {
+			case data := <-b.queue:
+				spans = append(spans, data)
+				if len(spans) >= batchSize {
+					send()
+				}
+			default:
+				send()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case data := <-b.queue:
+			spans = append(spans, data)
+			if len(spans) >= batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case ch := <-b.flush:
+			drain()
+			close(ch)
+		case <-b.stop:
+			drain()
+			return
+		}
+	}
+}
+
+func (b *batcher) shutdown(ctx context.Context) error {
+	if b == nil || b.exporter == nil {
+		return nil
+	}
+
+	b.once.Do(func() { close(b.stop) })
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return b.exporter.Shutdown(ctx)
+}
+
+// spanJSON stdout/file导出的格式，每行一个span
+type spanJSON struct {
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	Service    string                 `json:"service,omitempty"`
+	Name       string                 `json:"name"`
+	Kind       Kind                   `json:"kind"`
+	Start      time.Time              `json:"start"`
+	DurationUs int64                  `json:"duration_us"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Status     StatusCode             `json:"status,omitempty"`
+	Message    string                 `json:"message,omitempty"`
+}
+
+// WriterExporter 以JSON行写入w
+type WriterExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+// NewFileExporter 追加写入文件
+func NewFileExporter(path string) (*WriterExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &WriterExporter{w: f, closer: f}, nil
+}
+
+func (e *WriterExporter) ExportSpans(ctx context.Context, spans []*SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	enc := json.NewEncoder(e.w)
+	for _, data := range spans {
+		s := spanJSON{
+			TraceID:    data.TraceID.String(),
+			SpanID:     data.SpanID.String(),
+			Service:    data.Service,
+			Name:       data.Name,
+			Kind:       data.Kind,
+			Start:      data.Start,
+			DurationUs: data.End.Sub(data.Start).Microseconds(),
+			Attributes: data.Attributes,
+			Status:     data.StatusCode,
+			Message:    data.StatusMessage,
+		}
+		if data.Parent.IsValid() {
+			s.ParentID = data.Parent.String()
+		}
+		if err := enc.Encode(&s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *WriterExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+	return nil
+}
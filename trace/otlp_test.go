@@ -0,0 +1,116 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// collector OTLP/HTTP collector的替身，记录收到的请求
+type collector struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []map[string]interface{}
+	headers  []http.Header
+}
+
+func newCollector() *collector {
+	c := &collector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != otlpTracesPath || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var req map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		c.mu.Lock()
+		c.requests = append(c.requests, req)
+		c.headers = append(c.headers, r.Header)
+		c.mu.Unlock()
+		w.Write([]byte("{}"))
+	}))
+	return c
+}
+
+func TestOTLPExporter(t *testing.T) {
+	c := newCollector()
+	defer c.Close()
+
+	exporter, err := NewExporter(&Config{
+		Exporter: ExporterOTLP,
+		Endpoint: c.URL + "/",
+		Headers:  map[string]string{"Authorization": "Bearer token"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	SetServiceName("user")
+	SetExporter(exporter)
+	ctx, root := Start(context.Background(), "GET /user/:id", KindServer)
+	root.SetAttr("http.status_code", 200)
+	_, child := Start(ctx, "SELECT users", KindClient)
+	child.SetStatus(StatusError, "record not found")
+	child.End()
+	root.End()
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(c.requests))
+	}
+	if c.headers[0].Get("Authorization") != "Bearer token" {
+		t.Fatalf("missing header: %v", c.headers[0])
+	}
+
+	data, _ := json.Marshal(c.requests[0])
+	var req otlpExportRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.ResourceSpans) != 1 {
+		t.Fatalf("unexpected request: %s", data)
+	}
+
+	rs := req.ResourceSpans[0]
+	if attr := rs.Resource.Attributes[0]; attr.Key != "service.name" || *attr.Value.StringValue != "user" {
+		t.Fatalf("unexpected resource: %s", data)
+	}
+
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("unexpected spans: %s", data)
+	}
+	s, r := spans[0], spans[1]
+	if s.ParentSpanID != r.SpanID || s.TraceID != r.TraceID || s.Status.Code != StatusError || s.Kind != KindClient {
+		t.Fatalf("unexpected child span: %s", data)
+	}
+	if len(r.Attributes) != 1 || *r.Attributes[0].Value.IntValue != "200" || r.ParentSpanID != "" {
+		t.Fatalf("unexpected root span: %s", data)
+	}
+}
+
+func TestOTLPExporterError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	exporter := NewOTLPExporter(server.URL)
+	err := exporter.ExportSpans(context.Background(), []*SpanData{{Name: "x"}})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,347 @@
+package trace
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind 与OTLP的SpanKind取值一致
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+	KindProducer Kind = 4
+	KindConsumer Kind = 5
+)
+
+// StatusCode 与OTLP的Status.Code取值一致
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOk    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// TraceparentHeader W3C Trace Context的请求头，gRPC metadata中使用小写形式
+const TraceparentHeader = "traceparent"
+
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext 跨进程传递的部分
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent 格式化为 00-<trace-id>-<span-id>-<flags>
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent 解析W3C traceparent，版本ff及全零ID视为无效
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("invalid traceparent version %q", parts[0])
+	}
+
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || len(version) != 1 {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+
+	sc.Sampled = flags[0]&0x01 == 1
+	return sc, nil
+}
+
+// SpanData 结束后交给Exporter的数据
+type SpanData struct {
+	SpanContext
+	Parent        SpanID
+	Name          string
+	Kind          Kind
+	Start         time.Time
+	End           time.Time
+	Attributes    map[string]interface{}
+	StatusCode    StatusCode
+	StatusMessage string
+	Service       string
+}
+
+// Span nil表示不记录，所有方法均可安全调用
+type Span struct {
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes[key] = value
+}
+
+// SetError 记录错误并将状态置为Error，err为nil时忽略
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.StatusCode = StatusError
+	s.data.StatusMessage = err.Error()
+}
+
+func (s *Span) SetStatus(code StatusCode, msg string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.StatusCode = code
+	s.data.StatusMessage = msg
+}
+
+// End 结束并导出，重复调用无效
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	data.Attributes = make(map[string]interface{}, len(s.data.Attributes))
+	for k, v := range s.data.Attributes {
+		data.Attributes[k] = v
+	}
+	s.mu.Unlock()
+
+	if data.Sampled {
+		export(&data)
+	}
+}
+
+// GinKey gin中以c.Set(trace.GinKey, span)保存当前span，*gin.Context.Value按字符串键读取c.Keys
+const GinKey = "trace.span"
+
+type spanCtxKey struct{}
+type remoteCtxKey struct{}
+
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanCtxKey{}, span)
+}
+
+// ContextWithRemote 设置来自上游的SpanContext，之后Start的span以其为父节点
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteCtxKey{}, sc)
+}
+
+func FromContext(ctx context.Context) *Span {
+	if span, ok := ctx.Value(spanCtxKey{}).(*Span); ok {
+		return span
+	}
+	span, _ := ctx.Value(GinKey).(*Span)
+	return span
+}
+
+// SpanContextFromContext 优先返回本进程的span，其次为上游传入的
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if span := FromContext(ctx); span != nil {
+		return span.SpanContext(), true
+	}
+	sc, ok := ctx.Value(remoteCtxKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// Start 开始span，ctx中没有父节点时按采样率新建trace，用于服务端、消费者等入口
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent, ok := SpanContextFromContext(ctx)
+	return start(ctx, name, kind, parent, ok)
+}
+
+// StartChild 只在ctx中已有span时开始子span，否则返回nil，用于redis、mysql等客户端调用
+func StartChild(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent, ok := SpanContextFromContext(ctx)
+	if !ok {
+		return ctx, nil
+	}
+	return start(ctx, name, kind, parent, true)
+}
+
+func start(ctx context.Context, name string, kind Kind, parent SpanContext, hasParent bool) (context.Context, *Span) {
+	span := &Span{
+		data: SpanData{
+			Name:       name,
+			Kind:       kind,
+			Start:      time.Now(),
+			Attributes: map[string]interface{}{},
+			Service:    ServiceName(),
+		},
+	}
+
+	if hasParent {
+		span.data.TraceID = parent.TraceID
+		span.data.Parent = parent.SpanID
+		span.data.Sampled = parent.Sampled
+	} else {
+		span.data.TraceID = newTraceID()
+		span.data.Sampled = shouldSample()
+	}
+	span.data.SpanID = newSpanID()
+
+	return ContextWithSpan(ctx, span), span
+}
+
+// Inject 返回当前span的traceparent，没有span时返回""
+func Inject(ctx context.Context) string {
+	sc, ok := SpanContextFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return sc.Traceparent()
+}
+
+// Extract 解析上游的traceparent并设置到ctx，无效时返回原ctx
+func Extract(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+
+	sc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		return ctx
+	}
+	return ContextWithRemote(ctx, sc)
+}
+
+// InjectHeader 将traceparent写入发出的HTTP请求头
+func InjectHeader(ctx context.Context, header http.Header) {
+	if tp := Inject(ctx); tp != "" {
+		header.Set(TraceparentHeader, tp)
+	}
+}
+
+// ExtractHeader 从收到的HTTP请求头解析traceparent
+func ExtractHeader(ctx context.Context, header http.Header) context.Context {
+	return Extract(ctx, header.Get(TraceparentHeader))
+}
+
+// LogFields 附加到日志的trace_id和span_id，没有span时返回nil
+func LogFields(ctx context.Context) map[string]interface{} {
+	sc, ok := SpanContextFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return map[string]interface{}{
+		"trace_id": sc.TraceID.String(),
+		"span_id":  sc.SpanID.String(),
+	}
+}
+
+var (
+	idRand   *rand.Rand
+	idRandMu = sync.Mutex{}
+)
+
+func init() {
+	var seed int64
+	binary.Read(crand.Reader, binary.LittleEndian, &seed)
+	idRand = rand.New(rand.NewSource(seed))
+}
+
+func newTraceID() TraceID {
+	idRandMu.Lock()
+	defer idRandMu.Unlock()
+
+	var id TraceID
+	for !id.IsValid() {
+		idRand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	idRandMu.Lock()
+	defer idRandMu.Unlock()
+
+	var id SpanID
+	for !id.IsValid() {
+		idRand.Read(id[:])
+	}
+	return id
+}
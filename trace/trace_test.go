@@ -0,0 +1,161 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// memExporter 记录导出的span
+type memExporter struct {
+	mu    sync.Mutex
+	spans []*SpanData
+}
+
+func (e *memExporter) ExportSpans(ctx context.Context, spans []*SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func (e *memExporter) Spans() []*SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*SpanData(nil), e.spans...)
+}
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || !sc.Sampled {
+		t.Fatalf("unexpected span context: %+v", sc)
+	}
+	if s := sc.Traceparent(); s != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Fatalf("unexpected traceparent: %s", s)
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	}
+	for _, s := range invalid {
+		if _, err := ParseTraceparent(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+
+	// 未来版本允许附加字段
+	if _, err := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestStartAndExport(t *testing.T) {
+	exporter := &memExporter{}
+	SetServiceName("test")
+	SetExporter(exporter)
+	defer SetExporter(nil)
+
+	// 子调用没有父节点时不记录
+	if _, span := StartChild(context.Background(), "redis GET", KindClient); span != nil {
+		t.Fatal("StartChild without parent should return nil")
+	}
+
+	upstream := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx := Extract(context.Background(), upstream)
+	ctx, server := Start(ctx, "/user.User/Get", KindServer)
+	_, client := StartChild(ctx, "redis GET", KindClient)
+	client.SetAttr("db.system", "redis")
+	client.SetError(errors.New("timeout"))
+	client.End()
+	server.End()
+	server.End()
+
+	if fields := LogFields(ctx); fields["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || fields["span_id"] != server.SpanContext().SpanID.String() {
+		t.Fatalf("unexpected log fields: %v", fields)
+	}
+	if Inject(ctx) != server.SpanContext().Traceparent() {
+		t.Fatalf("unexpected inject: %s", Inject(ctx))
+	}
+
+	Flush()
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+
+	c, s := spans[0], spans[1]
+	if s.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || s.Parent.String() != "00f067aa0ba902b7" || s.Service != "test" {
+		t.Fatalf("unexpected server span: %+v", s)
+	}
+	if c.TraceID != s.TraceID || c.Parent != s.SpanID || c.StatusCode != StatusError || c.Attributes["db.system"] != "redis" {
+		t.Fatalf("unexpected client span: %+v", c)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	exporter := &memExporter{}
+	SetExporter(exporter)
+	SetSampleRatio(0)
+	defer func() {
+		SetSampleRatio(1)
+		SetExporter(nil)
+	}()
+
+	// 未采样的trace仍生成ID并向下游传递
+	ctx, span := Start(context.Background(), "root", KindServer)
+	if span.SpanContext().Sampled || Inject(ctx) == "" {
+		t.Fatalf("unexpected span context: %+v", span.SpanContext())
+	}
+	span.End()
+
+	// 上游已采样时沿用
+	ctx = Extract(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, span = Start(ctx, "child", KindServer)
+	span.End()
+
+	Flush()
+	if spans := exporter.Spans(); len(spans) != 1 || spans[0].Name != "child" {
+		t.Fatalf("unexpected spans: %+v", spans)
+	}
+}
+
+func TestWriterExporter(t *testing.T) {
+	var buf bytes.Buffer
+	SetExporter(NewWriterExporter(&buf))
+
+	ctx, root := Start(context.Background(), "root", KindServer)
+	_, child := Start(ctx, "child", KindInternal)
+	child.End()
+	root.End()
+	SetExporter(nil)
+
+	var lines []spanJSON
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var s spanJSON
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, s)
+	}
+	if len(lines) != 2 || lines[0].Name != "child" || lines[0].ParentID != lines[1].SpanID || lines[1].ParentID != "" {
+		t.Fatalf("unexpected output: %s", buf.String())
+	}
+}